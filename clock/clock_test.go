@@ -0,0 +1,61 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	fc := NewFake()
+	start := fc.Now()
+	t1 := fc.NewTimer(time.Minute)
+	t2 := fc.NewTimer(time.Second)
+	if n := fc.Pending(); n != 2 {
+		t.Fatalf("pending %d expected 2", n)
+	}
+
+	fc.Advance(30 * time.Second)
+	select {
+	case at := <-t2.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("Timer fired at %v expected %v", at, start.Add(time.Second))
+		}
+	default:
+		t.Fatalf("Timer didn't fire")
+	}
+	select {
+	case <-t1.C():
+		t.Fatalf("Timer fired early")
+	default:
+	}
+	if !t1.Stop() || t1.Stop() {
+		t.Errorf("Unexpected Stop result")
+	}
+	fc.Advance(time.Hour)
+	select {
+	case <-t1.C():
+		t.Fatalf("Stopped timer fired")
+	default:
+	}
+	if !fc.Now().Equal(start.Add(time.Hour + 30*time.Second)) {
+		t.Errorf("Now %v after advancing", fc.Now())
+	}
+}
@@ -0,0 +1,128 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when advanced, for tests.
+type Fake struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	c      chan time.Time
+	at     time.Time
+	active bool
+}
+
+// NewFake returns a fake clock.
+func NewFake() *Fake {
+	return &Fake{now: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)}
+}
+
+func (fc *Fake) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: fc, c: make(chan time.Time, 1)}
+	fc.lock.Lock()
+	fc.timers = append(fc.timers, t)
+	fc.lock.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d firing the timers that expire on the
+// way in order.
+func (fc *Fake) Advance(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	end := fc.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range fc.timers {
+			if t.active && !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		fc.now = next.at
+		next.fire()
+	}
+	fc.now = end
+}
+
+// Pending returns the number of active timers, i.e., the number of things
+// waiting on the clock.
+func (fc *Fake) Pending() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	n := 0
+	for _, t := range fc.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// fire expires the timer, called with the clock locked. Like time.Timer the
+// expiry is dropped if the last one hasn't been received.
+func (t *fakeTimer) fire() {
+	t.active = false
+	select {
+	case t.c <- t.clock.now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.active
+	t.at = t.clock.now.Add(d)
+	t.active = true
+	if d <= 0 {
+		t.fire()
+	}
+	return active
+}
@@ -0,0 +1,329 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	dbusName         = "org.imapidle"
+	dbusPath         = dbus.ObjectPath("/org/imapidle")
+	dbusManagerIface = "org.imapidle.Manager"
+	dbusAccountIface = "org.imapidle.Account"
+	dbusPropsIface   = "org.freedesktop.DBus.Properties"
+)
+
+// dbusService exports the accounts of a dispatcher on D-Bus. It is sent the
+// dispatcher's events (as one of its Streams) to update the properties and
+// emit the signals.
+type dbusService struct {
+	conn     *dbus.Conn
+	accounts map[string]*dbusAccount
+}
+
+// dbusAccount is the object exported for an account.
+type dbusAccount struct {
+	a     *watcher.Account
+	d     *Dispatcher
+	conn  *dbus.Conn
+	path  dbus.ObjectPath
+	props *dbusProps
+}
+
+// dbusProps implements org.freedesktop.DBus.Properties for the read-only
+// properties of an account.
+type dbusProps struct {
+	lock   sync.Mutex
+	values map[string]interface{}
+}
+
+// The account properties in introspection order with their initial values.
+var dbusAccountProps = []struct {
+	name  string
+	value interface{}
+}{
+	{"State", ""},
+	{"UnreadCount", uint32(0)}, // unseen messages in INBOX
+	{"LastUpdate", int64(0)},   // Unix time, 0 if never
+	{"LastError", ""},
+}
+
+// dbusManager is the object exported at dbusPath.
+type dbusManager struct {
+	s *dbusService
+}
+
+// dbusAccountPath returns the object path for a store, anything but ASCII
+// letters and digits is escaped as _XX.
+func dbusAccountPath(name string) dbus.ObjectPath {
+	var b []byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b = append(b, c)
+		} else {
+			b = append(b, fmt.Sprintf("_%02x", c)...)
+		}
+	}
+	if len(b) == 0 {
+		b = []byte("_")
+	}
+	return dbusPath + "/account/" + dbus.ObjectPath(b)
+}
+
+var dbusAccountSignals = []introspect.Signal{
+	{Name: "NewMail", Args: []introspect.Arg{
+		{Name: "class", Type: "s"}, {Name: "new", Type: "u"}, {Name: "messages", Type: "u"},
+	}},
+	{Name: "FullUpdate"},
+	{Name: "StateChanged", Args: []introspect.Arg{
+		{Name: "state", Type: "s"}, {Name: "error", Type: "s"},
+	}},
+}
+
+// newDBusService exports the accounts of d on conn and takes the service
+// name.
+func newDBusService(conn *dbus.Conn, d *Dispatcher) (*dbusService, error) {
+	s := &dbusService{conn: conn, accounts: make(map[string]*dbusAccount)}
+	for name, a := range d.Accounts {
+		da := &dbusAccount{
+			a:     a,
+			d:     d,
+			conn:  conn,
+			path:  dbusAccountPath(name),
+			props: &dbusProps{values: make(map[string]interface{})},
+		}
+		var props []introspect.Property
+		for _, p := range dbusAccountProps {
+			da.props.values[p.name] = p.value
+			props = append(props, introspect.Property{
+				Name:   p.name,
+				Type:   dbus.SignatureOf(p.value).String(),
+				Access: "read",
+			})
+		}
+		stats := a.Stats()
+		da.props.values["State"] = stats.State.String()
+		da.props.values["LastError"] = stats.LastError
+		if c, ok := stats.Counts["INBOX"]; ok && a.Primary() == "" {
+			da.props.values["UnreadCount"] = uint32(c.Unseen)
+		}
+
+		if err := conn.Export(da, da.path, dbusAccountIface); err != nil {
+			return nil, err
+		}
+		if err := conn.Export(da.props, da.path, dbusPropsIface); err != nil {
+			return nil, err
+		}
+		node := &introspect.Node{
+			Name: string(da.path),
+			Interfaces: []introspect.Interface{
+				introspect.IntrospectData,
+				{
+					Name:    dbusPropsIface,
+					Methods: introspect.Methods(da.props),
+					Signals: []introspect.Signal{{Name: "PropertiesChanged", Args: []introspect.Arg{
+						{Name: "interface", Type: "s"},
+						{Name: "changed_properties", Type: "a{sv}"},
+						{Name: "invalidated_properties", Type: "as"},
+					}}},
+				},
+				{
+					Name:       dbusAccountIface,
+					Methods:    introspect.Methods(da),
+					Properties: props,
+					Signals:    dbusAccountSignals,
+				},
+			},
+		}
+		if err := conn.Export(introspect.NewIntrospectable(node), da.path,
+			"org.freedesktop.DBus.Introspectable"); err != nil {
+			return nil, err
+		}
+		s.accounts[name] = da
+	}
+
+	m := dbusManager{s}
+	if err := conn.Export(m, dbusPath, dbusManagerIface); err != nil {
+		return nil, err
+	}
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{Name: dbusManagerIface, Methods: introspect.Methods(m)},
+		},
+	}
+	for _, da := range s.accounts {
+		node.Children = append(node.Children, introspect.Node{Name: string(da.path[len(dbusPath)+1:])})
+	}
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+	if err := conn.Export(introspect.NewIntrospectable(node), dbusPath,
+		"org.freedesktop.DBus.Introspectable"); err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("%s is already taken", dbusName)
+	}
+	return s, nil
+}
+
+// ListAccounts returns the object path of each store.
+func (m dbusManager) ListAccounts() (map[string]dbus.ObjectPath, *dbus.Error) {
+	paths := make(map[string]dbus.ObjectPath)
+	for name, da := range m.s.accounts {
+		paths[name] = da.path
+	}
+	return paths, nil
+}
+
+// Sync runs the update script for the store.
+func (da *dbusAccount) Sync() *dbus.Error {
+	if err := da.d.Sync(da.a.Name); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// Reconnect reconnects the store now.
+func (da *dbusAccount) Reconnect() *dbus.Error {
+	da.a.Reconnect()
+	return nil
+}
+
+// Pause takes the store offline, or brings it back.
+func (da *dbusAccount) Pause(paused bool) *dbus.Error {
+	da.a.Pause(paused)
+	return nil
+}
+
+func (p *dbusProps) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	if iface != dbusAccountIface {
+		return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("unknown interface %s", iface))
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := p.values[name]
+	if !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("unknown property %s", name))
+	}
+	return dbus.MakeVariant(v), nil
+}
+
+func (p *dbusProps) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if iface != dbusAccountIface {
+		return nil, dbus.MakeFailedError(fmt.Errorf("unknown interface %s", iface))
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	all := make(map[string]dbus.Variant)
+	for name, v := range p.values {
+		all[name] = dbus.MakeVariant(v)
+	}
+	return all, nil
+}
+
+func (p *dbusProps) Set(iface, name string, v dbus.Variant) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("property %s is read-only", name))
+}
+
+// set sets properties, emitting PropertiesChanged for those that changed.
+func (da *dbusAccount) set(values map[string]interface{}) error {
+	changed := make(map[string]dbus.Variant)
+	da.props.lock.Lock()
+	for name, v := range values {
+		if da.props.values[name] != v {
+			da.props.values[name] = v
+			changed[name] = dbus.MakeVariant(v)
+		}
+	}
+	da.props.lock.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	return da.conn.Emit(da.path, dbusPropsIface+".PropertiesChanged", dbusAccountIface, changed, []string{})
+}
+
+func (da *dbusAccount) emit(signal string, args ...interface{}) error {
+	return da.conn.Emit(da.path, dbusAccountIface+"."+signal, args...)
+}
+
+// Send updates the properties and emits the signals for an event, it's only
+// called from the dispatcher's sink.Queue.
+func (s *dbusService) Send(e *sink.Event) error {
+	if e.Type == sink.UpdateRun {
+		var updated []*dbusAccount
+		if e.Full {
+			for _, da := range s.accounts {
+				updated = append(updated, da)
+			}
+		}
+		for _, name := range e.Stores {
+			if da := s.accounts[name]; da != nil {
+				updated = append(updated, da)
+			}
+		}
+		for _, da := range updated {
+			if err := da.set(map[string]interface{}{"LastUpdate": e.Time.Unix()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	da := s.accounts[e.Store]
+	if da == nil {
+		return nil
+	}
+	switch e.Type {
+	case sink.NewMail:
+		return da.emit("NewMail", e.Class, uint32(e.New), uint32(e.Messages))
+	case sink.Counts:
+		if e.Mailbox == "INBOX" {
+			return da.set(map[string]interface{}{"UnreadCount": uint32(e.Unseen)})
+		}
+	case sink.FullUpdate:
+		return da.emit("FullUpdate")
+	case sink.State:
+		if err := da.set(map[string]interface{}{"State": e.State, "LastError": e.Error}); err != nil {
+			return err
+		}
+		return da.emit("StateChanged", e.State, e.Error)
+	}
+	return nil
+}
+
+func (s *dbusService) Close() error {
+	return s.conn.Close()
+}
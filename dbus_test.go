@@ -0,0 +1,196 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/godbus/dbus/v5"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// privateBus starts a dbus-daemon for the test returning its address.
+func privateBus(t *testing.T) string {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := ioutil.WriteFile(config, []byte(fmt.Sprintf(busConfig, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(path, "--config-file="+config, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+func busConn(t *testing.T, addr string) *dbus.Conn {
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDBusAccountPath(t *testing.T) {
+	for name, want := range map[string]dbus.ObjectPath{
+		"gmail":         "/org/imapidle/account/gmail",
+		"work-remote":   "/org/imapidle/account/work_2dremote",
+		"":              "/org/imapidle/account/_",
+		"a_b.c":         "/org/imapidle/account/a_5fb_2ec",
+		"Store2-remote": "/org/imapidle/account/Store2_2dremote",
+	} {
+		if got := dbusAccountPath(name); got != want || !got.IsValid() {
+			t.Errorf("dbusAccountPath(%q) = %s expected %s", name, got, want)
+		}
+	}
+}
+
+func TestDBusService(t *testing.T) {
+	td := startDispatcher(t)
+	addr := privateBus(t)
+	svc, err := newDBusService(busConn(t, addr), td.Dispatcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDBusService(busConn(t, addr), td.Dispatcher); err == nil {
+		t.Errorf("Second service took the name")
+	}
+
+	client := busConn(t, addr)
+	var paths map[string]dbus.ObjectPath
+	if err := client.Object(dbusName, dbusPath).Call(dbusManagerIface+".ListAccounts", 0).Store(&paths); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]dbus.ObjectPath{"a": dbusAccountPath("a"), "b": dbusAccountPath("b")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("ListAccounts %v expected %v", paths, want)
+	}
+	obj := client.Object(dbusName, paths["a"])
+	if v, err := obj.GetProperty(dbusAccountIface + ".State"); err != nil || v.Value() != "disconnected" {
+		t.Errorf("State %v: %v", v, err)
+	}
+
+	// Methods
+	if err := obj.Call(dbusAccountIface+".Pause", 0, true).Err; err != nil {
+		t.Fatal(err)
+	}
+	if !td.Accounts["a"].Paused() {
+		t.Errorf("Pause didn't pause")
+	}
+	if err := obj.Call(dbusAccountIface+".Reconnect", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if td.Accounts["a"].Paused() {
+		t.Errorf("Reconnect didn't resume")
+	}
+	if err := obj.Call(dbusAccountIface+".Sync", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{}})
+
+	// Signals and properties follow the events
+	signals := make(chan *dbus.Signal, 10)
+	client.Signal(signals)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(paths["a"])); err != nil {
+		t.Fatal(err)
+	}
+	expectSignal := func(name string, body ...interface{}) {
+		t.Helper()
+		select {
+		case s := <-signals:
+			if s.Name != name || !reflect.DeepEqual(s.Body, body) {
+				t.Errorf("Signal %s %v expected %s %v", s.Name, s.Body, name, body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s", name)
+		}
+	}
+	now := td.clock.Now()
+	svc.Send(&sink.Event{Type: sink.NewMail, Store: "a", Time: now, Class: "urgent", New: 2, Messages: 9})
+	expectSignal(dbusAccountIface+".NewMail", "urgent", uint32(2), uint32(9))
+
+	// UnreadCount is the unseen count of INBOX, updates don't reset it
+	svc.Send(&sink.Event{Type: sink.Counts, Store: "a", Time: now, Mailbox: "Archive", Unseen: 4})
+	svc.Send(&sink.Event{Type: sink.Counts, Store: "a", Time: now, Mailbox: "INBOX", Messages: 9, Unseen: 3})
+	expectSignal(dbusPropsIface+".PropertiesChanged", dbusAccountIface,
+		map[string]dbus.Variant{"UnreadCount": dbus.MakeVariant(uint32(3))}, []string{})
+	svc.Send(&sink.Event{Type: sink.UpdateRun, Time: now, Stores: []string{"a"}})
+	expectSignal(dbusPropsIface+".PropertiesChanged", dbusAccountIface,
+		map[string]dbus.Variant{"LastUpdate": dbus.MakeVariant(now.Unix())}, []string{})
+	if v, err := obj.GetProperty(dbusAccountIface + ".UnreadCount"); err != nil || v.Value() != uint32(3) {
+		t.Errorf("UnreadCount %v: %v", v, err)
+	}
+
+	svc.Send(&sink.Event{Type: sink.State, Store: "a", Time: now, State: "backoff", Error: "gone"})
+	expectSignal(dbusPropsIface+".PropertiesChanged", dbusAccountIface,
+		map[string]dbus.Variant{
+			"State":     dbus.MakeVariant("backoff"),
+			"LastError": dbus.MakeVariant("gone"),
+		}, []string{})
+	expectSignal(dbusAccountIface+".StateChanged", "backoff", "gone")
+	if v, err := obj.GetProperty(dbusAccountIface + ".State"); err != nil || v.Value() != "backoff" {
+		t.Errorf("State %v: %v", v, err)
+	}
+
+	// Events for other stores aren't signalled on a
+	svc.Send(&sink.Event{Type: sink.FullUpdate, Store: "b", Time: now})
+	svc.Send(&sink.Event{Type: sink.FullUpdate, Store: "a", Time: now})
+	expectSignal(dbusAccountIface + ".FullUpdate")
+}
@@ -0,0 +1,561 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/choppsv1/imapidle/power"
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+// Dispatcher is the main event loop, it coalesces the account events and
+// runs the update script.
+type Dispatcher struct {
+	Accounts     map[string]*watcher.Account
+	Clock        clock.Clock
+	FullInterval time.Duration // time between full updates
+	NormalDelay  time.Duration // time to coalesce normal (non-urgent) new mail
+	Watchdog     <-chan time.Time
+
+	// Power, if set, receives the power and network state. While it's
+	// constrained full updates are at most every ConstrainedFullInterval,
+	// only urgent priority stores sync right away and low priority stores
+	// poll rather than IDLE.
+	Power                   <-chan power.Status
+	ConstrainedFullInterval time.Duration
+
+	// CountsFile, if set, is rewritten with the INBOX counts as they change.
+	CountsFile string
+
+	// RunScript runs the update script for the given update names, those
+	// in urgent are also in update. It returns the script's exit code, -1
+	// if it couldn't be run.
+	RunScript func(update, urgent []string) int
+
+	// Notify, if set, passes on a message for the user about a store. It
+	// must not block.
+	Notify func(store, msg string)
+
+	// Sinks are sent the events of each store, by store name. Sending
+	// must not block, see sink.Queue.
+	Sinks map[string][]sink.EventSink
+
+	// Streams are sent the events of all stores as well as update runs and
+	// script results. Sending must not block, see sink.Stream.
+	Streams []sink.EventSink
+
+	// Events receives the account events, see watcher.Watcher.
+	Events <-chan watcher.Event
+
+	attempted map[string]bool
+	ready     bool
+
+	update     map[string]bool
+	urgent     map[string]bool // by update name
+	fullUpdate bool
+
+	// Update names of the changed mailboxes of each store, its UpdateName
+	// is used if there are none, e.g., for a Sync.
+	names map[string]map[string]bool
+
+	// Scheduled stores: held has new mail and heldFull missed full updates
+	// while their policy said so, they catch up when their schedule is
+	// active again.
+	fullStores map[string]bool      // stores to update fully in the next run
+	lastFull   map[string]time.Time // last full update of each store
+	held       map[string]bool
+	heldFull   map[string]bool
+	schedT     clock.Timer
+
+	fullT       clock.Timer
+	constrained bool // saving power or data
+
+	dampT     clock.Timer
+	dampArmed bool
+	dampAt    time.Time
+
+	syncc    chan string // stores to update now, see Sync
+	syncLock sync.Mutex
+	syncing  map[string]bool // stores waiting on syncc
+
+	scriptDone chan *sink.Event
+	running    bool
+	deferred   bool
+}
+
+// NewDispatcher returns a dispatcher for the accounts of w.
+func NewDispatcher(w *watcher.Watcher, clk clock.Clock) *Dispatcher {
+	return &Dispatcher{
+		Accounts:                w.Accounts,
+		Clock:                   clk,
+		FullInterval:            watcher.DefPollInterval,
+		NormalDelay:             time.Minute,
+		ConstrainedFullInterval: time.Hour,
+		Events:                  w.Events(),
+		Sinks:                   make(map[string][]sink.EventSink),
+		attempted:               make(map[string]bool),
+		update:                  make(map[string]bool),
+		urgent:                  make(map[string]bool),
+		names:                   make(map[string]map[string]bool),
+		fullStores:              make(map[string]bool),
+		lastFull:                make(map[string]time.Time),
+		held:                    make(map[string]bool),
+		heldFull:                make(map[string]bool),
+		syncc:                   make(chan string, 10),
+		syncing:                 make(map[string]bool),
+		scriptDone:              make(chan *sink.Event),
+	}
+}
+
+// damp (re)arms the damp timer unless it's already due to fire sooner.
+func (d *Dispatcher) damp(delay time.Duration) {
+	now := d.Clock.Now()
+	if d.dampArmed && !now.Add(delay).Before(d.dampAt) {
+		return
+	}
+	if d.dampArmed && !d.dampT.Stop() {
+		<-d.dampT.C()
+	}
+	log.Debugf("[Re]Setting damp timer: %v", delay)
+	d.dampT.Reset(delay)
+	d.dampAt = now.Add(delay)
+	d.dampArmed = true
+}
+
+// runUpdate runs the update script in the background so the main loop keeps
+// running, updates arriving meanwhile wait for it to finish.
+func (d *Dispatcher) runUpdate() {
+	if d.running {
+		log.Debugf("Update script still running, deferring update")
+		d.deferred = true
+		return
+	}
+	full := false
+	if d.fullUpdate {
+		full = d.dueFull()
+	}
+	d.fullUpdate = false
+	now := d.Clock.Now()
+	stores := make([]string, 0, len(d.update))
+	channels := make([]string, 0, len(d.update))
+	var fullNames []string
+	for k := range d.fullStores {
+		d.lastFull[k] = now
+		fullNames = append(fullNames, k)
+		delete(d.held, k)
+		delete(d.heldFull, k)
+		delete(d.names, k)
+		if full {
+			// Everything, the script gets no channels
+			continue
+		}
+		stores = append(stores, k)
+		a := d.Accounts[k]
+		if len(a.Channels) == 0 {
+			channels = append(channels, a.UpdateName)
+		}
+		for _, c := range a.Channels {
+			channels = append(channels, c.Name)
+		}
+	}
+	for k := range d.update {
+		if d.fullStores[k] {
+			continue
+		}
+		delete(d.held, k)
+		stores = append(stores, k)
+		if len(d.names[k]) == 0 {
+			channels = append(channels, d.Accounts[k].UpdateName)
+		}
+		for name := range d.names[k] {
+			channels = append(channels, name)
+		}
+		delete(d.names, k)
+	}
+	urgentChannels := make([]string, 0, len(d.urgent))
+	for name := range d.urgent {
+		urgentChannels = append(urgentChannels, name)
+	}
+	// Clear update tracker
+	d.update = make(map[string]bool)
+	d.urgent = make(map[string]bool)
+	d.fullStores = make(map[string]bool)
+	if !full && len(stores) == 0 {
+		log.Debugf("No stores due an update")
+		return
+	}
+	d.running = true
+	d.sendStream(&sink.Event{
+		Type:     sink.UpdateRun,
+		Time:     d.Clock.Now(),
+		Stores:   stores,
+		Channels: channels,
+		Urgent:   urgentChannels,
+		Full:     full,
+	})
+	sort.Strings(fullNames)
+	for _, name := range fullNames {
+		d.sendStore(name, &sink.Event{Type: sink.FullUpdate, Store: name, Time: now})
+	}
+	go func() {
+		start := d.Clock.Now()
+		code := d.RunScript(channels, urgentChannels)
+		end := d.Clock.Now()
+		d.scriptDone <- &sink.Event{
+			Type:     sink.ScriptResult,
+			Time:     end,
+			Stores:   stores,
+			Channels: channels,
+			ExitCode: &code,
+			Seconds:  end.Sub(start).Seconds(),
+		}
+	}()
+}
+
+// policy returns the current policy of a store.
+func (d *Dispatcher) policy(name string) watcher.Policy {
+	a := d.Accounts[name]
+	p, _ := a.Store.Policy(d.Clock.Now())
+	if d.constrained && (a.Store == nil || a.Store.Priority != watcher.UrgentPriority) {
+		// Wait for the next full update
+		p.Sync = watcher.SyncNone
+	}
+	return p
+}
+
+// fullInterval returns the time between full updates.
+func (d *Dispatcher) fullInterval() time.Duration {
+	if d.constrained && d.ConstrainedFullInterval > d.FullInterval {
+		return d.ConstrainedFullInterval
+	}
+	return d.FullInterval
+}
+
+// setPower adjusts to the power and network state.
+func (d *Dispatcher) setPower(s power.Status) {
+	if s.Constrained() == d.constrained {
+		return
+	}
+	log.Infof("Power and network: %v", s)
+	d.constrained = s.Constrained()
+	for _, a := range d.Accounts {
+		if a.Store == nil || a.Store.Priority != watcher.UrgentPriority {
+			a.SetConstrained(d.constrained)
+		}
+		if a.Store != nil && a.Store.Priority == watcher.LowPriority {
+			a.SetPowerSave(d.constrained)
+		}
+	}
+	if !d.fullT.Stop() {
+		select {
+		case <-d.fullT.C():
+		default:
+		}
+	}
+	d.fullT.Reset(d.fullInterval())
+	d.catchUp()
+}
+
+// dueFull adds the stores due a full update under their policy to
+// fullStores, returning true if that's all of them.
+func (d *Dispatcher) dueFull() bool {
+	now := d.Clock.Now()
+	all := true
+	for name := range d.Accounts {
+		p := d.policy(name)
+		if p.FullInterval < 0 || (p.FullInterval > 0 && now.Sub(d.lastFull[name]) < p.FullInterval) {
+			log.WithField("store", name).Debugf("Leaving out of full update")
+			d.heldFull[name] = true
+			all = false
+		} else {
+			d.fullStores[name] = true
+		}
+	}
+	return all
+}
+
+// armSchedule arms the schedule timer for the next time a store's schedule
+// starts or ends.
+func (d *Dispatcher) armSchedule() {
+	now := d.Clock.Now()
+	var next time.Time
+	for _, a := range d.Accounts {
+		if a.Store == nil || a.Store.Schedule == nil {
+			continue
+		}
+		if t := a.Store.Schedule.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if !next.IsZero() {
+		log.Debugf("Next schedule change at %v", next)
+		d.schedT.Reset(next.Sub(now))
+	}
+}
+
+// catchUp updates the stores with held events whose policy no longer holds
+// them.
+func (d *Dispatcher) catchUp() {
+	for name := range d.Accounts {
+		if !d.held[name] && !d.heldFull[name] {
+			continue
+		}
+		if p := d.policy(name); p.Sync != watcher.SyncAll || p.FullInterval != 0 {
+			continue
+		}
+		log.WithField("store", name).Infof("Catching up")
+		if d.heldFull[name] {
+			d.fullStores[name] = true
+		} else {
+			d.update[name] = true
+		}
+		delete(d.held, name)
+		delete(d.heldFull, name)
+		d.damp(time.Second)
+	}
+}
+
+// addName adds the update name of a change to those of its store.
+func (d *Dispatcher) addName(e watcher.Event) {
+	names := d.names[e.A.Name]
+	if names == nil {
+		names = make(map[string]bool)
+		d.names[e.A.Name] = names
+	}
+	names[e.UpdateName()] = true
+}
+
+func (d *Dispatcher) handleEvent(e watcher.Event) {
+	d.sendSinks(e)
+	switch e.E {
+	case watcher.CheckMailEvent, watcher.ExpungeEvent, watcher.FlagsChangedEvent:
+		log.WithField("store", e.A.Name).Debugf("Received %v event: %v", e.E, e.C)
+		if e.Trigger() == watcher.FullAction {
+			log.WithField("store", e.A.Name).Debugf("Leaving change to the next full update")
+		} else if p := d.policy(e.A.Name); p.Suppresses(e.C) {
+			log.WithField("store", e.A.Name).Debugf("Holding %v mail by policy", e.C)
+			d.held[e.A.Name] = true
+			d.addName(e)
+		} else if !d.fullUpdate {
+			// Wait for other accounts, longer if not urgent
+			if e.C == watcher.UrgentClass {
+				d.damp(time.Second)
+				if p.Notify {
+					d.urgent[e.UpdateName()] = true
+				}
+			} else {
+				d.damp(d.NormalDelay)
+			}
+			d.update[e.A.Name] = true
+			d.addName(e)
+		}
+	case watcher.FullUpdateEvent:
+		log.Debugf("Received FullUpdateEvent")
+		if e.A != nil && d.policy(e.A.Name).Sync == watcher.SyncNone {
+			log.WithField("store", e.A.Name).Debugf("Holding full update for the schedule")
+			d.heldFull[e.A.Name] = true
+		} else if !d.fullUpdate {
+			d.damp(time.Second)
+			d.update = make(map[string]bool)
+			d.urgent = make(map[string]bool)
+		}
+		d.fullUpdate = true
+	case watcher.StateEvent:
+		d.attempted[e.A.Name] = true
+		if !d.ready && len(d.attempted) == len(d.Accounts) {
+			log.Debugf("All accounts have attempted to connect, ready")
+			d.ready = true
+			sdNotify("READY=1")
+		}
+		sdNotify(systemdStatus(d.Accounts))
+	case watcher.CountsEvent:
+		if d.CountsFile != "" {
+			if err := writeCounts(d.CountsFile, newCountsFile(d.Accounts, d.Clock.Now())); err != nil {
+				log.Warnf("counts: %v", err)
+			}
+		}
+	case watcher.NoticeEvent:
+		log.Warnf("%s: %s", e.A.Name, e.M)
+		if d.Notify != nil && d.policy(e.A.Name).Notify {
+			d.Notify(e.A.Name, e.M)
+		}
+	}
+}
+
+// sinkEvent returns the sink form of an account event, nil if it isn't sent
+// to sinks.
+func (d *Dispatcher) sinkEvent(e watcher.Event) *sink.Event {
+	se := &sink.Event{
+		Store: e.A.Name,
+		Time:  d.Clock.Now(),
+	}
+	switch e.E {
+	case watcher.CheckMailEvent:
+		se.Type = sink.NewMail
+		se.Class = e.C.String()
+		se.New = e.N
+		se.Messages = e.Total
+		se.UIDs = e.UIDs
+		se.Mailbox = e.Mailbox
+	case watcher.ExpungeEvent, watcher.FlagsChangedEvent:
+		se.Type = sink.Expunge
+		if e.E == watcher.FlagsChangedEvent {
+			se.Type = sink.FlagsChanged
+		}
+		se.New = e.N
+		se.Messages = e.Total
+		se.SeqNums = e.SeqNums
+		se.UIDs = e.UIDs
+		se.Mailbox = e.Mailbox
+	case watcher.CountsEvent:
+		c := e.A.Stats().Counts[e.Mailbox]
+		se.Type = sink.Counts
+		se.Mailbox = e.Mailbox
+		se.Messages = c.Messages
+		se.Unseen = c.Unseen
+		se.Recent = c.Recent
+	case watcher.FullUpdateEvent:
+		// Only a request, sinks get FullUpdate when it runs
+		return nil
+	case watcher.StateEvent:
+		s := e.A.Stats()
+		se.Type = sink.State
+		se.State = s.State.String()
+		se.Error = s.LastError
+	case watcher.NoticeEvent:
+		se.Type = sink.Notice
+		se.Message = e.M
+	default:
+		return nil
+	}
+	return se
+}
+
+// sendSinks sends an account event to the store's sinks and the stream.
+func (d *Dispatcher) sendSinks(e watcher.Event) {
+	if e.A == nil || (len(d.Sinks[e.A.Name]) == 0 && len(d.Streams) == 0) {
+		return
+	}
+	if se := d.sinkEvent(e); se != nil {
+		d.sendStore(e.A.Name, se)
+	}
+}
+
+// sendStore sends an event to a store's sinks and the streams.
+func (d *Dispatcher) sendStore(name string, se *sink.Event) {
+	for _, s := range d.Sinks[name] {
+		s.Send(se)
+	}
+	d.sendStream(se)
+}
+
+// sendStream sends an event to the streams.
+func (d *Dispatcher) sendStream(se *sink.Event) {
+	for _, s := range d.Streams {
+		s.Send(se)
+	}
+}
+
+// Sync runs the update script for the store soon, as for new mail. A store
+// already waiting to sync isn't queued again. It is safe to call from any
+// goroutine and doesn't block, returning an error if too many stores are
+// waiting.
+func (d *Dispatcher) Sync(store string) error {
+	d.syncLock.Lock()
+	defer d.syncLock.Unlock()
+	if d.syncing[store] {
+		return nil
+	}
+	select {
+	case d.syncc <- store:
+		d.syncing[store] = true
+		return nil
+	default:
+		return errors.New("too many syncs waiting")
+	}
+}
+
+// Run runs the event loop until ctx is done, then waits for a running update
+// script to finish.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.dampT = d.Clock.NewTimer(10 * time.Minute)
+	d.dampT.Stop() // Stop immediately
+	log.Debugf("Damped timer created and stopped")
+	d.schedT = d.Clock.NewTimer(time.Hour)
+	d.schedT.Stop()
+	d.armSchedule()
+
+	// Periodically do a full update, starting with one now
+	d.fullT = d.Clock.NewTimer(d.FullInterval)
+	d.handleEvent(watcher.Event{E: watcher.FullUpdateEvent})
+
+	for {
+		log.Debugf("Main select")
+		select {
+		case <-ctx.Done():
+			d.fullT.Stop()
+			d.dampT.Stop()
+			d.schedT.Stop()
+			if d.running {
+				d.sendStream(<-d.scriptDone)
+			}
+			return
+		case e := <-d.Events:
+			d.handleEvent(e)
+		case <-d.fullT.C():
+			d.fullT.Reset(d.fullInterval())
+			d.handleEvent(watcher.Event{E: watcher.FullUpdateEvent})
+		case <-d.dampT.C():
+			log.Debugf("Damped timer fires (stopped)")
+			d.dampArmed = false
+			d.runUpdate()
+		case s := <-d.Power:
+			d.setPower(s)
+		case <-d.schedT.C():
+			d.catchUp()
+			d.armSchedule()
+		case name := <-d.syncc:
+			d.syncLock.Lock()
+			delete(d.syncing, name)
+			d.syncLock.Unlock()
+			log.WithField("store", name).Debugf("Sync requested")
+			if !d.fullUpdate {
+				d.damp(time.Second)
+				d.update[name] = true
+			}
+		case se := <-d.scriptDone:
+			d.sendStream(se)
+			d.running = false
+			if d.deferred {
+				d.deferred = false
+				d.runUpdate()
+			}
+		case <-d.Watchdog:
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
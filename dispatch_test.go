@@ -0,0 +1,539 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/power"
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+type scriptRun struct {
+	update, urgent []string
+}
+
+type testDispatcher struct {
+	*Dispatcher
+	t      *testing.T
+	clock  *clock.Fake
+	events chan watcher.Event
+	runs   chan scriptRun
+	hold   chan struct{} // if set the script runs until it's closed
+	sunk   chanSink      // events sent to store a's sink
+	stream chanSink      // events sent to the stream
+}
+
+// chanSink is a sink that sends events on a channel.
+type chanSink chan *sink.Event
+
+func (c chanSink) Send(e *sink.Event) error {
+	c <- e
+	return nil
+}
+
+func (c chanSink) Close() error {
+	return nil
+}
+
+// waitPending waits for n timers to be active, i.e., for the main loop to be
+// waiting on the clock.
+func (td *testDispatcher) waitPending(n int) {
+	td.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for td.clock.Pending() != n {
+		if time.Now().After(deadline) {
+			td.t.Fatalf("Timeout waiting for %d pending timers", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestDispatcher returns a dispatcher for stores a and b on a fake clock,
+// it isn't running yet.
+func newTestDispatcher(t *testing.T) *testDispatcher {
+	accounts := make(map[string]*watcher.Account)
+	for _, name := range []string{"a", "b"} {
+		accounts[name] = &watcher.Account{
+			AccountConfig: mbsyncrc.AccountConfig{Name: name},
+			UpdateName:    fmt.Sprintf("%s-inbox:INBOX", name),
+		}
+	}
+	td := &testDispatcher{
+		Dispatcher: NewDispatcher(watcher.New(accounts), clock.NewFake()),
+		t:          t,
+		// Unbuffered so send can tell when an event has been handled
+		events: make(chan watcher.Event),
+		runs:   make(chan scriptRun, 10),
+		sunk:   make(chanSink, 100),
+		stream: make(chanSink, 100),
+	}
+	td.Sinks["a"] = []sink.EventSink{td.sunk}
+	td.Streams = []sink.EventSink{td.stream}
+	td.clock = td.Clock.(*clock.Fake)
+	td.Events = td.events
+	td.RunScript = func(update, urgent []string) int {
+		sort.Strings(update)
+		sort.Strings(urgent)
+		hold := td.hold
+		td.runs <- scriptRun{update, urgent}
+		if hold != nil {
+			<-hold
+		}
+		return 0
+	}
+	return td
+}
+
+// start runs the dispatcher until the test ends.
+func (td *testDispatcher) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		td.Run(ctx)
+		close(done)
+	}()
+	td.t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// startDispatcher runs a dispatcher for stores a and b on a fake clock and
+// consumes the initial full update.
+func startDispatcher(t *testing.T) *testDispatcher {
+	td := newTestDispatcher(t)
+	td.start()
+
+	// The full update timer and the armed damp timer
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+	td.expectSunk(sink.Event{Type: sink.FullUpdate, Store: "a", Time: td.clock.Now()})
+	return td
+}
+
+// expectSunk checks the next event sent to store a's sink.
+func (td *testDispatcher) expectSunk(want sink.Event) {
+	td.t.Helper()
+	select {
+	case e := <-td.sunk:
+		if !reflect.DeepEqual(*e, want) {
+			td.t.Fatalf("Sink got %+v expected %+v", *e, want)
+		}
+	case <-time.After(5 * time.Second):
+		td.t.Fatalf("Timeout waiting for sink event %+v", want)
+	}
+}
+
+// send sends an event and waits for the main loop to handle it.
+func (td *testDispatcher) send(e watcher.Event) {
+	td.events <- e
+	// The loop only receives this once it's done with e
+	td.events <- watcher.Event{E: watcher.OfflineEvent}
+}
+
+func (td *testDispatcher) checkMail(name string, class watcher.Class) {
+	td.send(watcher.Event{E: watcher.CheckMailEvent, A: td.Accounts[name], C: class})
+}
+
+func (td *testDispatcher) expectRun(want scriptRun) {
+	td.t.Helper()
+	select {
+	case run := <-td.runs:
+		if !reflect.DeepEqual(run, want) {
+			td.t.Fatalf("Script run %v expected %v", run, want)
+		}
+	case <-time.After(5 * time.Second):
+		td.t.Fatalf("Timeout waiting for script run %v", want)
+	}
+}
+
+func (td *testDispatcher) expectNoRun() {
+	td.t.Helper()
+	select {
+	case run := <-td.runs:
+		td.t.Fatalf("Unexpected script run %v", run)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcherUrgentCoalescing(t *testing.T) {
+	td := startDispatcher(t)
+
+	td.checkMail("a", watcher.UrgentClass)
+	td.clock.Advance(500 * time.Millisecond)
+	td.checkMail("b", watcher.UrgentClass)
+	td.clock.Advance(499 * time.Millisecond)
+	td.expectNoRun()
+
+	// The second event doesn't push out the first's deadline
+	td.clock.Advance(time.Millisecond)
+	td.expectRun(scriptRun{
+		[]string{"a-inbox:INBOX", "b-inbox:INBOX"},
+		[]string{"a-inbox:INBOX", "b-inbox:INBOX"},
+	})
+	td.clock.Advance(time.Minute)
+	td.expectNoRun()
+}
+
+func TestDispatcherNormalDelay(t *testing.T) {
+	td := startDispatcher(t)
+
+	td.checkMail("a", watcher.NormalClass)
+	td.clock.Advance(59 * time.Second)
+	td.expectNoRun()
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{}})
+
+	// Urgent mail brings a pending normal update forward
+	td.checkMail("a", watcher.NormalClass)
+	td.clock.Advance(10 * time.Second)
+	td.checkMail("b", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX", "b-inbox:INBOX"}, []string{"b-inbox:INBOX"}})
+	td.clock.Advance(time.Minute)
+	td.expectNoRun()
+}
+
+func TestDispatcherFullUpdate(t *testing.T) {
+	td := startDispatcher(t)
+
+	td.clock.Advance(watcher.DefPollInterval - 2*time.Second)
+	td.expectNoRun()
+	td.clock.Advance(time.Second)
+	td.waitPending(2)
+
+	// Mail arriving for a pending full update is covered by it
+	td.checkMail("a", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+	td.clock.Advance(time.Minute)
+	td.expectNoRun()
+
+	// And again a full interval after the first
+	td.clock.Advance(watcher.DefPollInterval - time.Minute - time.Second)
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+}
+
+func TestDispatcherFullUpdateSinks(t *testing.T) {
+	td := startDispatcher(t)
+
+	// Timer driven full updates reach the sinks
+	td.clock.Advance(watcher.DefPollInterval - time.Second)
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+	td.expectSunk(sink.Event{Type: sink.FullUpdate, Store: "a", Time: td.clock.Now()})
+
+	// An account asking for a full update isn't one yet
+	td.send(watcher.Event{E: watcher.FullUpdateEvent, A: td.Accounts["a"]})
+	if len(td.sunk) != 0 {
+		t.Errorf("Sink got %+v before the full update ran", <-td.sunk)
+	}
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+	td.expectSunk(sink.Event{Type: sink.FullUpdate, Store: "a", Time: td.clock.Now()})
+}
+
+func TestDispatcherDeferred(t *testing.T) {
+	td := startDispatcher(t)
+	td.hold = make(chan struct{})
+
+	td.checkMail("a", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{"a-inbox:INBOX"}})
+
+	// Updates while the script runs wait for it to finish
+	td.checkMail("b", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectNoRun()
+	close(td.hold)
+	td.expectRun(scriptRun{[]string{"b-inbox:INBOX"}, []string{"b-inbox:INBOX"}})
+}
+
+func TestDispatcherSinks(t *testing.T) {
+	td := startDispatcher(t)
+
+	td.checkMail("a", watcher.UrgentClass)
+	td.checkMail("b", watcher.UrgentClass)
+	td.send(watcher.Event{E: watcher.NoticeEvent, A: td.Accounts["a"], M: "hello"})
+	for _, want := range []sink.Event{
+		{Type: sink.NewMail, Store: "a", Time: td.clock.Now(), Class: "urgent"},
+		{Type: sink.Notice, Store: "a", Time: td.clock.Now(), Message: "hello"},
+	} {
+		select {
+		case e := <-td.sunk:
+			if !reflect.DeepEqual(*e, want) {
+				t.Errorf("Sink got %+v expected %+v", *e, want)
+			}
+		default:
+			t.Fatalf("Sink didn't get %+v", want)
+		}
+	}
+	if len(td.sunk) != 0 {
+		t.Errorf("Sink got events for other stores")
+	}
+}
+
+// expectStream checks the next event sent to the stream.
+func (td *testDispatcher) expectStream(want sink.Event) {
+	td.t.Helper()
+	select {
+	case e := <-td.stream:
+		if !reflect.DeepEqual(*e, want) {
+			td.t.Fatalf("Stream got %+v expected %+v", *e, want)
+		}
+	case <-time.After(5 * time.Second):
+		td.t.Fatalf("Timeout waiting for stream event %+v", want)
+	}
+}
+
+func TestDispatcherStream(t *testing.T) {
+	td := startDispatcher(t)
+	zero := 0
+	start := td.clock.Now()
+	td.expectStream(sink.Event{Type: sink.UpdateRun, Time: start, Stores: []string{},
+		Channels: []string{}, Urgent: []string{}, Full: true})
+	td.expectStream(sink.Event{Type: sink.FullUpdate, Store: "a", Time: start})
+	td.expectStream(sink.Event{Type: sink.FullUpdate, Store: "b", Time: start})
+	td.expectStream(sink.Event{Type: sink.ScriptResult, Time: start, Stores: []string{},
+		Channels: []string{}, ExitCode: &zero})
+
+	td.send(watcher.Event{E: watcher.CheckMailEvent, A: td.Accounts["b"], C: watcher.UrgentClass, N: 2, Total: 7})
+	td.expectStream(sink.Event{Type: sink.NewMail, Store: "b", Time: start, Class: "urgent", New: 2, Messages: 7})
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"b-inbox:INBOX"}, []string{"b-inbox:INBOX"}})
+	now := td.clock.Now()
+	td.expectStream(sink.Event{Type: sink.UpdateRun, Time: now, Stores: []string{"b"},
+		Channels: []string{"b-inbox:INBOX"}, Urgent: []string{"b-inbox:INBOX"}})
+	td.expectStream(sink.Event{Type: sink.ScriptResult, Time: now, Stores: []string{"b"},
+		Channels: []string{"b-inbox:INBOX"}, ExitCode: &zero})
+	if len(td.sunk) != 0 {
+		t.Errorf("Store a's sink got events for b")
+	}
+}
+
+func TestDispatcherChanges(t *testing.T) {
+	td := startDispatcher(t)
+	a := td.Accounts["a"]
+
+	// Flag changes wait for the full update by default but are still sent
+	td.send(watcher.Event{E: watcher.FlagsChangedEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 3,
+		SeqNums: []uint32{2}, UIDs: []uint32{12}})
+	td.clock.Advance(time.Second)
+	td.expectNoRun()
+	if e := <-td.sunk; e.Type != sink.FlagsChanged || !reflect.DeepEqual(e.UIDs, []uint32{12}) {
+		t.Errorf("Unexpected sink event %+v", e)
+	}
+
+	td.send(watcher.Event{E: watcher.ExpungeEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 2,
+		SeqNums: []uint32{2}})
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{"a-inbox:INBOX"}})
+	if e := <-td.sunk; e.Type != sink.Expunge || !reflect.DeepEqual(e.SeqNums, []uint32{2}) {
+		t.Errorf("Unexpected sink event %+v", e)
+	}
+
+	// Unless configured otherwise
+	a.Store = watcher.NewStoreConfig("a")
+	a.Store.Triggers[watcher.FlagsChange] = watcher.NowAction
+	a.Store.Triggers[watcher.ExpungeChange] = watcher.FullAction
+	td.send(watcher.Event{E: watcher.ExpungeEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 1})
+	td.clock.Advance(time.Second)
+	td.expectNoRun()
+	td.send(watcher.Event{E: watcher.FlagsChangedEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 1})
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{"a-inbox:INBOX"}})
+}
+
+func TestDispatcherPolledMailbox(t *testing.T) {
+	td := startDispatcher(t)
+	a := td.Accounts["a"]
+
+	// Changes of polled mailboxes update their own channel:mailbox
+	td.send(watcher.Event{E: watcher.CheckMailEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 4,
+		Mailbox: "Lists/go", Update: "a-lists:Lists/go"})
+	td.send(watcher.Event{E: watcher.ExpungeEvent, A: a, C: watcher.NormalClass, N: 1, Total: 2,
+		Mailbox: "Archive", Update: "a-inbox:Archive"})
+	td.checkMail("b", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{
+		[]string{"a-inbox:Archive", "a-lists:Lists/go", "b-inbox:INBOX"},
+		[]string{"a-lists:Lists/go", "b-inbox:INBOX"},
+	})
+	if e := <-td.sunk; e.Type != sink.NewMail || e.Mailbox != "Lists/go" {
+		t.Errorf("Unexpected sink event %+v", e)
+	}
+
+	// And INBOX along with them
+	td.checkMail("a", watcher.UrgentClass)
+	td.send(watcher.Event{E: watcher.CheckMailEvent, A: a, C: watcher.UrgentClass, N: 1, Total: 5,
+		Mailbox: "Lists/go", Update: "a-lists:Lists/go"})
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{
+		[]string{"a-inbox:INBOX", "a-lists:Lists/go"},
+		[]string{"a-inbox:INBOX", "a-lists:Lists/go"},
+	})
+}
+
+func TestDispatcherSchedule(t *testing.T) {
+	td := newTestDispatcher(t)
+	td.FullInterval = time.Hour
+	notices := make(chan string, 10)
+	td.Notify = func(store, msg string) {
+		notices <- store
+	}
+	// It's Sunday 10:00, a is only active for half an hour from 10:30.
+	a := td.Accounts["a"]
+	a.Channels = []*mbsyncrc.Channel{{Name: "a-chan"}}
+	a.Store = watcher.NewStoreConfig("a")
+	w, err := watcher.ParseWindow("Sun", "10:30-11:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Store.Schedule = &watcher.Schedule{Windows: []watcher.Window{w}}
+	a.Store.OffHours = watcher.Policy{Sync: watcher.SyncUrgent, FullInterval: -1}
+	td.start()
+
+	// The full update leaves a out
+	td.waitPending(3)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"b-inbox:INBOX"}, []string{}})
+
+	// Urgent mail is synced without notifying, normal mail is held
+	td.checkMail("a", watcher.NormalClass)
+	td.clock.Advance(time.Minute)
+	td.expectNoRun()
+	td.checkMail("a", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{}})
+	td.send(watcher.Event{E: watcher.NoticeEvent, A: a, M: "quiet"})
+	td.send(watcher.Event{E: watcher.NoticeEvent, A: td.Accounts["b"], M: "loud"})
+	if n := <-notices; n != "b" || len(notices) != 0 {
+		t.Errorf("Notified %s expected only b", n)
+	}
+
+	// One full catch-up sync when the schedule starts
+	td.checkMail("a", watcher.NormalClass)
+	td.clock.Advance(30*time.Minute - 62*time.Second)
+	td.waitPending(3)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-chan"}, []string{}})
+	td.expectNoRun()
+
+	// Then back to normal
+	td.checkMail("a", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{"a-inbox:INBOX"}})
+
+	// Until it ends with the full update
+	td.clock.Advance(30 * time.Minute)
+	td.waitPending(3)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"b-inbox:INBOX"}, []string{}})
+}
+
+func TestDispatcherPower(t *testing.T) {
+	td := newTestDispatcher(t)
+	td.FullInterval = 10 * time.Minute
+	powerc := make(chan power.Status)
+	td.Power = powerc
+	a, b := td.Accounts["a"], td.Accounts["b"]
+	a.Store = watcher.NewStoreConfig("a")
+	a.Store.Priority = watcher.LowPriority
+	b.Store = watcher.NewStoreConfig("b")
+	b.Store.Priority = watcher.UrgentPriority
+	a.PollInt, b.PollInt = time.Minute, time.Minute
+	td.start()
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+
+	// On battery a polls and its mail waits, urgent b is unchanged
+	powerc <- power.Status{OnBattery: true}
+	td.checkMail("a", watcher.UrgentClass)
+	if !a.PowerSave() || b.PowerSave() {
+		t.Errorf("Power save a %v b %v expected only a", a.PowerSave(), b.PowerSave())
+	}
+	if a.PollInterval() != watcher.DefPowerPollInt || b.PollInterval() != time.Minute {
+		t.Errorf("Poll intervals a %v b %v expected only a stretched", a.PollInterval(), b.PollInterval())
+	}
+	td.checkMail("b", watcher.UrgentClass)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"b-inbox:INBOX"}, []string{"b-inbox:INBOX"}})
+
+	// Full updates are stretched to the constrained interval
+	td.clock.Advance(10 * time.Minute)
+	td.expectNoRun()
+	td.clock.Advance(50*time.Minute - time.Second)
+	td.waitPending(2)
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{}, []string{}})
+
+	// Back on mains held mail is synced
+	td.checkMail("a", watcher.NormalClass)
+	powerc <- power.Status{}
+	td.send(watcher.Event{E: watcher.OfflineEvent})
+	if a.PowerSave() || a.PollInterval() != time.Minute {
+		t.Errorf("a still saving power, polling every %v", a.PollInterval())
+	}
+	td.clock.Advance(time.Second)
+	td.expectRun(scriptRun{[]string{"a-inbox:INBOX"}, []string{}})
+}
+
+func TestDispatcherSyncQueue(t *testing.T) {
+	td := newTestDispatcher(t)
+	td.syncc = make(chan string, 1)
+	for i := 0; i < 2; i++ {
+		if err := td.Sync("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(td.syncc); n != 1 {
+		t.Errorf("%d syncs queued expected 1", n)
+	}
+	if err := td.Sync("b"); err == nil {
+		t.Errorf("Sync didn't fail with a full queue")
+	}
+
+	// Once handled the queue has room again
+	td.start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := td.Sync("b")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Sync still failing: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,6 +4,8 @@ go 1.16
 
 require (
 	github.com/emersion/go-imap v1.0.6
+	github.com/emersion/go-message v0.11.1
 	github.com/emersion/go-sasl v0.0.0-20191210011802-430746ea8b9b
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/sirupsen/logrus v1.8.1
 )
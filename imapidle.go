@@ -22,15 +22,27 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/choppsv1/imapidle/logging"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/power"
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+	"github.com/godbus/dbus/v5"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -61,13 +73,35 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
-func runUpdateScript(script string, updateNames []string) {
+// updateName returns the update script argument of a store given on the
+// command line as storename[:channel-name[:inbox-name]], vals being the values
+// after the store name. The inbox name may be UTF-8 or modified UTF-7.
+func updateName(a *watcher.Account, vals []string) (string, error) {
+	channel, mailbox := a.Channels[0].Name, "INBOX"
+	if len(vals) > 0 && vals[0] != "" {
+		channel = vals[0]
+	}
+	if len(vals) > 1 {
+		var err error
+		if mailbox, err = watcher.NormalizeMailbox(vals[1]); err != nil {
+			return "", err
+		} else if mailbox == "" {
+			return "", errors.New("Empty inbox name")
+		}
+	}
+	return a.ChannelArg(channel, mailbox), nil
+}
+
+// runUpdateScript runs the update script returning its exit code, -1 if it
+// couldn't be run.
+func runUpdateScript(script string, updateNames, urgentNames []string) int {
 	log.Debugf("Running update script %s with args: %s", script, updateNames)
 
-	sPath, err := exec.LookPath(expandTilde(script))
+	sPath, err := exec.LookPath(mbsyncrc.ExpandTilde(script))
 	if err != nil {
 		log.Errorf("Cannot find update script %s in PATH", sPath)
-		return
+		scriptStats.record(-1, 0)
+		return -1
 	}
 	log.Debugf("Update script found: %s", sPath)
 
@@ -83,59 +117,141 @@ func runUpdateScript(script string, updateNames []string) {
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 	}
+	if len(urgentNames) != 0 {
+		// Let the script know which updates are urgent so it can notify
+		cmd.Env = append(os.Environ(), "IMAPIDLE_URGENT="+strings.Join(urgentNames, " "))
+	}
 
-	if err = cmd.Run(); err != nil {
+	start := time.Now()
+	err = cmd.Run()
+	if err != nil {
+		log.Warnf("%s: returned an error: %v", script, err)
+	}
+	// ExitCode is -1 if the script couldn't be started
+	code := cmd.ProcessState.ExitCode()
+	scriptStats.record(code, time.Since(start))
+	return code
+}
+
+// runNotifyScript runs the notify script with the store name and message.
+func runNotifyScript(script, store, msg string) {
+	sPath, err := exec.LookPath(mbsyncrc.ExpandTilde(script))
+	if err != nil {
+		log.Errorf("Cannot find notify script %s in PATH", script)
+		return
+	}
+	cmd := exec.Command(sPath, store, msg)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
 		log.Warnf("%s: returned an error: %v", script, err)
 	}
 }
 
+// subcommands are run by name with the remaining arguments.
+var subcommands = map[string]func([]string) int{
+	"systemd-unit": systemdUnitCmd,
+	"watch":        watchCmd,
+	"status":       statusCmd,
+	"list":         listCmd,
+}
+
+// subcommand returns the subcommand args name, or nil if they are stores to
+// watch. A store named like a subcommand is watched, isStore reports whether
+// name is a store.
+func subcommand(args []string, isStore func(name string) bool) func([]string) int {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := subcommands[args[0]]
+	if cmd == nil || isStore(args[0]) {
+		return nil
+	}
+	return cmd
+}
+
 func main() {
-	var updateScript, mbsyncrc string
-	var interval time.Duration
+	var updateScript, notifyScript, mbsyncrcFile, configFile, metricsAddr, eventsSocket string
+	var interval, normalDelay time.Duration
+	var maxRestarts, maxLoginConnections int
 
 	flag.StringVar(&updateScript, "update-script", "~/.imapidle-update", "Script to run when an INBOX is updated")
-	flag.StringVar(&mbsyncrc, "mbsyncrc", "~/.mbsyncrc", "Location of mbsync config file")
-	flag.DurationVar(&interval, "full-interval", DefPollInterval, "Time between full updates regardless of IDLE")
+	flag.StringVar(&notifyScript, "notify-script", "", "Script to run with a store name and message the user should see, e.g., a refused login")
+	flag.StringVar(&mbsyncrcFile, "mbsyncrc", "~/.mbsyncrc", "Location of mbsync config file")
+	flag.StringVar(&configFile, "config", "~/.imapidlerc", "Location of imapidle config file")
+	flag.DurationVar(&interval, "full-interval", watcher.DefPollInterval, "Time between full updates regardless of IDLE")
+	flag.DurationVar(&normalDelay, "normal-delay", time.Minute, "Time to coalesce updates for normal (non-urgent) new mail")
+	flag.StringVar(&metricsAddr, "metrics-listen", "", "Address (host:port) to serve Prometheus metrics on, disabled if empty")
+	flag.StringVar(&eventsSocket, "events-socket", defaultEventsSocket(), "Unix socket to stream events to imapidle watch clients on, disabled if empty")
+	countsFile := flag.String("counts-file", defaultCountsFile(), "File to keep the INBOX counts in for imapidle status, disabled if empty")
+	flag.IntVar(&maxLoginConnections, "max-login-connections", 0, "Connections to keep open for each server login (host, port and user), unlimited if 0")
+	flag.IntVar(&maxRestarts, "max-restarts", watcher.DefMaxRestarts, "Restarts of a store after internal errors before giving up on it")
+	dbusFlag := flag.Bool("dbus", false, "Export the org.imapidle service on the D-Bus session bus")
+	powerFlag := flag.Bool("power-aware", false, "Back off on battery or a metered connection")
+	constrainedInterval := flag.Duration("constrained-full-interval", time.Hour, "Time between full updates on battery or a metered connection")
+	powerPollInterval := flag.Duration("power-poll-interval", watcher.DefPowerPollInt, "Time between STATUS polls of low priority stores on battery or a metered connection")
 	runPassCmdFlag := flag.Bool("run-passcmd-on-parse", false, "Run PassCmds on parsing of .mbsyncrc file")
 	versionFlag := flag.Bool("version", false, "Print the version and exit")
 	verboseFlag := flag.Bool("verbose", false, "Log verbosely")
 	debugFlag := flag.Bool("debug", false, "Log information useful for debugging")
+	logFormatFlag := flag.String("log-format", "text", "Log output format: text, json or journald")
+	logLevelFlag := flag.String("log-level", "", "Log levels: [subsystem=]level,... where subsystem is a store name, imap or imap:<store>")
 	flag.NArg()
 	flag.Parse()
 	checkStores := flag.Args()
 
+	cmd := subcommand(checkStores, func(name string) bool {
+		stores, err := mbsyncrc.ParseFile(mbsyncrcFile, false)
+		return err == nil && stores[name] != nil
+	})
+	if cmd != nil {
+		os.Exit(cmd(checkStores[1:]))
+	}
+
 	if *versionFlag {
 		fmt.Printf("Version %s (%s)\n", strings.Split(Version, "\n")[0], Sha)
 		os.Exit(0)
 	}
 
+	level := log.InfoLevel
 	if *verboseFlag {
-		log.SetLevel(log.DebugLevel)
+		level = log.DebugLevel
 	}
 	if *debugFlag {
-		log.SetLevel(log.TraceLevel)
+		level = log.TraceLevel
+	}
+	if err := logging.Setup(level, *logFormatFlag, *logLevelFlag); err != nil {
+		log.Fatal("logging.Setup: ", err)
 	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "01-02-2006 15:04:05.000",
-	})
 
-	stores, err := parseFile(mbsyncrc, *runPassCmdFlag)
+	stores, err := mbsyncrc.ParseFile(mbsyncrcFile, *runPassCmdFlag)
 	if err != nil {
-		log.Fatal("parseFile: ", err)
+		log.Fatal("mbsyncrc.ParseFile: ", err)
+	}
+	for _, v := range stores {
+		logging.AddSecret(v.Config.Password)
 	}
 
-	var accounts = make(map[string]*Account)
+	config, err := watcher.ParseConfig(configFile)
+	if err != nil {
+		log.Fatal("watcher.ParseConfig: ", err)
+	}
+
+	var accounts = make(map[string]*watcher.Account)
 	for k, v := range stores {
 		if len(v.Channels) == 0 {
 			log.Infof("Skipping store %v due to no channels", k)
 			continue
 		}
 
-		a := &Account{
+		a := &watcher.Account{
 			AccountConfig: v.Config,
 			Channels:      v.Channels,
+			Path:          v.Path,
+			PathDelimiter: v.PathDelimiter,
 			PollInt:       interval,
+			Store:         config.Store(k),
+			PowerPollInt:  *powerPollInterval,
 		}
 
 		// Fix the name to be the same as the store
@@ -145,21 +261,12 @@ func main() {
 		if len(checkStores) != 0 {
 			i := 0
 			for i = range checkStores {
-				vals := strings.Split(checkStores[i], ":")
-				vlen := len(vals)
-				sname := vals[0]
-				if sname != k {
+				vals := strings.SplitN(checkStores[i], ":", 3)
+				if vals[0] != k {
 					continue
 				}
-				if vlen == 3 {
-					// User specified store channel and inbox name
-					a.UpdateName = strings.Join(vals[1:], ":")
-				} else if vlen == 2 {
-					a.UpdateName = fmt.Sprintf("%s:INBOX", vals[1])
-				} else if vlen == 1 {
-					a.UpdateName = fmt.Sprintf("%s:INBOX", a.Channels[0].Name)
-				} else {
-					log.Errorf("Bad store/channel name %v", checkStores[i])
+				if a.UpdateName, err = updateName(a, vals[1:]); err != nil {
+					log.Errorf("Bad store/channel name %v: %v", checkStores[i], err)
 					flag.Usage()
 					os.Exit(1)
 				}
@@ -171,82 +278,114 @@ func main() {
 			}
 		} else {
 			// Set the update name
-			a.UpdateName = fmt.Sprintf("%s:INBOX", a.Channels[0].Name)
+			a.UpdateName = a.ChannelArg(a.Channels[0].Name, "INBOX")
 		}
 
 		accounts[k] = a
 	}
 
+	watcher.Share(accounts, maxLoginConnections)
 	dumpValue(accounts)
 
-	// Channel to receive account events
-	eventc := make(chan Event, 1)
+	listeners := activationListeners()
+	if l, ok := listeners["metrics"]; ok {
+		go serveMetrics(l, accounts)
+	} else if metricsAddr != "" {
+		l, err := net.Listen("tcp", metricsAddr)
+		if err != nil {
+			log.Fatal("metrics: ", err)
+		}
+		go serveMetrics(l, accounts)
+	}
 
-	for _, a := range accounts {
-		go a.Online(eventc)
+	w := watcher.New(accounts)
+	w.MaxRestarts = maxRestarts
+	d := NewDispatcher(w, clock.System)
+	for k := range accounts {
+		for _, c := range config.Store(k).Sinks {
+			if c.Secret != "" {
+				logging.AddSecret(c.Secret)
+			}
+			s, err := sink.New(c)
+			if err != nil {
+				log.Fatal("sink: ", err)
+			}
+			name := fmt.Sprintf("%s %s %s", k, c.Type, c.Target)
+			d.Sinks[k] = append(d.Sinks[k], sink.NewQueue(s, name, 100))
+		}
+	}
+	if l, ok := listeners["events"]; ok {
+		d.Streams = append(d.Streams, serveEvents(l))
+	} else if eventsSocket != "" {
+		l, err := listenEvents(mbsyncrc.ExpandTilde(eventsSocket))
+		if errors.Is(err, errSocketInUse) {
+			// Another instance, e.g., with a different config
+			log.Warnf("events: %v, not streaming events", err)
+		} else if err != nil {
+			log.Fatal("events: ", err)
+		} else {
+			d.Streams = append(d.Streams, serveEvents(l))
+		}
+	}
+	if *dbusFlag {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			log.Fatal("dbus: ", err)
+		}
+		svc, err := newDBusService(conn, d)
+		if err != nil {
+			log.Fatal("dbus: ", err)
+		}
+		d.Streams = append(d.Streams, sink.NewQueue(svc, "dbus", 100))
+	}
+	if *powerFlag {
+		// Without NetworkManager only the power supply is watched
+		bus, err := dbus.ConnectSystemBus()
+		if err != nil {
+			log.Warnf("dbus: %v, ignoring metered connections", err)
+			bus = nil
+		}
+		powerc := make(chan power.Status)
+		go power.NewMonitor(bus).Run(context.Background(), powerc)
+		d.Power = powerc
+	}
+	d.FullInterval = interval
+	d.ConstrainedFullInterval = *constrainedInterval
+	d.NormalDelay = normalDelay
+	if *countsFile != "" {
+		d.CountsFile = mbsyncrc.ExpandTilde(*countsFile)
+	}
+	d.RunScript = func(update, urgent []string) int {
+		return runUpdateScript(updateScript, update, urgent)
+	}
+	if notifyScript != "" {
+		d.Notify = func(store, msg string) {
+			go runNotifyScript(notifyScript, store, msg)
+		}
 	}
 
-	// Periodically do a full update
+	// SIGUSR1 retries stores waiting after a refused login
+	retryc := make(chan os.Signal, 1)
+	signal.Notify(retryc, syscall.SIGUSR1)
 	go func() {
-		ft := time.NewTimer(interval)
-		for {
-			eventc <- Event{FullUpdateEvent, nil}
-			<-ft.C
-			ft.Reset(interval)
+		for range retryc {
+			log.Infof("Retrying refused logins")
+			for _, a := range accounts {
+				a.Retry()
+			}
 		}
 	}()
 
-	update := make(map[string]bool)
-	fullUpdate := false
-	dampT := time.NewTimer(10 * time.Minute)
-	dampT.Stop() // Stop immediately
-	log.Debugf("Damped timer created and stopped")
+	// Keep the service manager watchdog fed while the main loop is running
+	if wd := watchdogInterval(); wd != 0 {
+		log.Debugf("Sending watchdog notifications every %v", wd)
+		d.Watchdog = time.NewTicker(wd).C
+	}
+
+	ctx := context.Background()
+	w.Start(ctx)
 
 	defer log.Error("Exited Main!")
 
-	for {
-		log.Debugf("Main select")
-		select {
-		case e := <-eventc:
-			switch e.E {
-			case CheckMailEvent:
-				log.Debugf("Received CheckMailEvent: %v", e.A.Name)
-				if !fullUpdate {
-					// Timer hasn't been set yet -- set.
-					if len(update) == 0 {
-						// Wait 1 second for other accounts
-
-						log.Debugf("[Re]Setting damp timer")
-						dampT.Reset(time.Second)
-					}
-					update[e.A.Name] = true
-				}
-			case FullUpdateEvent:
-				log.Debugf("Received FullUpdateEvent")
-				if !fullUpdate {
-					if len(update) != 0 {
-						update = make(map[string]bool)
-					} else {
-						// Timer hasn't been set yet -- set.
-						log.Debugf("[Re]Setting damp timer")
-						dampT.Reset(time.Second)
-					}
-				}
-				fullUpdate = true
-			}
-		case <-dampT.C:
-			log.Debugf("Damped timer fires (stopped)")
-			if fullUpdate {
-				fullUpdate = false
-			}
-			channels := make([]string, 0, len(update))
-			for k := range update {
-				channels = append(channels, accounts[k].UpdateName)
-			}
-			// Clear update tracker
-			update = make(map[string]bool)
-			runUpdateScript(updateScript, channels)
-		}
-	}
-	log.Panic("NOTREACHED")
+	d.Run(ctx)
 }
@@ -0,0 +1,104 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/choppsv1/imapidle/logging"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestUpdateName(t *testing.T) {
+	a := &watcher.Account{
+		Channels:      []*mbsyncrc.Channel{{Name: "work", Far: ":work-remote:"}},
+		Path:          "INBOX.",
+		PathDelimiter: ".",
+	}
+	for arg, want := range map[string]string{
+		"":                         "work:INBOX",
+		"work-all":                 "work-all:INBOX",
+		"work-all:INBOX":           "work-all:INBOX",
+		"work-drafts:Entwürfe":     "work-drafts:Entwürfe",
+		"work-drafts:Entw&APw-rfe": "work-drafts:Entwürfe",
+		"cjk:INBOX.&ZeVnLIqe-":     "cjk:日本語",
+		"work:Lists.a:b":           "work:Lists/a:b",
+	} {
+		var vals []string
+		if arg != "" {
+			vals = strings.SplitN(arg, ":", 2)
+		}
+		if got, err := updateName(a, vals); err != nil || got != want {
+			t.Errorf("updateName(%q) %q %v expected %q", arg, got, err, want)
+		}
+	}
+	for _, arg := range []string{"work:", "work:Entw\xfcrfe"} {
+		if got, err := updateName(a, strings.SplitN(arg, ":", 2)); err == nil {
+			t.Errorf("updateName(%q) %q expected an error", arg, got)
+		}
+	}
+}
+
+func TestDumpValueRedacted(t *testing.T) {
+	out := &bytes.Buffer{}
+	std := log.StandardLogger()
+	savedOut, savedFormatter, savedLevel := std.Out, std.Formatter, log.GetLevel()
+	defer func() {
+		log.SetOutput(savedOut)
+		log.SetFormatter(savedFormatter)
+		log.SetLevel(savedLevel)
+	}()
+	logging.AddSecret("s3<r&t>")
+	defer logging.RemoveSecret("s3<r&t>")
+	for _, format := range []string{"text", "json"} {
+		if err := logging.Setup(log.DebugLevel, format, ""); err != nil {
+			t.Fatal(err)
+		}
+		log.SetOutput(out)
+		dumpValue(map[string]string{"Password": "s3<r&t>"})
+		if got := out.String(); strings.Contains(got, "s3") || !strings.Contains(got, logging.Redacted) {
+			t.Errorf("%s dump not redacted: %s", format, got)
+		}
+		out.Reset()
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	stores := map[string]bool{"work": true, "status": true}
+	isStore := func(name string) bool { return stores[name] }
+	for _, c := range []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"work"}, false},
+		{[]string{"list", "work"}, true},
+		{[]string{"watch"}, true},
+		{[]string{"status"}, false},
+		{[]string{"status:status-channel"}, false},
+	} {
+		if got := subcommand(c.args, isStore) != nil; got != c.want {
+			t.Errorf("subcommand(%q) %v expected %v", c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+// listTimeout bounds connecting, logging in and listing.
+const listTimeout = time.Minute
+
+// renderMailboxes writes a table of the mailboxes with their special use,
+// subscription and update script argument.
+func renderMailboxes(w io.Writer, a *watcher.Account, channel string, mailboxes []watcher.Mailbox, subscribed bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAILBOX\tUSE\tSUBSCRIBED\tCHANNEL")
+	for _, m := range mailboxes {
+		if subscribed && !m.Subscribed {
+			continue
+		}
+		use, sub, arg := m.SpecialUse(), "", ""
+		if use == "" {
+			use = "-"
+		}
+		if m.Subscribed {
+			sub = "yes"
+		} else {
+			sub = "no"
+		}
+		if m.Selectable() {
+			arg = a.ChannelArg(channel, m.Name)
+		} else {
+			arg = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Name, use, sub, arg)
+	}
+	return tw.Flush()
+}
+
+// listCmd lists the mailboxes of a store, or resolves the given targets
+// (mailboxes or special uses such as \Important) to update script arguments.
+func listCmd(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s list [options] store [mailbox|\\special-use...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	mbsyncrcFile := fs.String("mbsyncrc", "~/.mbsyncrc", "Location of mbsync config file")
+	configFile := fs.String("config", "~/.imapidlerc", "Location of imapidle config file, for the store's Proxy")
+	channel := fs.String("channel", "", "Channel of the update script arguments, the store's first channel if empty")
+	subscribed := fs.Bool("subscribed", false, "Only list subscribed mailboxes")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+
+	stores, err := mbsyncrc.ParseFile(*mbsyncrcFile, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	st, ok := stores[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "No IMAPStore %s in %s\n", name, *mbsyncrcFile)
+		return 1
+	}
+	config, err := watcher.ParseConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		return 1
+	}
+	a := &watcher.Account{
+		AccountConfig: st.Config,
+		Channels:      st.Channels,
+		Path:          st.Path,
+		PathDelimiter: st.PathDelimiter,
+		Store:         config.Store(name),
+	}
+	a.Name = name
+	if len(a.Channels) != 0 {
+		a.UpdateName = a.ChannelArg(a.Channels[0].Name, "INBOX")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+	if err := a.Login(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	defer a.Logout()
+	mailboxes, err := a.ListMailboxes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	if fs.NArg() == 1 {
+		if err := renderMailboxes(os.Stdout, a, *channel, mailboxes, *subscribed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	code := 0
+	for _, target := range fs.Args()[1:] {
+		mbox, err := watcher.NormalizeMailbox(target)
+		if err == nil {
+			mbox, err = watcher.ResolveMailbox(mailboxes, mbox)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", target, err)
+			code = 1
+			continue
+		}
+		fmt.Println(a.ChannelArg(*channel, mbox))
+	}
+	return code
+}
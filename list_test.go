@@ -0,0 +1,58 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+func TestRenderMailboxes(t *testing.T) {
+	a := &watcher.Account{UpdateName: "gmail:INBOX"}
+	mailboxes := []watcher.Mailbox{
+		{Name: "INBOX", Subscribed: true},
+		{Name: "[Gmail]", Attributes: []string{`\Noselect`, `\HasChildren`}},
+		{Name: "[Gmail]/Important", Attributes: []string{`\HasNoChildren`, `\Important`}},
+	}
+	var b bytes.Buffer
+	if err := renderMailboxes(&b, a, "", mailboxes, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `MAILBOX            USE         SUBSCRIBED  CHANNEL
+INBOX              \Inbox      yes         gmail:INBOX
+[Gmail]            -           no          -
+[Gmail]/Important  \Important  no          gmail:[Gmail]/Important
+`
+	if b.String() != want {
+		t.Errorf("Rendered:\n%s\nexpected:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := renderMailboxes(&b, a, "gmail-all", mailboxes, true); err != nil {
+		t.Fatal(err)
+	}
+	want = `MAILBOX  USE     SUBSCRIBED  CHANNEL
+INBOX    \Inbox  yes         gmail-all:INBOX
+`
+	if b.String() != want {
+		t.Errorf("Rendered:\n%s\nexpected:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,285 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logging sets up logrus for imapidle: output formats, per subsystem
+// levels and redaction of secrets.
+package logging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secrets in all log output.
+const Redacted = "[REDACTED]"
+
+// secrets holds strings that must never be logged.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// AddSecret registers a secret (e.g., PassCmd output) to be redacted from all
+// log output. The IMAP quoted and base64 forms are redacted as well as these
+// appear in protocol traces, and the JSON string forms for values logged as
+// JSON. Short secrets are redacted too even if that mangles unrelated output.
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, s := range secretForms(secret) {
+		found := false
+		for _, v := range secrets.values {
+			found = found || v == s
+		}
+		if !found {
+			secrets.values = append(secrets.values, s)
+		}
+	}
+	// Replace longer secrets first so a secret containing another is
+	// fully redacted.
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// RemoveSecret stops redacting a secret registered with AddSecret.
+func RemoveSecret(secret string) {
+	forms := secretForms(secret)
+	secrets.Lock()
+	defer secrets.Unlock()
+	values := secrets.values[:0]
+	for _, v := range secrets.values {
+		found := false
+		for _, s := range forms {
+			found = found || v == s
+		}
+		if !found {
+			values = append(values, v)
+		}
+	}
+	secrets.values = values
+}
+
+// secretForms returns the forms of secret that are redacted.
+func secretForms(secret string) []string {
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(secret)
+	encoded := base64.StdEncoding.EncodeToString([]byte(secret))
+	forms := []string{secret, quoted, encoded}
+	for _, escapeHTML := range []bool{true, false} {
+		b := &bytes.Buffer{}
+		enc := json.NewEncoder(b)
+		enc.SetEscapeHTML(escapeHTML)
+		if err := enc.Encode(secret); err == nil {
+			forms = append(forms, strings.Trim(strings.TrimSpace(b.String()), `"`))
+		}
+	}
+	return forms
+}
+
+func redact(b []byte) []byte {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, s := range secrets.values {
+		b = bytes.ReplaceAll(b, []byte(s), []byte(Redacted))
+	}
+	return b
+}
+
+func redactString(s string) string {
+	return string(redact([]byte(s)))
+}
+
+// redactFormatter wraps another formatter to remove secrets. The message and
+// fields are redacted before formatting as formatters escape them, e.g.,
+// JSON escapes <, > and &, and the output after for anything else.
+type redactFormatter struct {
+	log.Formatter
+}
+
+func (f *redactFormatter) Format(e *log.Entry) ([]byte, error) {
+	c := *e
+	c.Message = redactString(e.Message)
+	c.Data = make(log.Fields, len(e.Data))
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			c.Data[k] = redactString(v)
+		case error:
+			c.Data[k] = redactString(v.Error())
+		default:
+			c.Data[k] = v
+		}
+	}
+	b, err := f.Formatter.Format(&c)
+	return redact(b), err
+}
+
+// journaldFormatter writes sd-daemon(3) priority prefixed lines, journald
+// adds the timestamp itself.
+type journaldFormatter struct{}
+
+func (f *journaldFormatter) Format(e *log.Entry) ([]byte, error) {
+	prio := 7
+	switch e.Level {
+	case log.PanicLevel, log.FatalLevel:
+		prio = 2
+	case log.ErrorLevel:
+		prio = 3
+	case log.WarnLevel:
+		prio = 4
+	case log.InfoLevel:
+		prio = 6
+	}
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "<%d>%s", prio, strings.TrimRight(e.Message, "\n"))
+	for _, k := range keys {
+		fmt.Fprintf(b, " %s=%v", k, e.Data[k])
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// The logging setup, the level can be overridden per subsystem. Subsystems
+// are store names for the account logs, "imap" for the IMAP protocol trace of
+// all stores and "imap:<store>" for a single store.
+var logConfig = struct {
+	Level     log.Level
+	Levels    map[string]log.Level
+	Formatter log.Formatter
+}{
+	Level:  log.InfoLevel,
+	Levels: make(map[string]log.Level),
+}
+
+// Setup configures the standard logger with the default level, the output
+// format (text, json or journald) and the per subsystem levels given as
+// "[subsystem=]level,...".
+func Setup(level log.Level, format, levels string) error {
+	logConfig.Level = level
+	switch format {
+	case "text":
+		logConfig.Formatter = &log.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "01-02-2006 15:04:05.000",
+		}
+	case "json":
+		logConfig.Formatter = &log.JSONFormatter{}
+	case "journald":
+		logConfig.Formatter = &journaldFormatter{}
+	default:
+		return fmt.Errorf("Unknown log format %s", format)
+	}
+	logConfig.Formatter = &redactFormatter{logConfig.Formatter}
+
+	for _, spec := range strings.Split(levels, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		name := ""
+		if i := strings.LastIndex(spec, "="); i != -1 {
+			name, spec = spec[:i], spec[i+1:]
+		}
+		level, err := log.ParseLevel(spec)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			logConfig.Level = level
+		} else {
+			logConfig.Levels[name] = level
+		}
+	}
+
+	log.SetLevel(logConfig.Level)
+	log.SetFormatter(logConfig.Formatter)
+	return nil
+}
+
+// SubsystemLevel returns the log level for a subsystem.
+func SubsystemLevel(names ...string) log.Level {
+	for _, name := range names {
+		if level, ok := logConfig.Levels[name]; ok {
+			return level
+		}
+	}
+	return logConfig.Level
+}
+
+// TraceIMAP returns true if the IMAP protocol trace was asked for on a
+// store, it isn't enabled by the default level as it is very verbose.
+func TraceIMAP(store string) bool {
+	for _, name := range []string{"imap:" + store, "imap"} {
+		if level, ok := logConfig.Levels[name]; ok {
+			return level == log.TraceLevel
+		}
+	}
+	return false
+}
+
+// NewLogger returns a logger sharing the standard logger's output and
+// formatting with the level set for the first subsystem configured.
+func NewLogger(names ...string) *log.Logger {
+	std := log.StandardLogger()
+	return &log.Logger{
+		Out:          std.Out,
+		Hooks:        std.Hooks,
+		Formatter:    std.Formatter,
+		ReportCaller: std.ReportCaller,
+		Level:        SubsystemLevel(names...),
+		ExitFunc:     std.ExitFunc,
+	}
+}
+
+// LineWriter logs each line written to it at trace level, used for the IMAP
+// protocol trace.
+type LineWriter struct {
+	Log  *log.Entry
+	lock sync.Mutex // client reads and writes are traced concurrently
+	buf  []byte
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		w.Log.Trace(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,121 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package logging sets up logrus for imapidle: output formats, per subsystem
+package logging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// format returns an entry formatted by a redacting formatter.
+func format(t *testing.T, f log.Formatter, msg string, fields log.Fields) string {
+	t.Helper()
+	e := log.NewEntry(log.New()).WithFields(fields)
+	e.Message = msg
+	e.Level = log.InfoLevel
+	b, err := (&redactFormatter{f}).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAddSecret(t *testing.T) {
+	secret := `pa"ss\word`
+	AddSecret(secret)
+	for _, msg := range []string{
+		"PassCmd returned " + secret,
+		`LOGIN user "pa\"ss\\word"`,
+		"AUTHENTICATE PLAIN " + base64.StdEncoding.EncodeToString([]byte(secret)),
+	} {
+		out := format(t, &journaldFormatter{}, msg, nil)
+		if strings.Contains(out, "word") || !strings.Contains(out, Redacted) {
+			t.Errorf("%q not redacted: %q", msg, out)
+		}
+	}
+}
+
+func TestAddSecretShort(t *testing.T) {
+	AddSecret("q7")
+	if out := format(t, &journaldFormatter{}, "password q7", nil); strings.Contains(out, "q7") {
+		t.Errorf("Short secret not redacted: %q", out)
+	}
+	AddSecret("")
+	if out := format(t, &journaldFormatter{}, "nothing", nil); out != "<6>nothing\n" {
+		t.Errorf("Empty secret redacted: %q", out)
+	}
+}
+
+func TestRemoveSecret(t *testing.T) {
+	AddSecret("gone<1>")
+	AddSecret("kept")
+	RemoveSecret("gone<1>")
+	defer RemoveSecret("kept")
+	out := format(t, &log.JSONFormatter{}, "gone<1> kept", nil)
+	if !strings.Contains(out, "gone") || strings.Contains(out, "kept") {
+		t.Errorf("Got %s", out)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	secret := "<b&d>pass"
+	AddSecret(secret)
+	out := format(t, &log.JSONFormatter{}, "password "+secret, log.Fields{
+		"pass": secret,
+		"err":  errors.New("bad " + secret),
+	})
+	if strings.Contains(out, "b\\u0026d") || strings.Contains(out, "b&d") || strings.Count(out, Redacted) != 3 {
+		t.Errorf("Not redacted: %s", out)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &fields); err != nil {
+		t.Errorf("Bad JSON %q: %v", out, err)
+	}
+
+	// Values logged as JSON, e.g., -dump-config
+	b, err := json.Marshal(struct{ Password string }{secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := format(t, &log.TextFormatter{}, string(b), nil); strings.Contains(out, "\\u003e") {
+		t.Errorf("JSON value not redacted: %s", out)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	AddSecret("hunter2")
+	logger := log.New()
+	logger.Level = log.TraceLevel
+	logger.Formatter = &redactFormatter{&journaldFormatter{}}
+	out := &bytes.Buffer{}
+	logger.Out = out
+	w := &LineWriter{Log: log.NewEntry(logger)}
+	w.Write([]byte("a1 LOGIN user hun"))
+	w.Write([]byte("ter2\r\na2 NOOP\r\n"))
+	if got := out.String(); got != "<7>a1 LOGIN user "+Redacted+"\n<7>a2 NOOP\n" {
+		t.Errorf("Unexpected trace %q", got)
+	}
+}
@@ -0,0 +1,480 @@
+// -*- coding: utf-8 -*-
+//
+// April 24 2021, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2021, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package mbsyncrc parses the IMAP accounts, stores and channels from an
+// mbsync(1) configuration file.
+package mbsyncrc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var userInfo *user.User
+
+// ExpandTilde expands a leading ~ in path to the user's home directory. The
+// path is returned unchanged if the home directory can't be found.
+func ExpandTilde(path string) string {
+	var err error
+	var home string
+	if userInfo == nil {
+		if userInfo, err = user.Current(); err != nil {
+			userInfo = nil
+			if home, err = os.UserHomeDir(); err != nil {
+				log.Warnf("expandTilde: %v", err)
+				return path
+			}
+		}
+	}
+	if userInfo != nil {
+		home = userInfo.HomeDir
+	}
+
+	if i := strings.Index(path, "/~/"); i != -1 {
+		return strings.Join([]string{home, path[i+3:]}, "/")
+	} else if ok := strings.HasPrefix(path, "~/"); ok {
+		return strings.Join([]string{home, path[2:]}, "/")
+	} else if ok := strings.HasSuffix(path, "/~"); ok {
+		return home
+	} else if path == "~" {
+		return home
+	}
+	return path
+}
+
+// SplitValues splits config values the way mbsync does: values are separated
+// by white space unless double quoted, quotes may start and end anywhere in a
+// value, a backslash escapes the next character and a # starting a value
+// starts a comment.
+func SplitValues(s string) ([]string, error) {
+	var values []string
+	var b strings.Builder
+	inValue, escaped, quoted := false, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+			b.WriteByte(c)
+		case c == '\\':
+			escaped, inValue = true, true
+		case c == '"':
+			quoted, inValue = !quoted, true
+		case !quoted && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inValue {
+				values = append(values, b.String())
+				b.Reset()
+				inValue = false
+			}
+		case !inValue && c == '#':
+			return values, nil
+		default:
+			inValue = true
+			b.WriteByte(c)
+		}
+	}
+	if escaped {
+		return nil, errors.New("unterminated escape sequence")
+	}
+	if quoted {
+		return nil, errors.New("missing closing quote")
+	}
+	if inValue {
+		values = append(values, b.String())
+	}
+	return values, nil
+}
+
+// Keyword returns the keyword starting a config line.
+func Keyword(line string) string {
+	if f := strings.Fields(line); len(f) != 0 {
+		return f[0]
+	}
+	return ""
+}
+
+// CheckQuotes returns an error if the values on a config line aren't
+// correctly quoted by mbsync's rules. GetValue and GetValues don't unquote
+// such lines.
+func CheckQuotes(line string) error {
+	_, err := SplitValues(line)
+	return err
+}
+
+// checkKeywords returns CheckQuotes of a line if it starts with one of the
+// keywords, lines that aren't read aren't checked.
+func checkKeywords(line string, keywords []string) error {
+	kw := Keyword(line)
+	for _, k := range keywords {
+		if kw == k {
+			return CheckQuotes(line)
+		}
+	}
+	return nil
+}
+
+// GetValue returns the value following keyword on a config line, or false if
+// the line isn't for keyword. Quotes around a single value are removed.
+func GetValue(line, keyword string) (bool, string) {
+	l := strings.TrimSpace(line)
+	if !strings.HasPrefix(l, keyword) {
+		return false, ""
+	}
+	l = l[len(keyword):]
+	// Check for keyword only
+	if len(l) == 0 {
+		return true, ""
+	}
+	// Make sure this isn't just a prefix of a keyword
+	if l[0] != '\t' && l[0] != ' ' {
+		return false, ""
+	}
+	l = l[1:]
+
+	// Unquote a single value
+	if values, err := SplitValues(l); err == nil && len(values) == 1 {
+		return true, values[0]
+	}
+	return true, strings.TrimSpace(l)
+}
+
+// GetValues is GetValue for a space separated list of (possibly quoted)
+// values.
+func GetValues(line, keyword string) (bool, []string) {
+	ok, l := GetValue(line, keyword)
+	if !ok {
+		return false, []string{}
+	} else if l == "" {
+		return true, []string{}
+	}
+	values, err := SplitValues(l)
+	if err != nil {
+		values = strings.Fields(l)
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return true, values
+}
+
+// The keywords ParseFile reads by section, the quoting of others isn't
+// checked.
+var (
+	sectionKeywords = []string{"IMAPAccount", "IMAPStore", "Channel"}
+	channelKeywords = []string{"Far"}
+	accountKeywords = []string{"Host", "PassCmd", "Password", "AuthMechs", "Port", "SSLType", "SSLVersion",
+		"User", "CertificateFile"}
+	storeKeywords = append([]string{"Account", "Path", "PathDelimiter"}, accountKeywords...)
+)
+
+// AccountConfig is an IMAPAccount, or the account settings of an IMAPStore.
+type AccountConfig struct {
+	Name       string
+	Host       string
+	Port       int
+	StartTLS   bool
+	SSLVersion string
+	User       string
+	PassCmd    string
+	UseXOAuth2 bool
+	CertFile   string // CertificateFile of additional trusted certificates
+	Password   string `json:"-"` // Password or PassCmd output if run
+}
+
+// Channel is a Channel, only the Far store is of interest.
+type Channel struct {
+	Name string
+	Far  string
+}
+
+// IMAPStore is an IMAPStore with its account config and channels.
+type IMAPStore struct {
+	Name          string
+	Account       string
+	Config        AccountConfig
+	Path          string     // prefix of the mailbox names mbsync syncs
+	PathDelimiter string     // server hierarchy delimiter if given
+	Channels      []*Channel // config ordered channel list
+}
+
+// RunPassCmd runs a PassCmd returning the password, the command is killed if
+// ctx is done first.
+func RunPassCmd(ctx context.Context, cmdstr string) (string, error) {
+	// Get the password
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, bashPath, "-c", cmdstr)
+	var o []byte
+	if o, err = cmd.Output(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(o)), nil
+}
+
+func finishAccountConfig(name string, config *AccountConfig, runPassCmd bool) error {
+	if config.Host == "" {
+		return fmt.Errorf("Host required for %v", name)
+	}
+	if config.User == "" {
+		return fmt.Errorf("User required for %v", name)
+	}
+	if config.Password == "" && config.PassCmd == "" {
+		return fmt.Errorf("Password or PassCmd required for %v", name)
+	}
+	if runPassCmd && config.Password == "" {
+		var err error
+		if config.Password, err = RunPassCmd(context.Background(), config.PassCmd); err != nil {
+			return err
+		}
+	}
+	if config.Port == 0 {
+		config.Port = 993
+		if config.SSLVersion == "" {
+			config.SSLVersion = "TLSv1.2"
+		}
+	}
+	return nil
+}
+
+// ParseFile parses the mbsync config file returning the IMAP stores by name.
+// If runPassCmd is true the PassCmds are run to fill in the passwords.
+func ParseFile(fileName string, runPassCmd bool) (map[string]*IMAPStore, error) {
+	var accounts = make(map[string]*AccountConfig)
+	var stores = make(map[string]*IMAPStore)
+	var channels = make(map[string]*Channel)
+	var chlist []*Channel
+
+	f, err := os.Open(ExpandTilde(fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	finishSection := func(a *AccountConfig, st *IMAPStore, ch *Channel) error {
+		if a != nil {
+			if err := finishAccountConfig(a.Name, a, runPassCmd); err != nil {
+				return err
+			}
+			accounts[a.Name] = a
+		} else if st != nil {
+			if st.Account == "" {
+				if err := finishAccountConfig(st.Name, &st.Config, runPassCmd); err != nil {
+					return err
+				}
+			}
+			stores[st.Name] = st
+		} else if ch != nil {
+			if ch.Far == "" {
+				return fmt.Errorf("No Far given for Channel %v", ch.Name)
+			}
+			channels[ch.Name] = ch
+			chlist = append(chlist, ch)
+		}
+		return nil
+	}
+
+	// Parse the file creating accounts
+	var a *AccountConfig = nil
+	var st *IMAPStore = nil
+	var ch *Channel = nil
+	otherSection := false
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno += 1
+
+		l := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		var keywords []string
+		switch {
+		case ch != nil:
+			keywords = channelKeywords
+		case st != nil:
+			keywords = storeKeywords
+		case a != nil:
+			keywords = accountKeywords
+		case !otherSection:
+			keywords = sectionKeywords
+		}
+		if err := checkKeywords(l, keywords); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, lineno, err)
+		}
+
+		// Blank lines terminate a section
+		if l == "" {
+			if err := finishSection(a, st, ch); err != nil {
+				return nil, err
+			}
+			a = nil
+			st = nil
+			ch = nil
+			otherSection = false
+			continue
+		}
+
+		// Not in any section, only look for section starts
+		if a == nil && st == nil && ch == nil && !otherSection {
+			if ok, v := GetValue(l, "IMAPAccount"); ok {
+				if _, ok := accounts[v]; ok {
+					return nil, fmt.Errorf("Duplicate Account %v", v)
+				}
+				log.Debugf("Adding account %v", v)
+				a = &AccountConfig{
+					Name: v,
+				}
+			} else if ok, v := GetValue(l, "IMAPStore"); ok {
+				if _, ok := stores[v]; ok {
+					return nil, fmt.Errorf("Duplicate IMAPStore %v", v)
+				}
+				log.Debugf("Adding IMAPStore %v", v)
+				st = &IMAPStore{
+					Name: v,
+				}
+			} else if ok, v := GetValue(l, "Channel"); ok {
+				if _, ok := channels[v]; ok {
+					return nil, fmt.Errorf("Duplicate Channel %v", v)
+				}
+				log.Debugf("Adding Channel %v", v)
+				ch = &Channel{
+					Name: v,
+				}
+			} else {
+				log.Debugf("%d: Skipping past section: \"%v\"", lineno, l)
+				otherSection = true
+			}
+			continue
+		}
+
+		if ch != nil {
+			if ok, v := GetValue(l, "Far"); ok {
+				if ch.Far != "" {
+					return nil, fmt.Errorf("Multiple Far specified for channel %v", ch.Name)
+				}
+				ch.Far = v
+			}
+			continue
+		} else if a != nil || st != nil {
+			if st != nil {
+				a = &st.Config
+			}
+			// First handle the account values that might occur in
+			// the IMAPStore a well
+			if ok, v := GetValue(l, "Host"); ok {
+				a.Host = v
+			} else if ok, v := GetValue(l, "PassCmd"); ok {
+				a.PassCmd = v
+			} else if ok, v := GetValue(l, "Password"); ok {
+				a.Password = v
+			} else if ok, v := GetValue(l, "AuthMechs"); ok {
+				a.UseXOAuth2 = (v == "XOAUTH2")
+			} else if ok, v := GetValue(l, "Port"); ok {
+				if a.Port, err = strconv.Atoi(v); err != nil {
+					return nil, err
+				}
+			} else if ok, v := GetValue(l, "SSLType"); ok {
+				if v == "STARTTLS" {
+					a.StartTLS = true
+					if a.Port == 0 {
+						a.Port = 143
+					}
+				} else if v == "IMAPS" {
+					if a.Port == 0 {
+						a.Port = 993
+					}
+				} else {
+					return nil, fmt.Errorf("Unknown SSLType %s", v)
+				}
+				if a.SSLVersion == "" {
+					a.SSLVersion = "TLSv1.2"
+				}
+			} else if ok, v := GetValue(l, "SSLVersion"); ok {
+				a.SSLVersion = v
+				if v != "None" {
+					if a.Port == 0 {
+						a.Port = 993
+					}
+				}
+			} else if ok, v := GetValue(l, "User"); ok {
+				a.User = v
+			} else if ok, v := GetValue(l, "CertificateFile"); ok {
+				a.CertFile = ExpandTilde(v)
+			}
+
+			// If we are processing an actual account we are done
+			if st == nil {
+				continue
+			}
+
+			// Forget the store account config
+			a = nil
+			if ok, v := GetValue(l, "Account"); ok {
+				st.Account = v
+			} else if ok, v := GetValue(l, "Path"); ok {
+				st.Path = v
+			} else if ok, v := GetValue(l, "PathDelimiter"); ok {
+				st.PathDelimiter = v
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// EOF also finishes the section
+	if err := finishSection(a, st, ch); err != nil {
+		return nil, err
+	}
+
+	// Now go back over all stores and copy any account config if referenced
+	for k, v := range stores {
+		if v.Account != "" {
+			if a, ok := accounts[v.Account]; !ok {
+				return nil, fmt.Errorf("Store %v specifies non-existent account %v", k, v.Account)
+			} else {
+				v.Config = *a
+			}
+		}
+	}
+
+	// Create config ordered list of channels per store
+	for i := range chlist {
+		ch := chlist[i]
+		far := ch.Far[1 : len(ch.Far)-1]
+		st, ok := stores[far]
+		if !ok {
+			return nil, fmt.Errorf("Channel specifies non-existent Far store %v", far)
+		}
+		st.Channels = append(st.Channels, ch)
+	}
+
+	return stores, nil
+}
@@ -0,0 +1,180 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mbsyncrc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `# Account shared by a store
+IMAPAccount work
+Host imap.example.com
+User "me@example.com"
+PassCmd "pass show work"
+SSLType IMAPS
+
+IMAPStore work-remote
+Account work
+Path INBOX.
+PathDelimiter .
+
+# Store with inline account settings
+IMAPStore home-remote
+Host mail.example.org
+User me
+Password secret
+SSLType STARTTLS
+AuthMechs XOAUTH2
+
+MaildirStore work-local
+Path ~/Mail/work/
+
+Channel work-inbox
+Far :work-remote:
+Near :work-local:
+Patterns INBOX
+
+Channel work-lists
+Far :work-remote:
+Patterns * !"[Gmail]/All Mail"
+
+Channel home
+Far :home-remote:
+`
+
+func TestParseFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "mbsyncrc")
+	if err := ioutil.WriteFile(fileName, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stores, err := ParseFile(fileName, false)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if len(stores) != 2 {
+		t.Fatalf("Got %d stores expected 2", len(stores))
+	}
+
+	work := stores["work-remote"]
+	want := AccountConfig{
+		Name:       "work",
+		Host:       "imap.example.com",
+		Port:       993,
+		SSLVersion: "TLSv1.2",
+		User:       "me@example.com",
+		PassCmd:    "pass show work",
+	}
+	if !reflect.DeepEqual(work.Config, want) {
+		t.Errorf("work-remote config %+v expected %+v", work.Config, want)
+	}
+	if work.Path != "INBOX." || work.PathDelimiter != "." {
+		t.Errorf("work-remote Path %q PathDelimiter %q", work.Path, work.PathDelimiter)
+	}
+	if len(work.Channels) != 2 || work.Channels[0].Name != "work-inbox" || work.Channels[1].Name != "work-lists" {
+		t.Errorf("work-remote channels not in config order: %v", work.Channels)
+	}
+
+	home := stores["home-remote"]
+	// Inline account settings don't have a name
+	want = AccountConfig{
+		Host:       "mail.example.org",
+		Port:       143,
+		StartTLS:   true,
+		SSLVersion: "TLSv1.2",
+		User:       "me",
+		UseXOAuth2: true,
+		Password:   "secret",
+	}
+	if !reflect.DeepEqual(home.Config, want) {
+		t.Errorf("home-remote config %+v expected %+v", home.Config, want)
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	for _, config := range []string{
+		"IMAPStore s\nHost h\nUser u\n",
+		"IMAPStore s\nAccount missing\n",
+		"IMAPStore s\nHost h\nUser u\nPassword p\nSSLType Bogus\n",
+		"Channel c\nFar :missing:\n",
+		"IMAPStore s\nHost \"h\nUser u\nPassword p\n",
+		"IMAPStore s\nHost h\nUser u\nPassword p\\\n",
+	} {
+		fileName := filepath.Join(t.TempDir(), "mbsyncrc")
+		if err := ioutil.WriteFile(fileName, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseFile(fileName, false); err == nil {
+			t.Errorf("No error parsing %q", config)
+		}
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	ok, v := GetValues(`Rule urgent  From "a b"`, "Rule")
+	if !ok || !reflect.DeepEqual(v, []string{"urgent", "From", "a b"}) {
+		t.Errorf("GetValues returned %v %q", ok, v)
+	}
+	ok, v = GetValues(`Patterns * !"[Gmail]/All Mail"`, "Patterns")
+	if !ok || !reflect.DeepEqual(v, []string{"*", "![Gmail]/All Mail"}) {
+		t.Errorf("GetValues returned %v %q", ok, v)
+	}
+	if ok, _ := GetValues("Rules x", "Rule"); ok {
+		t.Errorf("GetValues matched keyword prefix")
+	}
+}
+
+func TestGetValueBadQuote(t *testing.T) {
+	if err := CheckQuotes(`Host "imap.example.com`); err == nil {
+		t.Errorf("No error for unterminated quote")
+	}
+	if err := CheckQuotes(`PassCmd "pass show work"`); err != nil {
+		t.Errorf("CheckQuotes: %v", err)
+	}
+	// Badly quoted values are returned as is
+	if ok, v := GetValue(`Host "imap.example.com`, "Host"); !ok || v != `"imap.example.com` {
+		t.Errorf("GetValue returned %v %q", ok, v)
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{`*  !"[Gmail]/All Mail"`, []string{"*", "![Gmail]/All Mail"}},
+		{`"pass show \"work\""`, []string{`pass show "work"`}},
+		{`a\ b c\\`, []string{"a b", `c\`}},
+		{`a # comment`, []string{"a"}},
+		{`a#b`, []string{"a#b"}},
+		{``, nil},
+	} {
+		got, err := SplitValues(tc.line)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitValues(%q) returned %q %v expected %q", tc.line, got, err, tc.want)
+		}
+	}
+	for _, line := range []string{`"a`, `a\`, `a "b c`} {
+		if _, err := SplitValues(line); err == nil {
+			t.Errorf("No error for %q", line)
+		}
+	}
+}
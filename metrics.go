@@ -0,0 +1,186 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choppsv1/imapidle/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScriptStats tracks runs of the update script.
+type ScriptStats struct {
+	lock     sync.Mutex
+	runs     map[int]int // runs by exit code, -1 if the script couldn't run
+	seconds  float64     // total run time
+	lastRun  time.Time
+	lastCode int
+}
+
+var scriptStats = &ScriptStats{runs: make(map[int]int)}
+
+func (ss *ScriptStats) record(code int, d time.Duration) {
+	ss.lock.Lock()
+	ss.runs[code]++
+	ss.seconds += d.Seconds()
+	ss.lastRun = time.Now()
+	ss.lastCode = code
+	ss.lock.Unlock()
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, accounts map[string]*watcher.Account) {
+	names := make([]string, 0, len(accounts))
+	for k := range accounts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	stats := make([]watcher.AccountStats, len(names))
+	for i, k := range names {
+		stats[i] = accounts[k].Stats()
+	}
+	writeStoreMetrics(w, names, stats)
+	scriptStats.write(w)
+}
+
+// writeStoreMetrics writes the metrics of the stores given their names and
+// stats.
+func writeStoreMetrics(w io.Writer, names []string, stats []watcher.AccountStats) {
+	metric := func(name, typ, help string, value func(s *watcher.AccountStats) interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for i := range names {
+			if v := value(&stats[i]); v != nil {
+				fmt.Fprintf(w, "%s{store=\"%s\"} %v\n", name, escapeLabel(names[i]), v)
+			}
+		}
+	}
+	metric("imapidle_connected", "gauge", "Whether the store is connected and logged in.",
+		func(s *watcher.AccountStats) interface{} { return boolValue(s.Connected) })
+	metric("imapidle_idling", "gauge", "Whether the store is running the IDLE command.",
+		func(s *watcher.AccountStats) interface{} { return boolValue(s.Idling) })
+	metric("imapidle_reconnects_total", "counter", "Number of successful logins after the first.",
+		func(s *watcher.AccountStats) interface{} { return s.Reconnects })
+	metric("imapidle_login_failures_total", "counter", "Number of failed connects or logins.",
+		func(s *watcher.AccountStats) interface{} { return s.LoginFailures })
+	metric("imapidle_panics_total", "counter", "Number of internal errors the store was restarted after.",
+		func(s *watcher.AccountStats) interface{} { return s.Panics })
+	metric("imapidle_idle_refreshes_total", "counter", "Number of IDLE commands refreshed.",
+		func(s *watcher.AccountStats) interface{} { return s.IdleRefreshes })
+	metric("imapidle_unseen_messages", "gauge", "Number of unseen messages in the INBOX.",
+		func(s *watcher.AccountStats) interface{} {
+			if c, ok := s.Counts["INBOX"]; ok {
+				return c.Unseen
+			}
+			return nil
+		})
+	metric("imapidle_last_idle_age_seconds", "gauge", "Seconds since IDLE was last successfully started.",
+		func(s *watcher.AccountStats) interface{} {
+			if s.LastIdle.IsZero() {
+				return nil
+			}
+			return time.Since(s.LastIdle).Seconds()
+		})
+
+	name := "imapidle_updates_total"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, "Number of updates received from the server by type.", name)
+	for i := range names {
+		types := make([]string, 0, len(stats[i].Updates))
+		for t := range stats[i].Updates {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			fmt.Fprintf(w, "%s{store=\"%s\",type=\"%s\"} %d\n", name, escapeLabel(names[i]), escapeLabel(t), stats[i].Updates[t])
+		}
+	}
+
+	name = "imapidle_connect_phase_seconds"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, "Time each phase of the last login took.", name)
+	for i := range names {
+		if stats[i].Logins == 0 {
+			continue
+		}
+		for _, p := range connectPhases(stats[i].Connect) {
+			fmt.Fprintf(w, "%s{store=\"%s\",phase=\"%s\"} %v\n", name, escapeLabel(names[i]), p.name, p.d.Seconds())
+		}
+	}
+
+}
+
+// write writes the update script metrics.
+func (ss *ScriptStats) write(w io.Writer) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	codes := make([]int, 0, len(ss.runs))
+	count := 0
+	for c, n := range ss.runs {
+		codes = append(codes, c)
+		count += n
+	}
+	sort.Ints(codes)
+	name := "imapidle_update_script_runs_total"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, "Number of update script runs by exit code.", name)
+	for _, c := range codes {
+		fmt.Fprintf(w, "%s{exit_code=\"%d\"} %d\n", name, c, ss.runs[c])
+	}
+	name = "imapidle_update_script_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, "Time spent running the update script.", name)
+	fmt.Fprintf(w, "%s_sum %v\n%s_count %d\n", name, ss.seconds, name, count)
+	if !ss.lastRun.IsZero() {
+		name = "imapidle_update_script_last_run_timestamp_seconds"
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, "Time the update script last ran.", name)
+		fmt.Fprintf(w, "%s %d\n", name, ss.lastRun.Unix())
+		name = "imapidle_update_script_last_exit_code"
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, "Exit code of the last update script run.", name)
+		fmt.Fprintf(w, "%s %d\n", name, ss.lastCode)
+	}
+}
+
+// serveMetrics serves /metrics on l until the process exits.
+func serveMetrics(l net.Listener, accounts map[string]*watcher.Account) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, accounts)
+	})
+	log.Infof("Serving metrics on http://%s/metrics", l.Addr())
+	if err := http.Serve(l, mux); err != nil {
+		log.Errorf("Metrics listener failed: %v", err)
+	}
+}
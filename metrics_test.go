@@ -0,0 +1,123 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	names := []string{"plain", `we"ird\store`}
+	stats := []watcher.AccountStats{
+		{
+			Connected:     true,
+			Idling:        true,
+			Logins:        3,
+			Reconnects:    2,
+			LoginFailures: 1,
+			IdleRefreshes: 4,
+			Updates:       map[string]int{"mailbox": 5, "expunge": 1},
+			Counts:        map[string]watcher.Counts{"INBOX": {Messages: 10, Unseen: 7}},
+			Connect:       watcher.ConnectTimes{DNS: 10 * time.Millisecond, Auth: 250 * time.Millisecond},
+		},
+		{Panics: 1},
+	}
+	ss := &ScriptStats{runs: make(map[int]int)}
+	ss.record(0, 2*time.Second)
+	ss.record(0, time.Second)
+	ss.record(1, 500*time.Millisecond)
+	b := &bytes.Buffer{}
+	writeStoreMetrics(b, names, stats)
+	ss.write(b)
+	out := b.String()
+
+	for _, want := range []string{
+		`imapidle_connected{store="plain"} 1`,
+		`imapidle_connected{store="we\"ird\\store"} 0`,
+		`imapidle_idling{store="plain"} 1`,
+		`imapidle_reconnects_total{store="plain"} 2`,
+		`imapidle_login_failures_total{store="plain"} 1`,
+		`imapidle_panics_total{store="we\"ird\\store"} 1`,
+		`imapidle_idle_refreshes_total{store="plain"} 4`,
+		`imapidle_unseen_messages{store="plain"} 7`,
+		`imapidle_updates_total{store="plain",type="expunge"} 1`,
+		`imapidle_updates_total{store="plain",type="mailbox"} 5`,
+		`imapidle_connect_phase_seconds{store="plain",phase="dns"} 0.01`,
+		`imapidle_connect_phase_seconds{store="plain",phase="auth"} 0.25`,
+		`imapidle_update_script_runs_total{exit_code="0"} 2`,
+		`imapidle_update_script_runs_total{exit_code="1"} 1`,
+		`imapidle_update_script_duration_seconds_sum 3.5`,
+		`imapidle_update_script_duration_seconds_count 3`,
+		`imapidle_update_script_last_exit_code 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Missing %q in:\n%s", want, out)
+		}
+	}
+	// Stores without values are left out
+	for _, unwanted := range []string{
+		`imapidle_unseen_messages{store="we`,
+		`imapidle_last_idle_age_seconds{`,
+		`imapidle_connect_phase_seconds{store="we`,
+	} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("Unexpected %q in:\n%s", unwanted, out)
+		}
+	}
+
+	// Each metric has HELP then TYPE before its samples
+	types := map[string]string{
+		"imapidle_connected":                      "gauge",
+		"imapidle_reconnects_total":               "counter",
+		"imapidle_updates_total":                  "counter",
+		"imapidle_connect_phase_seconds":          "gauge",
+		"imapidle_update_script_runs_total":       "counter",
+		"imapidle_update_script_duration_seconds": "summary",
+	}
+	typed := make(map[string]string)
+	help := ""
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			help = f[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			if f[2] != help {
+				t.Errorf("TYPE of %s not after its HELP", f[2])
+			}
+			typed[f[2]] = f[3]
+		default:
+			name := strings.SplitN(f[0], "{", 2)[0]
+			name = strings.TrimSuffix(strings.TrimSuffix(name, "_sum"), "_count")
+			if _, ok := typed[name]; !ok {
+				t.Errorf("Sample %q before its TYPE", line)
+			}
+		}
+	}
+	for name, typ := range types {
+		if typed[name] != typ {
+			t.Errorf("%s has type %q expected %q", name, typed[name], typ)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package power tracks whether the machine is running on battery or on a
+// metered network connection.
+package power
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefPowerSupply is where the kernel lists the power supplies.
+	DefPowerSupply = "/sys/class/power_supply"
+	DefInterval    = time.Minute
+)
+
+// Status is the power and network state.
+type Status struct {
+	OnBattery bool
+	Metered   bool
+}
+
+// Constrained returns true if imapidle should save power or data.
+func (s Status) Constrained() bool {
+	return s.OnBattery || s.Metered
+}
+
+func (s Status) String() string {
+	var c []string
+	if s.OnBattery {
+		c = append(c, "on battery")
+	}
+	if s.Metered {
+		c = append(c, "metered")
+	}
+	if len(c) == 0 {
+		return "unconstrained"
+	}
+	return strings.Join(c, ", ")
+}
+
+func readAttr(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// OnBattery returns true if the machine has a battery and no external power
+// supply is online, see the sysfs-class-power ABI.
+func OnBattery(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	battery, online := false, false
+	for _, e := range entries {
+		psDir := filepath.Join(dir, e.Name())
+		switch readAttr(psDir, "type") {
+		case "Battery":
+			// Peripherals (mice etc.) have batteries too
+			if readAttr(psDir, "scope") != "Device" {
+				battery = true
+			}
+		case "Mains", "USB", "UPS":
+			if readAttr(psDir, "online") == "1" {
+				online = true
+			}
+		}
+	}
+	return battery && !online, nil
+}
+
+// NetworkManager's NMMetered values that count as metered.
+const (
+	nmMeteredYes      = 1
+	nmMeteredGuessYes = 3
+)
+
+// Metered returns true if NetworkManager says the primary connection is
+// metered.
+func Metered(conn *dbus.Conn) (bool, error) {
+	v, err := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager").
+		GetProperty("org.freedesktop.NetworkManager.Metered")
+	if err != nil {
+		return false, err
+	}
+	m, _ := v.Value().(uint32)
+	return m == nmMeteredYes || m == nmMeteredGuessYes, nil
+}
+
+// Monitor polls the power and network state.
+type Monitor struct {
+	Interval  time.Duration
+	OnBattery func() (bool, error) // nil to ignore power
+	Metered   func() (bool, error) // nil to ignore metering
+}
+
+// NewMonitor returns a monitor for the power supplies in sysfs and, if bus
+// isn't nil, NetworkManager's metered property.
+func NewMonitor(bus *dbus.Conn) *Monitor {
+	m := &Monitor{
+		Interval: DefInterval,
+		OnBattery: func() (bool, error) {
+			return OnBattery(DefPowerSupply)
+		},
+	}
+	if bus != nil {
+		m.Metered = func() (bool, error) {
+			return Metered(bus)
+		}
+	}
+	return m
+}
+
+// Status returns the current state, errors are logged and count as
+// unconstrained.
+func (m *Monitor) Status() Status {
+	var s Status
+	var err error
+	if m.OnBattery != nil {
+		if s.OnBattery, err = m.OnBattery(); err != nil {
+			log.Debugf("power: %v", err)
+		}
+	}
+	if m.Metered != nil {
+		if s.Metered, err = m.Metered(); err != nil {
+			log.Debugf("metered: %v", err)
+		}
+	}
+	return s
+}
+
+// Run sends the state on c, then again each time it changes, until ctx is
+// done.
+func (m *Monitor) Run(ctx context.Context, c chan<- Status) {
+	last := m.Status()
+	select {
+	case c <- last:
+	case <-ctx.Done():
+		return
+	}
+	t := time.NewTicker(m.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if s := m.Status(); s != last {
+			last = s
+			select {
+			case c <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
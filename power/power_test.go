@@ -0,0 +1,106 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package power
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// powerSupply adds a sysfs power supply with the given attributes.
+func powerSupply(t *testing.T, dir, name string, attrs map[string]string) {
+	psDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(psDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range attrs {
+		if err := ioutil.WriteFile(filepath.Join(psDir, k), []byte(v+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOnBattery(t *testing.T) {
+	dir := t.TempDir()
+	check := func(want bool) {
+		t.Helper()
+		if got, err := OnBattery(dir); err != nil || got != want {
+			t.Errorf("OnBattery %v (%v) expected %v", got, err, want)
+		}
+	}
+
+	// A desktop
+	check(false)
+	powerSupply(t, dir, "hidpp_battery_0", map[string]string{"type": "Battery", "scope": "Device"})
+	check(false)
+
+	powerSupply(t, dir, "BAT0", map[string]string{"type": "Battery", "status": "Discharging"})
+	check(true)
+	powerSupply(t, dir, "AC", map[string]string{"type": "Mains", "online": "1"})
+	check(false)
+	powerSupply(t, dir, "AC", map[string]string{"type": "Mains", "online": "0"})
+	check(true)
+
+	if on, err := OnBattery(filepath.Join(dir, "missing")); on || err != nil {
+		t.Errorf("OnBattery without sysfs %v %v", on, err)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	var lock sync.Mutex
+	battery := false
+	m := &Monitor{
+		Interval: 10 * time.Millisecond,
+		OnBattery: func() (bool, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return battery, nil
+		},
+		Metered: func() (bool, error) {
+			return true, nil
+		},
+	}
+	c := make(chan Status)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx, c)
+
+	if s := <-c; s != (Status{Metered: true}) || !s.Constrained() {
+		t.Errorf("Initial status %v", s)
+	}
+	lock.Lock()
+	battery = true
+	lock.Unlock()
+	if s := <-c; s != (Status{OnBattery: true, Metered: true}) {
+		t.Errorf("Changed status %v", s)
+	}
+	select {
+	case s := <-c:
+		t.Errorf("Unchanged status sent %v", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if (Status{}).Constrained() || (Status{}).String() != "unconstrained" {
+		t.Errorf("Bad empty status")
+	}
+}
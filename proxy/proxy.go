@@ -0,0 +1,420 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package proxy dials connections through SOCKS5 (RFC 1928) and HTTP CONNECT
+// proxies.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Dialer dials connections, net.Dialer is one.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// FromURL returns a Dialer connecting through the proxy at u using forward to
+// reach it. The schemes are socks5 (names resolved locally), socks5h (names
+// resolved by the proxy) and http (CONNECT), user info in the URL is used to
+// authenticate to the proxy.
+func FromURL(u *url.URL, forward Dialer) (Dialer, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("No proxy host in %s", u.Redacted())
+	}
+	var user, pass string
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "socks5", "socks5h":
+		return &SOCKS5{
+			Addr:           hostPort(u, "1080"),
+			Username:       user,
+			Password:       pass,
+			Forward:        forward,
+			ResolveLocally: strings.EqualFold(u.Scheme, "socks5"),
+		}, nil
+	case "http":
+		return &HTTPConnect{
+			Addr:     hostPort(u, "80"),
+			Username: user,
+			Password: pass,
+			Forward:  forward,
+		}, nil
+	}
+	return nil, fmt.Errorf("Unsupported proxy scheme %q", u.Scheme)
+}
+
+// Parse parses a proxy URL, one without a scheme is an HTTP proxy as for
+// curl.
+func Parse(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := FromURL(u, nil); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Environment returns the proxy to reach host through given by the
+// environment, nil if there is none: HTTPS_PROXY, otherwise ALL_PROXY (or
+// their lowercase forms) unless host matches NO_PROXY. Loopback addresses are
+// never proxied.
+func Environment(host string) (*url.URL, error) {
+	return environment(os.Getenv, host)
+}
+
+func environment(getenv func(string) string, host string) (*url.URL, error) {
+	env := func(name string) string {
+		if v := getenv(name); v != "" {
+			return v
+		}
+		return getenv(strings.ToLower(name))
+	}
+	if isLoopback(host) || noProxy(env("NO_PROXY"), host) {
+		return nil, nil
+	}
+	for _, name := range []string{"HTTPS_PROXY", "ALL_PROXY"} {
+		if v := env(name); v != "" {
+			u, err := Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// noProxy returns true if host matches the comma separated hosts and domains
+// of a NO_PROXY value, "*" matches all.
+func noProxy(value, host string) bool {
+	host = strings.ToLower(host)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if v == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(v); err == nil {
+			v = h
+		}
+		v = strings.TrimPrefix(strings.TrimPrefix(v, "*"), ".")
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// handshake runs f on conn, closing conn if ctx is done first.
+func handshake(ctx context.Context, conn net.Conn, f func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	err := f()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func dialProxy(ctx context.Context, forward Dialer, addr string) (net.Conn, error) {
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+	conn, err := forward.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// SOCKS5 dials through a SOCKS5 proxy, authenticating with a username and
+// password (RFC 1929) if Username is set.
+type SOCKS5 struct {
+	Addr               string
+	Username, Password string
+	Forward            Dialer // reaches the proxy, a net.Dialer if nil
+
+	// ResolveLocally has names resolved with Resolver (net.DefaultResolver
+	// if nil) before asking the proxy to connect, otherwise the proxy
+	// resolves them (needed for Tor).
+	ResolveLocally bool
+	Resolver       *net.Resolver
+}
+
+// SOCKS5 replies, see RFC 1928 6.
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+const (
+	socks5Version   = 5
+	socks5NoAuth    = 0
+	socks5UserPass  = 2
+	socks5NoMethods = 0xff
+	socks5Connect   = 1
+	socks5IPv4      = 1
+	socks5Domain    = 3
+	socks5IPv6      = 4
+)
+
+// DialContext connects to addr through the proxy.
+func (s *SOCKS5) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return nil, fmt.Errorf("Bad port in %s", addr)
+	}
+	if s.ResolveLocally && net.ParseIP(host) == nil {
+		resolver := s.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].IP.String()
+	}
+
+	conn, err := dialProxy(ctx, s.Forward, s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	err = handshake(ctx, conn, func() error {
+		return s.connect(conn, host, port)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %w", s.Addr, err)
+	}
+	return conn, nil
+}
+
+func (s *SOCKS5) connect(conn net.Conn, host string, port int) error {
+	method := byte(socks5NoAuth)
+	if s.Username != "" {
+		method = socks5UserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("Unexpected SOCKS version %d", b[0])
+	}
+	if b[1] != method {
+		return errors.New("No acceptable authentication method")
+	}
+	if method == socks5UserPass {
+		if err := s.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("Name too long %s", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	b = make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[1] != 0 {
+		if int(b[1]) < len(socks5Errors) {
+			return errors.New(socks5Errors[b[1]])
+		}
+		return fmt.Errorf("Unknown SOCKS reply %d", b[1])
+	}
+	// Skip the bound address and port.
+	var n int
+	switch b[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("Unknown SOCKS address type %d", b[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// authenticate authenticates with a username and password, see RFC 1929.
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("Username or password too long")
+	}
+	req := []byte{1, byte(len(s.Username))}
+	req = append(req, s.Username...)
+	req = append(req, byte(len(s.Password)))
+	req = append(req, s.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[1] != 0 {
+		return errors.New("Authentication failed")
+	}
+	return nil
+}
+
+// HTTPConnect dials through an HTTP proxy with CONNECT, authenticating with
+// Basic authentication if Username is set.
+type HTTPConnect struct {
+	Addr               string
+	Username, Password string
+	Forward            Dialer // reaches the proxy, a net.Dialer if nil
+}
+
+// DialContext connects to addr through the proxy.
+func (h *HTTPConnect) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := dialProxy(ctx, h.Forward, h.Addr)
+	if err != nil {
+		return nil, err
+	}
+	var br *bufio.Reader
+	err = handshake(ctx, conn, func() error {
+		req := &http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if h.Username != "" {
+			auth := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+			req.Header.Set("Proxy-Authorization", "Basic "+auth)
+		}
+		if err := req.Write(conn); err != nil {
+			return err
+		}
+		br = bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %w", h.Addr, err)
+	}
+	if br.Buffered() != 0 {
+		// The server spoke first, e.g., an IMAP greeting.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data already read from it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.r.Buffered() != 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,98 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Socket writes each event as a JSON line to a Unix stream socket. It
+// connects when needed and reconnects after an error.
+type Socket struct {
+	Path string
+
+	conn net.Conn
+}
+
+// NewSocket returns a sink for the Unix socket at path.
+func NewSocket(path string) *Socket {
+	return &Socket{Path: path}
+}
+
+func (s *Socket) Send(e *Event) error {
+	l, err := line(e)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		if s.conn, err = net.DialTimeout("unix", s.Path, 5*time.Second); err != nil {
+			s.conn = nil
+			return err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err = s.conn.Write(l); err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Socket) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Pipe writes each event as a JSON line to a named pipe (FIFO). Events are
+// dropped while nothing is reading the pipe.
+type Pipe struct {
+	Path string
+}
+
+// NewPipe returns a sink for the named pipe at path.
+func NewPipe(path string) *Pipe {
+	return &Pipe{Path: path}
+}
+
+func (p *Pipe) Send(e *Event) error {
+	l, err := line(e)
+	if err != nil {
+		return err
+	}
+	// Opening without a reader fails (ENXIO) rather than blocking, lines
+	// under PIPE_BUF are written atomically.
+	f, err := os.OpenFile(p.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(l)
+	return err
+}
+
+func (p *Pipe) Close() error {
+	return nil
+}
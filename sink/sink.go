@@ -0,0 +1,156 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sink delivers account events to other programs: an HTTP webhook, a
+// Unix socket, a named pipe or the clients of an event stream.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event types.
+const (
+	NewMail      = "new-mail"
+	Expunge      = "expunge"
+	FlagsChanged = "flags-changed"
+	Counts       = "counts"
+	FullUpdate   = "full-update"
+	State        = "state"
+	Notice       = "notice"
+	UpdateRun    = "update-run"    // coalesced update, stream only
+	ScriptResult = "script-result" // update script finished, stream only
+)
+
+// Event is the JSON form of an account event.
+type Event struct {
+	Type    string    `json:"type"`
+	Store   string    `json:"store,omitempty"`
+	Time    time.Time `json:"time"`
+	Class   string    `json:"class,omitempty"`   // of new mail
+	State   string    `json:"state,omitempty"`   // account state
+	Error   string    `json:"error,omitempty"`   // last error for state events
+	Message string    `json:"message,omitempty"` // notice text
+
+	New      int      `json:"new,omitempty"`      // new or changed messages
+	Messages int      `json:"messages,omitempty"` // messages in INBOX
+	SeqNums  []uint32 `json:"seq_nums,omitempty"` // of expunged or changed messages
+	UIDs     []uint32 `json:"uids,omitempty"`     // of the messages, if known
+	Mailbox  string   `json:"mailbox,omitempty"`  // counted, or polled and changed
+	Unseen   int      `json:"unseen,omitempty"`   // messages in the mailbox
+	Recent   int      `json:"recent,omitempty"`   // messages in the mailbox
+
+	// Update runs and script results have no Store.
+	Stores   []string `json:"stores,omitempty"`    // stores updated
+	Channels []string `json:"channels,omitempty"`  // update script arguments
+	Urgent   []string `json:"urgent,omitempty"`    // urgent channels
+	Full     bool     `json:"full,omitempty"`      // full update of all stores
+	ExitCode *int     `json:"exit_code,omitempty"` // of the update script
+	Seconds  float64  `json:"seconds,omitempty"`   // update script run time
+}
+
+// EventSink is somewhere to send events.
+type EventSink interface {
+	// Send delivers an event, it may block.
+	Send(e *Event) error
+	Close() error
+}
+
+// Config is a sink from the config file.
+type Config struct {
+	Type   string // webhook, socket or pipe
+	Target string // URL or path
+	Secret string // webhook HMAC key, optional
+}
+
+// New returns the sink for a config.
+func New(c Config) (EventSink, error) {
+	switch c.Type {
+	case "webhook":
+		return NewWebhook(c.Target, c.Secret), nil
+	case "socket":
+		return NewSocket(c.Target), nil
+	case "pipe":
+		return NewPipe(c.Target), nil
+	}
+	return nil, fmt.Errorf("Unknown sink type %s", c.Type)
+}
+
+// line returns the event as a JSON line.
+func line(e *Event) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Queue sends events to a sink from its own goroutine so Send never blocks,
+// events are dropped if the queue is full.
+type Queue struct {
+	sink  EventSink
+	name  string
+	c     chan *Event
+	done  chan struct{}
+	close sync.Once
+}
+
+// NewQueue starts a queue of up to size events for s, name is used in logs.
+func NewQueue(s EventSink, name string, size int) *Queue {
+	q := &Queue{
+		sink: s,
+		name: name,
+		c:    make(chan *Event, size),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for e := range q.c {
+			if err := q.sink.Send(e); err != nil {
+				log.Warnf("%s: dropping %s event: %v", q.name, e.Type, err)
+			}
+		}
+	}()
+	return q
+}
+
+// Send queues an event.
+func (q *Queue) Send(e *Event) error {
+	select {
+	case q.c <- e:
+		return nil
+	default:
+		log.Warnf("%s: queue full, dropping %s event", q.name, e.Type)
+		return fmt.Errorf("%s: queue full", q.name)
+	}
+}
+
+// Close sends the queued events then closes the sink.
+func (q *Queue) Close() error {
+	q.close.Do(func() {
+		close(q.c)
+	})
+	<-q.done
+	return q.sink.Close()
+}
@@ -0,0 +1,288 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var testEvent = &Event{
+	Type:  NewMail,
+	Store: "work-remote",
+	Time:  time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+	Class: "urgent",
+}
+
+// webhookServer records the requests to it, failing the first fail.
+type webhookServer struct {
+	*httptest.Server
+	lock   sync.Mutex
+	fail   int
+	bodies [][]byte
+	sigs   []string
+}
+
+func newWebhookServer(t *testing.T, fail int) *webhookServer {
+	ws := &webhookServer{fail: fail}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ws.lock.Lock()
+		defer ws.lock.Unlock()
+		ws.bodies = append(ws.bodies, body)
+		ws.sigs = append(ws.sigs, r.Header.Get(SignatureHeader))
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		} else if ws.fail > 0 {
+			ws.fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(ws.Close)
+	return ws
+}
+
+func TestWebhook(t *testing.T) {
+	ws := newWebhookServer(t, 2)
+	w := NewWebhook(ws.URL, "secret")
+	w.RetryBackoff = time.Millisecond
+	if err := w.Send(testEvent); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(ws.bodies) != 3 {
+		t.Fatalf("%d requests expected 3", len(ws.bodies))
+	}
+	var e Event
+	if err := json.Unmarshal(ws.bodies[2], &e); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&e, testEvent) {
+		t.Errorf("Got %+v expected %+v", e, testEvent)
+	}
+	if ws.sigs[2] != Sign("secret", ws.bodies[2]) {
+		t.Errorf("Bad signature %q", ws.sigs[2])
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	ws := newWebhookServer(t, 10)
+	w := NewWebhook(ws.URL, "")
+	w.RetryBackoff = time.Millisecond
+	if err := w.Send(testEvent); err == nil {
+		t.Errorf("Send succeeded")
+	}
+	if len(ws.bodies) != DefRetries+1 {
+		t.Errorf("%d requests expected %d", len(ws.bodies), DefRetries+1)
+	}
+	if ws.sigs[0] != "" {
+		t.Errorf("Signature without a secret")
+	}
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) *Event {
+	t.Helper()
+	l, err := r.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Event
+	if err := json.Unmarshal(l, &e); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := NewSocket(path)
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		if err := s.Send(testEvent); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := readEvent(t, bufio.NewReader(conn)); !reflect.DeepEqual(e, testEvent) {
+			t.Errorf("Got %+v expected %+v", e, testEvent)
+		}
+		// Reconnects after the reader goes away
+		conn.Close()
+		for s.Send(testEvent) == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipe(path)
+	if err := p.Send(testEvent); err == nil {
+		t.Errorf("Send without a reader succeeded")
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Send(testEvent); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if e := readEvent(t, bufio.NewReader(f)); !reflect.DeepEqual(e, testEvent) {
+		t.Errorf("Got %+v expected %+v", e, testEvent)
+	}
+}
+
+// blockingSink blocks sending until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	sent    int
+}
+
+func (s *blockingSink) Send(e *Event) error {
+	<-s.release
+	s.sent++
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	s := &blockingSink{release: make(chan struct{})}
+	q := NewQueue(s, "test", 2)
+	// One being sent, two queued and the rest dropped.
+	sent := 0
+	for i := 0; i < 5; i++ {
+		if q.Send(testEvent) == nil {
+			sent++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(s.release)
+	q.Close()
+	if sent != 3 || s.sent != 3 {
+		t.Errorf("Queued %d and sent %d expected 3", sent, s.sent)
+	}
+}
+
+// pipeListener accepts in-memory connections that block writing until the
+// other end reads.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) dial() net.Conn {
+	c, s := net.Pipe()
+	l.conns <- s
+	return c
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "unix"}
+}
+
+func waitClients(t *testing.T, s *Stream, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Clients() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d stream clients expected %d", s.Clients(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStream(t *testing.T) {
+	l := newPipeListener()
+	s := NewStream()
+	go s.Serve(l)
+	defer s.Close()
+
+	fast := bufio.NewReader(l.dial())
+	slow := l.dial()
+	waitClients(t, s, 2)
+
+	// The slow client never reads and is dropped once its backlog is full.
+	for i := 0; i < StreamBacklog+2; i++ {
+		if err := s.Send(testEvent); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if e := readEvent(t, fast); !reflect.DeepEqual(e, testEvent) {
+			t.Fatalf("Got %+v expected %+v", e, testEvent)
+		}
+	}
+	waitClients(t, s, 1)
+	// Returns once the dropped client is disconnected
+	ioutil.ReadAll(slow)
+
+	// Clients going away are removed.
+	gone := l.dial()
+	waitClients(t, s, 2)
+	gone.Close()
+	waitClients(t, s, 1)
+	s.Close()
+	waitClients(t, s, 0)
+	if _, err := fast.ReadByte(); err == nil {
+		t.Errorf("Client not disconnected on close")
+	}
+}
@@ -0,0 +1,158 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StreamBacklog is the number of events a stream client can fall behind by
+// before it's disconnected.
+const StreamBacklog = 100
+
+// Stream sends events as JSON lines to every client connected to its
+// listeners. Send never blocks.
+type Stream struct {
+	lock      sync.Mutex
+	clients   map[*streamClient]bool
+	listeners []net.Listener
+	closed    bool
+}
+
+type streamClient struct {
+	conn  net.Conn
+	lines chan []byte
+	once  sync.Once
+}
+
+// close disconnects the client, it may be called more than once.
+func (c *streamClient) close() {
+	c.once.Do(func() {
+		close(c.lines)
+		c.conn.Close()
+	})
+}
+
+// NewStream returns a stream without listeners, see Serve.
+func NewStream() *Stream {
+	return &Stream{clients: make(map[*streamClient]bool)}
+}
+
+// Clients returns the number of connected clients.
+func (s *Stream) Clients() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.clients)
+}
+
+// Serve accepts clients on l until it's closed.
+func (s *Stream) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return l.Close()
+	}
+	s.listeners = append(s.listeners, l)
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		c := &streamClient{conn: conn, lines: make(chan []byte, StreamBacklog)}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.clients[c] = true
+		s.lock.Unlock()
+		log.Debugf("event stream client connected")
+
+		go s.write(c)
+		go func() {
+			// Clients don't send anything, notice them going away.
+			io.Copy(ioutil.Discard, conn)
+			s.remove(c)
+		}()
+	}
+}
+
+func (s *Stream) write(c *streamClient) {
+	for l := range c.lines {
+		c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := c.conn.Write(l); err != nil {
+			s.remove(c)
+		}
+	}
+}
+
+func (s *Stream) remove(c *streamClient) {
+	s.lock.Lock()
+	if s.clients[c] {
+		delete(s.clients, c)
+		log.Debugf("event stream client disconnected")
+	}
+	s.lock.Unlock()
+	c.close()
+}
+
+// Send sends the event to the clients, a client that has fallen too far
+// behind is disconnected.
+func (s *Stream) Send(e *Event) error {
+	l, err := line(e)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.clients {
+		select {
+		case c.lines <- l:
+		default:
+			log.Warnf("event stream client too slow, disconnecting")
+			delete(s.clients, c)
+			c.close()
+		}
+	}
+	return nil
+}
+
+// Close closes the listeners and disconnects the clients.
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.clients {
+		delete(s.clients, c)
+		c.close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// SignatureHeader holds the hex HMAC-SHA256 of the body as
+	// "sha256=<hex>" if the webhook has a secret.
+	SignatureHeader = "X-Imapidle-Signature"
+
+	DefRetries      = 3
+	DefRetryBackoff = time.Second
+)
+
+// Webhook POSTs each event as JSON to a URL.
+type Webhook struct {
+	URL          string
+	Secret       string        // HMAC key, no signature if empty
+	Retries      int           // retries after a failed POST
+	RetryBackoff time.Duration // wait before the first retry, doubling after
+	Client       *http.Client
+}
+
+// NewWebhook returns a webhook sink with the default retries.
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{
+		URL:          url,
+		Secret:       secret,
+		Retries:      DefRetries,
+		RetryBackoff: DefRetryBackoff,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post makes one attempt returning true if it's worth retrying on error.
+func (w *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("POST %s: %s", w.URL, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Send POSTs the event retrying server errors.
+func (w *Webhook) Send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := w.RetryBackoff
+	for try := 0; ; try++ {
+		retry, err := w.post(body)
+		if err == nil || !retry || try == w.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) Close() error {
+	return nil
+}
@@ -0,0 +1,276 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+var statusFormats = []string{"plain", "waybar", "i3blocks", "polybar", "json"}
+
+// storeCounts are the INBOX counts of a store in the counts file, with how
+// long each phase of its last login took in seconds.
+type storeCounts struct {
+	Messages int                `json:"messages"`
+	Unseen   int                `json:"unseen"`
+	Recent   int                `json:"recent"`
+	Connect  map[string]float64 `json:"connect,omitempty"`
+}
+
+type connectPhase struct {
+	name string
+	d    time.Duration
+}
+
+// connectPhases returns the phases of connecting and logging in in order.
+func connectPhases(t watcher.ConnectTimes) []connectPhase {
+	return []connectPhase{
+		{"dns", t.DNS},
+		{"tcp", t.TCP},
+		{"tls", t.TLS},
+		{"greeting", t.Greeting},
+		{"auth", t.Auth},
+	}
+}
+
+// countsFile is the JSON form of the counts file.
+type countsFile struct {
+	Time   time.Time              `json:"time"`
+	Unseen int                    `json:"unseen"` // of all stores
+	Stores map[string]storeCounts `json:"stores"`
+}
+
+// defaultCountsFile returns the counts file path, in the runtime directory if
+// there is one.
+func defaultCountsFile() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "imapidle-counts.json")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("imapidle-counts-%d.json", os.Getuid()))
+}
+
+// newCountsFile returns the INBOX counts of the accounts counted so far. Stores
+// sharing connections have the INBOX of the one they share, its counts are
+// only its own.
+func newCountsFile(accounts map[string]*watcher.Account, now time.Time) *countsFile {
+	cf := &countsFile{Time: now, Stores: make(map[string]storeCounts)}
+	for name, a := range accounts {
+		if a.Primary() != "" {
+			continue
+		}
+		s := a.Stats()
+		if c, ok := s.Counts["INBOX"]; ok {
+			sc := storeCounts{Messages: c.Messages, Unseen: c.Unseen, Recent: c.Recent}
+			if s.Logins != 0 {
+				sc.Connect = make(map[string]float64)
+				for _, p := range connectPhases(s.Connect) {
+					sc.Connect[p.name] = p.d.Seconds()
+				}
+			}
+			cf.set(name, sc)
+		}
+	}
+	return cf
+}
+
+func (cf *countsFile) set(store string, c storeCounts) {
+	cf.Unseen += c.Unseen - cf.Stores[store].Unseen
+	cf.Stores[store] = c
+}
+
+// filter returns the counts of the given stores, all if there are none.
+func (cf *countsFile) filter(stores []string) *countsFile {
+	if len(stores) == 0 {
+		return cf
+	}
+	f := &countsFile{Time: cf.Time, Stores: make(map[string]storeCounts)}
+	for name, c := range cf.Stores {
+		if stringInSlice(name, stores) {
+			f.set(name, c)
+		}
+	}
+	return f
+}
+
+// writeCounts atomically replaces the counts file so readers never see part
+// of it.
+func writeCounts(path string, cf *countsFile) error {
+	b, err := json.Marshal(cf)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".imapidle-counts-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func readCounts(path string) (*countsFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cf := &countsFile{}
+	if err := json.Unmarshal(b, cf); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if cf.Stores == nil {
+		cf.Stores = make(map[string]storeCounts)
+	}
+	return cf, nil
+}
+
+// renderStatus returns the status line for a status bar or prompt. Status
+// bars hide a module with no text so there is none without unseen mail.
+func renderStatus(format string, cf *countsFile) (string, error) {
+	text, class := "", "none"
+	if cf.Unseen != 0 {
+		text, class = strconv.Itoa(cf.Unseen), "unseen"
+	}
+	var b []byte
+	var err error
+	switch format {
+	case "plain":
+		return strconv.Itoa(cf.Unseen), nil
+	case "polybar":
+		return text, nil
+	case "waybar":
+		names := make([]string, 0, len(cf.Stores))
+		for name := range cf.Stores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var tooltip []string
+		for _, name := range names {
+			tooltip = append(tooltip, fmt.Sprintf("%s: %d unseen", name, cf.Stores[name].Unseen))
+		}
+		b, err = json.Marshal(map[string]string{
+			"text":    text,
+			"tooltip": strings.Join(tooltip, "\n"),
+			"class":   class,
+			"alt":     class,
+		})
+	case "i3blocks":
+		b, err = json.Marshal(map[string]string{"full_text": text, "short_text": text})
+	case "json":
+		b, err = json.Marshal(cf)
+	default:
+		return "", fmt.Errorf("Unknown format %s", format)
+	}
+	return string(b), err
+}
+
+// statusCmd implements the status subcommand which prints the unseen counts
+// of a running imapidle for status bars and shell prompts.
+func statusCmd(args []string) int {
+	var stores []string
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s status [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "plain", "Output format: "+strings.Join(statusFormats, ", "))
+	countsPath := fs.String("counts-file", defaultCountsFile(), "Counts file of the running imapidle")
+	follow := fs.Bool("follow", false, "Print the status again each time it changes")
+	socket := fs.String("socket", defaultEventsSocket(), "Event stream socket of the running imapidle, for -follow")
+	fs.Var((*listFlag)(&stores), "store", "Only count these stores (repeatable or comma separated)")
+	fs.Parse(args)
+
+	if !stringInSlice(*format, statusFormats) {
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		return 2
+	}
+
+	// Connect first so no change is missed between reading and following
+	var conn net.Conn
+	if *follow {
+		var err error
+		if conn, err = net.Dial("unix", mbsyncrc.ExpandTilde(*socket)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer conn.Close()
+	}
+	cf, err := readCounts(mbsyncrc.ExpandTilde(*countsPath))
+	if os.IsNotExist(err) && *follow {
+		// Nothing counted yet
+		cf, err = &countsFile{Stores: make(map[string]storeCounts)}, nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	last, _ := renderStatus(*format, cf.filter(stores))
+	fmt.Println(last)
+	if !*follow {
+		return 0
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e sink.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Type != sink.Counts || e.Mailbox != "INBOX" {
+			continue
+		}
+		cf.Time = e.Time
+		c := cf.Stores[e.Store]
+		c.Messages, c.Unseen, c.Recent = e.Messages, e.Unseen, e.Recent
+		cf.set(e.Store, c)
+		if line, _ := renderStatus(*format, cf.filter(stores)); line != last {
+			fmt.Println(line)
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		fmt.Fprintln(os.Stderr, "imapidle closed the event stream")
+	}
+	return 1
+}
@@ -0,0 +1,104 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/sink"
+	"github.com/choppsv1/imapidle/watcher"
+)
+
+func TestCountsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.json")
+	cf := &countsFile{Time: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), Stores: make(map[string]storeCounts)}
+	cf.set("a", storeCounts{Messages: 10, Unseen: 3, Recent: 1})
+	cf.set("b", storeCounts{Messages: 5, Unseen: 2, Connect: map[string]float64{"dns": 0.01, "tcp": 0.02, "auth": 0.1}})
+	cf.set("a", storeCounts{Messages: 10, Unseen: 1})
+	if cf.Unseen != 3 {
+		t.Errorf("Unseen %d expected 3", cf.Unseen)
+	}
+	if err := writeCounts(path, cf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readCounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cf) {
+		t.Errorf("Read %+v expected %+v", got, cf)
+	}
+	if f := cf.filter([]string{"b", "c"}); f.Unseen != 2 || len(f.Stores) != 1 {
+		t.Errorf("Filtered %+v", f)
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".imapidle-counts-*")); len(files) != 0 {
+		t.Errorf("Temporary files left %v", files)
+	}
+	if _, err := readCounts(path + ".missing"); !os.IsNotExist(err) {
+		t.Errorf("Missing file error %v", err)
+	}
+}
+
+func TestRenderStatus(t *testing.T) {
+	cf := &countsFile{Stores: make(map[string]storeCounts)}
+	cf.set("b", storeCounts{Messages: 5, Unseen: 2, Connect: map[string]float64{"dns": 0.01, "tcp": 0.02, "auth": 0.1}})
+	cf.set("a", storeCounts{Messages: 10, Unseen: 1})
+	none := &countsFile{Stores: map[string]storeCounts{"a": {Messages: 10}}}
+	for _, c := range []struct {
+		format string
+		cf     *countsFile
+		want   string
+	}{
+		{"plain", cf, "3"},
+		{"plain", none, "0"},
+		{"polybar", cf, "3"},
+		{"polybar", none, ""},
+		{"waybar", cf, `{"alt":"unseen","class":"unseen","text":"3","tooltip":"a: 1 unseen\nb: 2 unseen"}`},
+		{"waybar", none, `{"alt":"none","class":"none","text":"","tooltip":"a: 0 unseen"}`},
+		{"i3blocks", cf, `{"full_text":"3","short_text":"3"}`},
+		{"i3blocks", none, `{"full_text":"","short_text":""}`},
+	} {
+		if got, err := renderStatus(c.format, c.cf); err != nil || got != c.want {
+			t.Errorf("%s: %s (%v) expected %s", c.format, got, err, c.want)
+		}
+	}
+	if _, err := renderStatus("dzen", cf); err == nil {
+		t.Errorf("No error for unknown format")
+	}
+}
+
+func TestDispatcherCounts(t *testing.T) {
+	td := startDispatcher(t)
+	td.CountsFile = filepath.Join(t.TempDir(), "counts.json")
+	td.send(watcher.Event{E: watcher.CountsEvent, A: td.Accounts["a"], Mailbox: "INBOX"})
+	if e := <-td.sunk; e.Type != sink.Counts || e.Mailbox != "INBOX" {
+		t.Errorf("Unexpected sink event %+v", e)
+	}
+	cf, err := readCounts(td.CountsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cf.Time.Equal(td.clock.Now()) {
+		t.Errorf("Counts file time %v expected %v", cf.Time, td.clock.Now())
+	}
+}
@@ -0,0 +1,244 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/choppsv1/imapidle/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+// The first file descriptor passed by socket activation, see sd_listen_fds(3)
+const listenFdsStart = 3
+
+// sdNotify sends a state string to the service manager, see sd_notify(3). It
+// does nothing if not running under systemd with notify enabled.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		// Abstract socket
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval at which to send WATCHDOG=1, or 0 if
+// the watchdog isn't enabled. This is half the service WatchdogSec.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// activationListeners returns the sockets passed by systemd socket
+// activation by their FileDescriptorName, see sd_listen_fds(3).
+func activationListeners() map[string]net.Listener {
+	return listenersFrom(listenFdsStart)
+}
+
+// listenersFrom returns the activation sockets passed starting at fd start.
+func listenersFrom(start int) map[string]net.Listener {
+	listeners := make(map[string]net.Listener)
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return listeners
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return listeners
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < nfds; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(start+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Warnf("Activation socket %s is not a listener: %v", name, err)
+			continue
+		}
+		log.Debugf("Using activation socket %s: %v", name, l.Addr())
+		listeners[name] = l
+	}
+	// Don't pass the sockets on to the update script
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return listeners
+}
+
+// systemdStatus returns a STATUS= line summarizing the account states.
+func systemdStatus(accounts map[string]*watcher.Account) string {
+	idling, connected := 0, 0
+	var down []string
+	for k, a := range accounts {
+		s := a.Stats()
+		if s.Idling {
+			idling++
+		}
+		if s.Connected {
+			connected++
+		} else {
+			down = append(down, fmt.Sprintf("%s (%v)", k, s.State))
+		}
+	}
+	status := fmt.Sprintf("STATUS=%d/%d stores connected, %d idling", connected, len(accounts), idling)
+	if len(down) != 0 {
+		status += "; not connected: " + strings.Join(down, ", ")
+	}
+	return status
+}
+
+// systemdQuote quotes a word of a command line for a unit file, specifiers
+// and variables are escaped so it's passed as is, see systemd.service(5).
+func systemdQuote(word string) string {
+	safe := word != ""
+	for _, c := range word {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("/_.,:=+@-", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return word
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%", "$", "$$").Replace(word) + `"`
+}
+
+// The socket units by FileDescriptorName, see activationListeners.
+var socketUnits = map[string]string{
+	"metrics": "imapidle.socket",
+	"events":  "imapidle-events.socket",
+}
+
+var serviceUnit = template.Must(template.New("service").Funcs(template.FuncMap{"quote": systemdQuote}).Parse(`[Unit]
+Description=IMAP IDLE watcher for mbsync
+Documentation=https://github.com/choppsv1/imapidle
+Wants=network-online.target
+After=network-online.target
+{{- range .Sockets}}
+Requires={{.}}
+{{- end}}
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{quote .Exec}} -log-format journald{{range .Args}} {{quote .}}{{end}}
+Restart=on-failure
+RestartSec=30
+WatchdogSec=2min
+
+[Install]
+WantedBy=default.target
+`))
+
+var socketUnit = template.Must(template.New("socket").Parse(`[Unit]
+Description=IMAP IDLE watcher for mbsync {{.Name}} socket
+
+[Socket]
+ListenStream={{.Listen}}
+FileDescriptorName={{.Name}}
+Service=imapidle.service
+
+[Install]
+WantedBy=sockets.target
+`))
+
+// systemdUnitCmd implements the systemd-unit subcommand which prints a user
+// service unit (or socket unit) template to stdout.
+func systemdUnitCmd(args []string) int {
+	return writeUnit(os.Stdout, args)
+}
+
+// writeUnit writes the unit asked for by the systemd-unit arguments.
+func writeUnit(w io.Writer, args []string) int {
+	fs := flag.NewFlagSet("systemd-unit", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s systemd-unit [options] [-- imapidle-args...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	listen := map[string]*string{
+		"metrics": fs.String("socket", "", "Listen address of a socket activated metrics socket unit"),
+		"events":  fs.String("events-socket", "", "Listen path of a socket activated event stream socket unit"),
+	}
+	printSocket := fs.Bool("print-socket", false, "Print the metrics socket unit rather than the service unit")
+	printEvents := fs.Bool("print-events-socket", false, "Print the event stream socket unit rather than the service unit")
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "imapidle"
+	}
+
+	var sockets []string
+	for _, name := range []string{"metrics", "events"} {
+		if *listen[name] != "" {
+			sockets = append(sockets, socketUnits[name])
+		}
+	}
+	socket := ""
+	if *printSocket {
+		socket = "metrics"
+	} else if *printEvents {
+		socket = "events"
+	}
+	if socket != "" {
+		if *listen[socket] == "" {
+			fmt.Fprintf(os.Stderr, "Printing the %s socket unit requires its listen address\n", socket)
+			return 1
+		}
+		err = socketUnit.Execute(w, struct{ Name, Listen string }{socket, *listen[socket]})
+	} else {
+		err = serviceUnit.Execute(w, struct {
+			Exec    string
+			Args    []string
+			Sockets []string
+		}{exe, fs.Args(), sockets})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// setenv sets an environment variable for the test.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestSdNotify(t *testing.T) {
+	setenv(t, "NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without systemd: %v", err)
+	}
+
+	for _, name := range []string{filepath.Join(t.TempDir(), "notify"), "@imapidle-test-" + strconv.Itoa(os.Getpid())} {
+		addr := name
+		if name[0] == '@' {
+			addr = "\x00" + name[1:]
+		}
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		setenv(t, "NOTIFY_SOCKET", name)
+		if err := sdNotify("READY=1"); err != nil {
+			t.Fatalf("sdNotify %s: %v", name, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 100)
+		n, err := conn.Read(b)
+		if err != nil || string(b[:n]) != "READY=1" {
+			t.Errorf("%s got %q: %v", name, b[:n], err)
+		}
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, c := range []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"bogus", "", 0},
+		{"-5", "", 0},
+		{"120000000", "", time.Minute},
+		{"120000000", strconv.Itoa(os.Getpid()), time.Minute},
+		{"120000000", "1", 0},
+	} {
+		setenv(t, "WATCHDOG_USEC", c.usec)
+		setenv(t, "WATCHDOG_PID", c.pid)
+		if got := watchdogInterval(); got != c.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q gave %v expected %v", c.usec, c.pid, got, c.want)
+		}
+	}
+}
+
+func TestActivationListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Passed as systemd does, listenersFrom takes it over
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not for this process
+	setenv(t, "LISTEN_PID", "1")
+	setenv(t, "LISTEN_FDS", "1")
+	setenv(t, "LISTEN_FDNAMES", "metrics")
+	if listeners := listenersFrom(fd); len(listeners) != 0 {
+		t.Errorf("Used sockets passed to another process: %v", listeners)
+	}
+
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners := listenersFrom(fd)
+	ml, ok := listeners["metrics"]
+	if !ok || len(listeners) != 1 {
+		t.Fatalf("Unexpected listeners %v", listeners)
+	}
+	defer ml.Close()
+	if ml.Addr().String() != l.Addr().String() {
+		t.Errorf("Listener on %v expected %v", ml.Addr(), l.Addr())
+	}
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Errorf("%s passed on", key)
+		}
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	for word, want := range map[string]string{
+		"gmail-remote":        "gmail-remote",
+		"/usr/bin/imapidle":   "/usr/bin/imapidle",
+		"":                    `""`,
+		"my store":            `"my store"`,
+		`say "hi" \ bye`:      `"say \"hi\" \\ bye"`,
+		"%h/mail":             `"%%h/mail"`,
+		"$HOME":               `"$$HOME"`,
+		"-log-levels=a=debug": "-log-levels=a=debug",
+	} {
+		if got := systemdQuote(word); got != want {
+			t.Errorf("systemdQuote(%q) = %s expected %s", word, got, want)
+		}
+	}
+}
+
+func TestWriteUnit(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := func(args ...string) string {
+		t.Helper()
+		b := &bytes.Buffer{}
+		if code := writeUnit(b, args); code != 0 {
+			t.Fatalf("writeUnit %v exited %d", args, code)
+		}
+		return b.String()
+	}
+
+	service := unit("--", "my store", "plain")
+	if !strings.Contains(service, "\nExecStart="+systemdQuote(exe)+` -log-format journald "my store" plain`+"\n") {
+		t.Errorf("Unexpected ExecStart in:\n%s", service)
+	}
+	if strings.Contains(service, "Requires=") {
+		t.Errorf("Service requires sockets without any:\n%s", service)
+	}
+
+	service = unit("-socket", "127.0.0.1:9101", "-events-socket", "%t/imapidle/events.sock")
+	if !strings.Contains(service, "\nRequires=imapidle.socket\nRequires=imapidle-events.socket\n") {
+		t.Errorf("Service doesn't require the sockets:\n%s", service)
+	}
+
+	for _, c := range []struct {
+		args       []string
+		name, addr string
+	}{
+		{[]string{"-socket", "127.0.0.1:9101", "-print-socket"}, "metrics", "127.0.0.1:9101"},
+		{[]string{"-events-socket", "%t/imapidle/events.sock", "-print-events-socket"}, "events", "%t/imapidle/events.sock"},
+	} {
+		socket := unit(c.args...)
+		for _, want := range []string{
+			"\nListenStream=" + c.addr + "\n",
+			"\nFileDescriptorName=" + c.name + "\n",
+			"\nService=imapidle.service\n",
+		} {
+			if !strings.Contains(socket, want) {
+				t.Errorf("Missing %q in:\n%s", want, socket)
+			}
+		}
+	}
+	if code := writeUnit(&bytes.Buffer{}, []string{"-print-events-socket"}); code == 0 {
+		t.Errorf("Printed an events socket unit without a listen path")
+	}
+}
@@ -0,0 +1,166 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+var eventTypes = []string{
+	sink.NewMail, sink.Expunge, sink.FlagsChanged, sink.Counts, sink.FullUpdate, sink.State, sink.Notice, sink.UpdateRun, sink.ScriptResult,
+}
+
+// defaultEventsSocket returns the event stream socket path, in the runtime
+// directory if there is one.
+func defaultEventsSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "imapidle.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("imapidle-%d.sock", os.Getuid()))
+}
+
+// errSocketInUse is returned listening on a socket another process serves.
+var errSocketInUse = errors.New("already in use")
+
+// listenEvents listens on the event stream socket, replacing a stale one.
+func listenEvents(path string) (net.Listener, error) {
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", path, errSocketInUse)
+	}
+	os.Remove(path)
+	// Events name stores and mailboxes, keep them to ourselves from the
+	// start
+	mask := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	return l, err
+}
+
+// serveEvents returns a stream serving clients on l.
+func serveEvents(l net.Listener) *sink.Stream {
+	s := sink.NewStream()
+	go func() {
+		if err := s.Serve(l); err != nil {
+			log.Errorf("events: %v", err)
+		}
+	}()
+	return s
+}
+
+// listFlag is a flag that can be repeated or given a comma separated list.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// eventFilter selects events by store and type, empty lists match all.
+type eventFilter struct {
+	stores, types []string
+}
+
+func (f *eventFilter) match(e *sink.Event) bool {
+	if len(f.types) != 0 && !stringInSlice(e.Type, f.types) {
+		return false
+	}
+	if len(f.stores) == 0 || stringInSlice(e.Store, f.stores) {
+		return true
+	}
+	for _, s := range e.Stores {
+		if stringInSlice(s, f.stores) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchCmd implements the watch subcommand which prints the events streamed
+// by a running imapidle as JSON lines.
+func watchCmd(args []string) int {
+	var f eventFilter
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s watch [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	socket := fs.String("socket", defaultEventsSocket(), "Event stream socket of the running imapidle")
+	fs.Var((*listFlag)(&f.stores), "store", "Only show events for these stores (repeatable or comma separated)")
+	fs.Var((*listFlag)(&f.types), "type", "Only show these event types (repeatable or comma separated): "+strings.Join(eventTypes, ", "))
+	fs.Parse(args)
+
+	for _, t := range f.types {
+		if !stringInSlice(t, eventTypes) {
+			fmt.Fprintf(os.Stderr, "Unknown event type %s\n", t)
+			return 2
+		}
+	}
+
+	conn, err := net.Dial("unix", mbsyncrc.ExpandTilde(*socket))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e sink.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad event: %v\n", err)
+			continue
+		}
+		if f.match(&e) {
+			out.Write(scanner.Bytes())
+			out.WriteByte('\n')
+			// Flush each event for pipes into status bars
+			out.Flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		fmt.Fprintln(os.Stderr, "imapidle closed the event stream")
+	}
+	return 1
+}
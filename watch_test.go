@@ -0,0 +1,78 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/choppsv1/imapidle/sink"
+)
+
+func TestEventFilter(t *testing.T) {
+	var stores, types listFlag
+	stores.Set("a, b")
+	stores.Set("c")
+	types.Set("new-mail,update-run")
+	f := eventFilter{stores, types}
+	for _, c := range []struct {
+		e    sink.Event
+		want bool
+	}{
+		{sink.Event{Type: sink.NewMail, Store: "a"}, true},
+		{sink.Event{Type: sink.NewMail, Store: "d"}, false},
+		{sink.Event{Type: sink.State, Store: "c"}, false},
+		{sink.Event{Type: sink.UpdateRun, Stores: []string{"d", "b"}}, true},
+		{sink.Event{Type: sink.UpdateRun, Stores: []string{"d"}}, false},
+	} {
+		if got := f.match(&c.e); got != c.want {
+			t.Errorf("match(%+v) = %v expected %v", c.e, got, c.want)
+		}
+	}
+	if f := (eventFilter{}); !f.match(&sink.Event{Type: sink.Notice, Store: "x"}) {
+		t.Errorf("Empty filter didn't match")
+	}
+}
+
+func TestListenEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "imapidle.sock")
+	l, err := listenEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("Socket mode %v expected 0600", fi.Mode().Perm())
+	}
+	if _, err := listenEvents(path); !errors.Is(err, errSocketInUse) {
+		t.Errorf("Listening on a served socket gave %v", err)
+	}
+
+	// A stale socket is replaced
+	l.Close()
+	ioutil.WriteFile(path, nil, 0600)
+	if l, err = listenEvents(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
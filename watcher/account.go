@@ -0,0 +1,1623 @@
+// -*- coding: utf-8 -*-
+//
+// April 24 2021, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2021, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package watcher watches the INBOX of IMAP accounts for changes using IDLE,
+// or polling if the server doesn't support it.
+package watcher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/choppsv1/imapidle/logging"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/proxy"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-sasl"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	IdleTimeout     = time.Duration(29) * time.Minute
+	DefPollInterval = time.Duration(5) * time.Minute
+	DefByeBackoff   = 2 * time.Minute
+	DefPowerPollInt = 30 * time.Minute
+
+	// The BYE backoff doubles up to this
+	maxByeBackoff = 30 * time.Minute
+
+	// How long to wait for the server to answer LOGOUT
+	logoutTimeout = 10 * time.Second
+)
+
+type EventCode int
+
+const (
+	OfflineEvent = iota // Offline reaping the account is safe.
+	CheckMailEvent
+	FullUpdateEvent
+	StateEvent        // Account connection state changed
+	NoticeEvent       // Something the user should know about, in M
+	ExpungeEvent      // Messages were expunged from INBOX
+	FlagsChangedEvent // Flags of INBOX messages changed
+	CountsEvent       // Counts of Mailbox changed, see AccountStats.Counts
+)
+
+var eventNames = []string{"offline", "check-mail", "full-update", "state", "notice", "expunge", "flags-changed", "counts"}
+
+func (e EventCode) String() string {
+	if e >= 0 && int(e) < len(eventNames) {
+		return eventNames[e]
+	}
+	return fmt.Sprintf("EventCode(%d)", int(e))
+}
+
+type Event struct {
+	E       EventCode
+	A       *Account
+	C       Class  // Class of new mail for CheckMailEvent
+	M       string // Message for NoticeEvent
+	Mailbox string // Mailbox for CountsEvent and changes of polled mailboxes
+	Update  string // Channel:mailbox to update if not the account's UpdateName
+
+	// For CheckMailEvent, ExpungeEvent and FlagsChangedEvent
+	N       int      // New or changed messages
+	Total   int      // Messages in INBOX
+	SeqNums []uint32 // of the messages, if known
+	UIDs    []uint32 // of the messages, if known
+}
+
+// UpdateName returns the channel:mailbox name to update for the event.
+func (e *Event) UpdateName() string {
+	if e.Update != "" {
+		return e.Update
+	}
+	return e.A.UpdateName
+}
+
+// An IDLE command.
+// Se RFC 2177 section 3.
+type Command struct{}
+
+func (cmd *Command) Command() *imap.Command {
+	return &imap.Command{
+		Name: "IDLE",
+	}
+}
+
+func (cmd *Command) Parse(fields []interface{}) error {
+	log.Tracef("Command parse fields: %v", fields)
+	return nil
+}
+
+// An IDLE response.
+type Response struct {
+	RepliesCh chan []byte
+	Stop      <-chan struct{}
+	Log       *log.Entry
+
+	wg                 *sync.WaitGroup // tracks the goroutine waiting on Stop
+	gotContinuationReq bool
+}
+
+func (r *Response) Replies() <-chan []byte {
+	r.Log.Tracef("Response: returning Replies channel")
+	return r.RepliesCh
+}
+
+func (r *Response) Handle(resp imap.Resp) error {
+	r.Log.Tracef("Response: Handle called with resp: %v", resp)
+
+	// Wait for a continuation request, setup go routine to clean things up
+	if cResp, ok := resp.(*imap.ContinuationReq); ok && !r.gotContinuationReq {
+		r.Log.Tracef("Response: Handle: ContinuationReq Info: %v", cResp.Info)
+		r.gotContinuationReq = true
+
+		// We got a continuation request, wait for r.Stop to be closed
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.Log.Tracef("Response: go-func waiting on r.Stop")
+			<-r.Stop
+			r.Log.Tracef("Response: got r.Stop, calling r.stop()")
+			r.stop()
+		}()
+
+		return nil
+	}
+	if dResp, ok := resp.(*imap.DataResp); ok {
+		r.Log.Tracef("Response: Handle: DataResp Tag: %s Fields: '%v'", dResp.Tag, dResp.Fields)
+	}
+
+	r.Log.Tracef("Response: Handle: Unhandled")
+
+	return responses.ErrUnhandled
+}
+
+func (r *Response) stop() {
+	r.Log.Debug("Response: stop called, sending DONE")
+	r.RepliesCh <- []byte("DONE\r\n")
+}
+
+// AccountStats is the account state and counters kept for status reporting.
+type AccountStats struct {
+	State         State
+	StateSince    time.Time      // when State was entered
+	Connected     bool           // State is logged in
+	Idling        bool           // State is Idling
+	Logins        int            // successful logins
+	Reconnects    int            // successful logins after the first
+	LoginFailures int            // failed connects or logins
+	IdleRefreshes int            // IDLE commands refreshed after IdleTimeout
+	Updates       map[string]int // updates received by type
+	LastIdle      time.Time      // last successful IDLE start or refresh
+	Panics        int            // panics recovered by the watcher
+	LastPanic     string         // last panic with its stack trace
+	LastError     string         // why the account last went offline
+	LastErrorAt   time.Time
+	Counts        map[string]Counts // by mailbox once counted
+	Connect       ConnectTimes      // of the last login
+}
+
+// Counts are the message counts of a mailbox.
+type Counts struct {
+	Messages int
+	Unseen   int
+	Recent   int
+}
+
+type Account struct {
+	// Configuration
+	mbsyncrc.AccountConfig
+	Channels []*mbsyncrc.Channel
+
+	// Path is the IMAPStore Path prefix and PathDelimiter its hierarchy
+	// delimiter, used to give mailboxes to the update script the way mbsync
+	// names them, see ChannelArg.
+	Path          string
+	PathDelimiter string
+
+	UpdateName string // Channel:INBOX name to update for this acct
+	PollInt    time.Duration
+	IdleInt    time.Duration // IDLE refresh interval, IdleTimeout if 0
+	ByeBackoff time.Duration // first wait after a BYE or UNAVAILABLE, DefByeBackoff if 0
+	Store      *StoreConfig  // imapidle settings for the store, defaults if nil
+	Clock      clock.Clock   // source of time, clock.System if nil
+
+	// Dialer connects to the server, if nil directly or through the
+	// store's Proxy.
+	Dialer proxy.Dialer `json:"-"`
+
+	// PowerPollInt is the time between STATUS polls while saving power,
+	// DefPowerPollInt if 0.
+	PowerPollInt time.Duration
+
+	// State
+	MsgCount int  // number of messages in INBOX
+	counted  bool // MsgCount has been read from the server
+
+	// INBOX counts besides MsgCount, searched when it's selected then
+	// tracked from the IDLE updates, see trackSeen.
+	unseen []uint32 // sequence numbers of the unseen messages, sorted
+	recent int
+
+	c        *client.Client
+	connDone chan struct{}   // closed when the connection is done with
+	donec    chan error      // IDLE Command done notification
+	eventc   chan<- Event    // receive events from the account
+	done     <-chan struct{} // closed when the account is taken offline
+	stopc    chan struct{}   // signal IDLE command to exit
+	t        clock.Timer     // Timer for IDLE refresh
+	wg       sync.WaitGroup  // goroutines started for the connections
+	updates  []client.Update // updates queued by the connection
+	queueing bool            // updates are queued, only while IDLE
+	updLock  sync.Mutex      // protects updates and queueing
+	updatec  chan struct{}   // signals updates were queued
+
+	idleOk bool
+	codes  *respCodes   // response codes from the connection
+	times  ConnectTimes // of the connection
+
+	byeBackoff time.Duration // last wait after a BYE, 0 once back online
+
+	credLock sync.Mutex // protects Password once online, see password
+
+	// Connection sharing, see Share.
+	primary    *Account   // the account watching INBOX for this one
+	followers  []*Account // the accounts this one watches INBOX for
+	pollOnConn bool       // poll INBOX and the targets on one connection
+
+	mboxLock  sync.Mutex
+	mailboxes []Mailbox // found by LIST logging in, see Mailboxes
+
+	retryOnce sync.Once
+	retryc    chan struct{} // operator asked to retry a refused login
+
+	ctlOnce     sync.Once
+	ctlc        chan struct{} // signals Reconnect or Pause was called
+	ctlLock     sync.Mutex    // protects paused, reconnect, powerSave and constrained
+	paused      bool          // the user paused the account
+	reconnect   bool          // the user asked to reconnect
+	powerSave   bool          // poll rather than IDLE, see SetPowerSave
+	constrained bool          // poll less often, see SetConstrained
+
+	baseLog *log.Entry // logger with the account fields
+	log     *log.Entry // baseLog with the connection fields
+	connID  int        // incremented for each connection
+
+	sm        stateMachine
+	statsLock sync.Mutex
+	stats     AccountStats
+}
+
+// Stats returns a copy of the account stats, those of the account it shares
+// connections with if any. It is safe to call from any goroutine.
+func (a *Account) Stats() AccountStats {
+	a = a.owner()
+	state, since := a.sm.get()
+	a.statsLock.Lock()
+	defer a.statsLock.Unlock()
+	stats := a.stats
+	stats.State = state
+	stats.StateSince = since
+	stats.Connected = state.Connected()
+	stats.Idling = state == Idling
+	stats.Updates = make(map[string]int, len(a.stats.Updates))
+	for k, v := range a.stats.Updates {
+		stats.Updates[k] = v
+	}
+	stats.Counts = make(map[string]Counts, len(a.stats.Counts))
+	for k, v := range a.stats.Counts {
+		stats.Counts[k] = v
+	}
+	return stats
+}
+
+// State returns the account state and when it was entered, it is safe to
+// call from any goroutine.
+func (a *Account) State() (State, time.Time) {
+	return a.owner().sm.get()
+}
+
+// History returns the last HistoryLen state transitions oldest first, it is
+// safe to call from any goroutine.
+func (a *Account) History() []Transition {
+	return a.owner().sm.transitions()
+}
+
+// setError records why the account went offline.
+func (a *Account) setError(reason string) {
+	now := a.clock().Now()
+	a.updateStats(func(s *AccountStats) {
+		s.LastError = reason
+		s.LastErrorAt = now
+	})
+}
+
+// setState moves the account to state to, an invalid transition is a bug
+// and is logged and ignored.
+func (a *Account) setState(to State, reason string) {
+	if cur, _ := a.sm.get(); cur == to {
+		return
+	}
+	t, ok := a.sm.set(to, a.clock().Now(), reason)
+	if !ok {
+		a.log.Errorf("invalid state transition %v -> %v: %s", t.From, t.To, reason)
+		return
+	}
+	if reason != "" && (to == Backoff || to == AuthFailed || to == Failed) {
+		a.setError(reason)
+	}
+	if reason != "" {
+		a.log.Debugf("state %v -> %v: %s", t.From, t.To, reason)
+	} else {
+		a.log.Debugf("state %v -> %v", t.From, t.To)
+	}
+}
+
+func (a *Account) updateStats(f func(s *AccountStats)) {
+	a = a.owner()
+	a.statsLock.Lock()
+	f(&a.stats)
+	a.statsLock.Unlock()
+}
+
+func (a *Account) clock() clock.Clock {
+	if a.Clock == nil {
+		return clock.System
+	}
+	return a.Clock
+}
+
+func (a *Account) String() string {
+	return fmt.Sprintf("ACCT: %s", a.Host)
+}
+
+// initLog sets up the account's logger and default Store if it hasn't been
+// already.
+func (a *Account) initLog() {
+	if a.Store == nil {
+		a.Store = NewStoreConfig(a.Name)
+	}
+	if a.baseLog != nil {
+		return
+	}
+	a.baseLog = logging.NewLogger(a.Name).WithFields(log.Fields{
+		"store":   a.Name,
+		"host":    a.Host,
+		"user":    a.User,
+		"mailbox": "INBOX",
+	})
+	a.log = a.baseLog
+}
+
+func getPass(ctx context.Context, cmdstr string) (string, error) {
+	pass, err := mbsyncrc.RunPassCmd(ctx, cmdstr)
+	if err != nil {
+		return "", err
+	}
+	logging.AddSecret(pass)
+	return pass, nil
+}
+
+// tlsConfig returns the TLS config for connecting to the server.
+func (a *Account) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: a.Host,
+		NextProtos: []string{a.SSLVersion},
+	}
+	if a.CertFile != "" {
+		pem, err := ioutil.ReadFile(a.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig.RootCAs, err = x509.SystemCertPool(); err != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", a.CertFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Login connects to the server if not connected and logs in. The connection
+// is closed if ctx is done before Logout.
+func (a *Account) Login(ctx context.Context) error {
+	var err error
+	a.initLog()
+	a.setState(Connecting, "")
+	if a.password() == "" {
+		pass, err := getPass(ctx, a.PassCmd)
+		if err != nil {
+			a.setState(Backoff, err.Error())
+			return err
+		}
+		a.setPassword(pass)
+	}
+
+	if a.c == nil {
+		if err := a.connect(ctx); err != nil {
+			a.setState(Backoff, err.Error())
+			return err
+		}
+	}
+
+	a.setState(Authenticating, "")
+	a.codes.take()
+	if err := a.login(); err != nil {
+		// The server refused us if the connection is still up.
+		refused := false
+		select {
+		case <-a.c.LoggedOut():
+		default:
+			refused = true
+		}
+		cmd := ""
+		var aerr *authCmdError
+		if errors.As(err, &aerr) {
+			cmd, err = aerr.Cmd, aerr.Err
+		}
+		lerr := classifyLogin(err, cmd, a.codes.take(), refused)
+		a.disconnect()
+		if lerr.Failure.Retried() {
+			a.setState(Backoff, lerr.Error())
+		} else {
+			a.setState(AuthFailed, lerr.Error())
+		}
+		return lerr
+	}
+
+	if a.idleOk, err = a.c.Support("IDLE"); err != nil {
+		a.idleOk = false
+		a.disconnect()
+		a.setState(Backoff, err.Error())
+		return err
+	}
+	a.log.Debugf("Support IDLE: %v", a.idleOk)
+
+	a.setState(Selecting, "")
+	return nil
+}
+
+// password returns the password, the status poller reads it while the
+// account may be refreshing it.
+func (a *Account) password() string {
+	a.credLock.Lock()
+	defer a.credLock.Unlock()
+	return a.Password
+}
+
+func (a *Account) setPassword(pass string) {
+	a.credLock.Lock()
+	a.Password = pass
+	a.credLock.Unlock()
+}
+
+// connect connects to the server, the connection is closed if ctx is done
+// before disconnect.
+func (a *Account) connect(ctx context.Context) error {
+	a.connID++
+	a.log = a.baseLog.WithField("conn_id", a.connID)
+
+	tlsConfig, err := a.tlsConfig()
+	if err != nil {
+		return err
+	}
+	a.times = ConnectTimes{}
+	raw, err := a.dial(ctx, &a.times)
+	if err != nil {
+		return err
+	}
+	conn := raw
+	a.codes = &respCodes{}
+	if !a.StartTLS {
+		conn = tls.Client(raw, tlsConfig)
+	}
+
+	// Close the connection to cancel anything blocked on it, starting
+	// with the TLS handshake and greeting.
+	connDone := make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		select {
+		case <-ctx.Done():
+			raw.Close()
+		case <-connDone:
+		}
+	}()
+	a.connDone = connDone
+
+	if !a.StartTLS {
+		if err := a.handshake(conn.(*tls.Conn), &a.times); err != nil {
+			raw.Close()
+			a.disconnect()
+			return err
+		}
+		conn = newTeeConn(conn, a.codes)
+	}
+	if a.c, err = a.greet(raw, conn, tlsConfig, &a.times); err != nil {
+		raw.Close()
+		a.disconnect()
+		return err
+	}
+	a.c.ErrorLog = a.log.WithField("subsystem", "imap")
+	if !a.StartTLS {
+		a.log.Debugf("Connected with TLS")
+	} else {
+		a.log.Debugf("Connected non-TLS, TLS started")
+	}
+
+	// Updates are queued so the client never blocks delivering them.
+	if a.updatec == nil {
+		a.updatec = make(chan struct{}, 1)
+	}
+	updatec := a.updatec
+	a.codes.notify = func() {
+		select {
+		case updatec <- struct{}{}:
+		default:
+		}
+	}
+	updates := make(chan client.Update)
+	a.c.Updates = updates
+	a.wg.Add(1)
+	go func(loggedOut <-chan struct{}, log *log.Entry) {
+		defer a.wg.Done()
+		for {
+			select {
+			case u := <-updates:
+				a.queueUpdate(u, log)
+			case <-loggedOut:
+				return
+			}
+		}
+	}(a.c.LoggedOut(), a.log)
+
+	// Setting the debug writer costs a NOOP, with TLS the response codes
+	// were already being copied from the connection.
+	var local, remote io.Writer
+	if logging.TraceIMAP(a.Name) {
+		local = &logging.LineWriter{
+			Log: logging.NewLogger("imap:"+a.Name, "imap").WithFields(a.log.Data).WithField("subsystem", "imap"),
+		}
+		remote = local
+	}
+	if a.StartTLS {
+		if remote != nil {
+			remote = io.MultiWriter(remote, a.codes)
+		} else {
+			remote = a.codes
+		}
+	}
+	if local != nil && local == remote {
+		a.c.SetDebug(local)
+	} else if remote != nil {
+		a.c.SetDebug(imap.NewDebugWriter(local, remote))
+	}
+	return nil
+}
+
+// disconnect closes the connection without logging out.
+func (a *Account) disconnect() {
+	if a.c != nil {
+		a.c.Terminate()
+		a.c = nil
+	}
+	if a.connDone != nil {
+		close(a.connDone)
+		a.connDone = nil
+	}
+}
+
+func (a *Account) login() error {
+	start := time.Now()
+	err := a.authenticate(a.c, a.log)
+	a.times.Auth = time.Since(start)
+	if err != nil {
+		return err
+	}
+	a.log.WithFields(a.times.fields()).Infof("logged in after %v", a.times.Total().Round(time.Millisecond))
+	polling := a.PowerSave()
+	times := a.times
+	a.updateStats(func(s *AccountStats) {
+		s.Connect = times
+		// Logging in for each power saving poll isn't reconnecting
+		if s.Logins != 0 && !polling {
+			s.Reconnects++
+		}
+		s.Logins++
+	})
+	return nil
+}
+
+// authenticate logs c in with the account credentials.
+func (a *Account) authenticate(c *client.Client, log *log.Entry) error {
+	pass := a.password()
+	if a.UseXOAuth2 {
+		// The SASL initial response is base64 encoded in traces
+		logging.AddSecret(fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", a.User, pass))
+		saslClient := sasl.NewXoauth2Client(a.User, pass)
+		// Ask for the capabilities Authenticate checks first so its
+		// errors are AUTHENTICATE's
+		if _, err := c.Support("SASL-IR"); err != nil {
+			return err
+		}
+		if err := c.Authenticate(saslClient); err != nil {
+			log.Warnf("xauth2 login %v failed", a.User)
+			if err == client.ErrAlreadyLoggedIn {
+				return err
+			}
+			return &authCmdError{Cmd: "AUTHENTICATE", Err: err}
+		}
+	} else {
+		if err := c.Login(a.User, pass); err != nil {
+			log.Warnf("login %v failed", a.User)
+			if err == client.ErrAlreadyLoggedIn || err == client.ErrLoginDisabled {
+				return err
+			}
+			return &authCmdError{Cmd: "LOGIN", Err: err}
+		}
+	}
+	return nil
+}
+
+// send sends an event unless the account is being taken offline. The INBOX
+// events of an account are sent for the accounts sharing its connections too,
+// except the counts which are only the account's so they're counted once.
+func (a *Account) send(e Event) {
+	if a.primary != nil {
+		a.primary.send(e)
+		return
+	}
+	if a.eventc == nil {
+		return
+	}
+	events := []Event{e}
+	if e.A == a && e.E != NoticeEvent && e.E != CountsEvent && (e.Mailbox == "" || e.Mailbox == "INBOX") {
+		for _, f := range a.followers {
+			fe := e
+			fe.A = f
+			events = append(events, fe)
+		}
+	}
+	for _, e := range events {
+		select {
+		case a.eventc <- e:
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// setCounts records the counts of a mailbox, letting the main loop know if
+// they changed.
+func (a *Account) setCounts(mailbox string, c Counts) {
+	changed := false
+	a.updateStats(func(s *AccountStats) {
+		if old, ok := s.Counts[mailbox]; !ok || old != c {
+			if s.Counts == nil {
+				s.Counts = make(map[string]Counts)
+			}
+			s.Counts[mailbox] = c
+			changed = true
+		}
+	})
+	if changed {
+		// The status poller counts too, a.log belongs to the account
+		a.baseLog.WithField("mailbox", mailbox).Debugf("counts: %+v", c)
+		a.send(Event{E: CountsEvent, A: a, Mailbox: mailbox})
+	}
+}
+
+// signalState lets the main loop know the account state has changed.
+func (a *Account) signalState() {
+	a.send(Event{E: StateEvent, A: a})
+}
+
+// notice sends a message for the user to the main loop.
+func (a *Account) notice(msg string) {
+	a.send(Event{E: NoticeEvent, A: a, M: msg})
+}
+
+func (a *Account) retryChan() chan struct{} {
+	a.retryOnce.Do(func() {
+		a.retryc = make(chan struct{}, 1)
+	})
+	return a.retryc
+}
+
+// Retry has an account waiting after a refused login try again, it is safe to
+// call from any goroutine.
+func (a *Account) Retry() {
+	a = a.owner()
+	select {
+	case a.retryChan() <- struct{}{}:
+	default:
+	}
+}
+
+// waitRetry waits for Retry, Reconnect or Pause returning false if ctx is
+// done first.
+func (a *Account) waitRetry(ctx context.Context) bool {
+	// Only a Retry while waiting counts
+	select {
+	case <-a.retryChan():
+	default:
+	}
+	select {
+	case <-a.retryChan():
+		return true
+	case <-a.ctlChan():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (a *Account) ctlChan() chan struct{} {
+	a.ctlOnce.Do(func() {
+		a.ctlc = make(chan struct{}, 1)
+	})
+	return a.ctlc
+}
+
+// control changes the requested state with f and wakes the account.
+func (a *Account) control(f func()) {
+	a.ctlLock.Lock()
+	f()
+	a.ctlLock.Unlock()
+	select {
+	case a.ctlChan() <- struct{}{}:
+	default:
+	}
+}
+
+// Reconnect has the account drop its connection and reconnect without
+// waiting out any backoff, resuming it if paused. It is safe to call from any
+// goroutine.
+func (a *Account) Reconnect() {
+	a = a.owner()
+	a.control(func() {
+		a.reconnect = true
+		a.paused = false
+	})
+}
+
+// Pause logs the account out and keeps it offline until Pause is called with
+// false or Reconnect is called. It is safe to call from any goroutine.
+func (a *Account) Pause(paused bool) {
+	a = a.owner()
+	a.control(func() {
+		a.paused = paused
+	})
+}
+
+// Paused returns true if the account has been paused, it is safe to call
+// from any goroutine.
+func (a *Account) Paused() bool {
+	a = a.owner()
+	a.ctlLock.Lock()
+	defer a.ctlLock.Unlock()
+	return a.paused
+}
+
+// SetPowerSave has the account drop IDLE in favor of logging in every
+// PowerPollInt to check INBOX with STATUS, or go back to IDLE. The connections
+// of a shared account belong to another, its priority decides. It is safe to
+// call from any goroutine.
+func (a *Account) SetPowerSave(on bool) {
+	if a.primary != nil {
+		return
+	}
+	a.control(func() {
+		a.powerSave = on
+	})
+}
+
+// PowerSave returns true if the account is saving power, it is safe to call
+// from any goroutine.
+func (a *Account) PowerSave() bool {
+	a = a.owner()
+	a.ctlLock.Lock()
+	defer a.ctlLock.Unlock()
+	return a.powerSave
+}
+
+// SetConstrained has the account poll no more often than PowerPollInt while
+// saving power or data: PollInt and the STATUS PollInterval are stretched to
+// it. The connections of a shared account belong to another, its priority
+// decides. It is safe to call from any goroutine.
+func (a *Account) SetConstrained(on bool) {
+	if a.primary != nil {
+		return
+	}
+	a.control(func() {
+		a.constrained = on
+	})
+}
+
+// Constrained returns true if the account's polls are stretched, it is safe to
+// call from any goroutine.
+func (a *Account) Constrained() bool {
+	a = a.owner()
+	a.ctlLock.Lock()
+	defer a.ctlLock.Unlock()
+	return a.constrained
+}
+
+// PollInterval returns the time between checks of INBOX when the server
+// doesn't support IDLE, PollInt unless stretched by SetConstrained. It is safe
+// to call from any goroutine.
+func (a *Account) PollInterval() time.Duration {
+	if a.Constrained() && a.PollInt < a.powerPollInt() {
+		return a.powerPollInt()
+	}
+	return a.PollInt
+}
+
+func (a *Account) powerPollInt() time.Duration {
+	if a.PowerPollInt == 0 {
+		return DefPowerPollInt
+	}
+	return a.PowerPollInt
+}
+
+// checkStatus checks INBOX with STATUS and if the message count has changed
+// selects it to look at the new mail.
+func (a *Account) checkStatus() {
+	st, err := a.c.Status("INBOX", []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen, imap.StatusRecent})
+	if err != nil {
+		a.log.Warnf("got error checking INBOX status: %v", err)
+		return
+	}
+	a.log.Debugf("STATUS INBOX: %d Messages", st.Messages)
+	a.setCounts("INBOX", Counts{Messages: int(st.Messages), Unseen: int(st.Unseen), Recent: int(st.Recent)})
+	if !a.counted {
+		a.MsgCount = int(st.Messages)
+		a.counted = true
+	} else if int(st.Messages) != a.MsgCount {
+		a.CheckForNew()
+	}
+}
+
+// powerPoll checks for new mail, unless it was idling until now, then logs
+// out until the next poll.
+func (a *Account) powerPoll(ctx context.Context) {
+	if a.stopc != nil {
+		a.log.Infof("saving power, polling every %v", a.powerPollInt())
+	} else {
+		a.checkStatus()
+		a.reportAlerts()
+	}
+	a.logout("saving power")
+	a.setState(Sleeping, "")
+	a.signalState()
+	a.pause(ctx, a.powerPollInt())
+}
+
+// handleControl acts on Reconnect and Pause, returning false if ctx is done
+// while paused.
+func (a *Account) handleControl(ctx context.Context) bool {
+	// Drain the signal before reading what it was for.
+	select {
+	case <-a.ctlChan():
+	default:
+	}
+	a.ctlLock.Lock()
+	reconnect := a.reconnect
+	a.reconnect = false
+	a.ctlLock.Unlock()
+
+	if reconnect {
+		a.log.Infof("reconnecting as asked")
+		a.byeBackoff = 0
+		if a.c != nil {
+			a.logout("reconnect requested")
+		}
+	}
+	if !a.Paused() {
+		return true
+	}
+	a.log.Infof("paused")
+	a.logout("paused")
+	a.setState(Paused, "")
+	a.signalState()
+	for a.Paused() {
+		select {
+		case <-a.ctlChan():
+		case <-ctx.Done():
+			return false
+		}
+	}
+	a.log.Infof("resuming")
+	return true
+}
+
+func (a *Account) Logout() {
+	a.logout("logout")
+}
+
+// logout logs out and closes the connection if there is one, moving to
+// Disconnected for reason.
+func (a *Account) logout(reason string) {
+	if a.c != nil {
+		if a.stopc != nil {
+			a.StopIdle()
+		}
+		// Don't wait forever on a dead connection
+		a.c.Timeout = logoutTimeout
+		if err := a.c.Logout(); err != nil {
+			a.log.Debugf("logout: %v", err)
+		}
+		a.disconnect()
+	}
+	a.setState(Disconnected, reason)
+	a.signalState()
+}
+
+func (a *Account) selectInbox() (mbox *imap.MailboxStatus, err error) {
+	a.log.Debugf("selecting INBOX")
+
+	mbox, err = a.c.Select("INBOX", false)
+	if err != nil {
+		return
+	}
+	a.MsgCount = int(mbox.Messages)
+	a.counted = true
+	a.log.Debugf("%d Messages", a.MsgCount)
+	a.countInbox(int(mbox.Recent))
+	return
+}
+
+// countInbox counts the unseen messages of the selected INBOX.
+func (a *Account) countInbox(recent int) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	unseen, err := a.c.Search(criteria)
+	if err != nil {
+		a.log.Warnf("got error searching for unseen messages: %v", err)
+		return
+	}
+	sort.Slice(unseen, func(i, j int) bool { return unseen[i] < unseen[j] })
+	a.unseen, a.recent = unseen, recent
+	a.setCounts("INBOX", a.inboxCounts())
+}
+
+// inboxCounts returns the counts of the selected INBOX.
+func (a *Account) inboxCounts() Counts {
+	return Counts{Messages: a.MsgCount, Unseen: len(a.unseen), Recent: a.recent}
+}
+
+// trackSeen tracks whether INBOX message seqNum is seen. New mail is taken to
+// be unseen until a FETCH says otherwise, the counts are searched again when
+// INBOX is next selected, e.g., for an IDLE refresh.
+func (a *Account) trackSeen(seqNum uint32, seen bool) {
+	i := sort.Search(len(a.unseen), func(i int) bool { return a.unseen[i] >= seqNum })
+	found := i < len(a.unseen) && a.unseen[i] == seqNum
+	if seen && found {
+		a.unseen = append(a.unseen[:i], a.unseen[i+1:]...)
+	} else if !seen && !found {
+		a.unseen = append(a.unseen, 0)
+		copy(a.unseen[i+1:], a.unseen[i:])
+		a.unseen[i] = seqNum
+	}
+}
+
+// trackExpunge tracks the expunge of INBOX message seqNum, renumbering the
+// messages after it.
+func (a *Account) trackExpunge(seqNum uint32) {
+	a.trackSeen(seqNum, true)
+	for i, n := range a.unseen {
+		if n > seqNum {
+			a.unseen[i]--
+		}
+	}
+}
+
+// PollPause waits PollInterval or until ctx is done.
+func (a *Account) PollPause(ctx context.Context) {
+	a.pause(ctx, a.PollInterval())
+}
+
+// pause waits timeout or until ctx is done.
+func (a *Account) pause(ctx context.Context, timeout time.Duration) {
+	if a.c == nil {
+		a.log.Debugf("pausing %v for reconnect", timeout)
+	} else {
+		a.log.Debugf("pausing %v for next poll", timeout)
+	}
+	t := a.clock().NewTimer(timeout)
+	select {
+	case <-t.C():
+	case <-a.ctlChan():
+		// Handled at the top of the Online loop
+		t.Stop()
+	case <-ctx.Done():
+		t.Stop()
+	}
+}
+
+func (a *Account) checkForNew() (int, error) {
+
+	old, counted := a.MsgCount, a.counted
+	if _, err := a.selectInbox(); err != nil {
+		return 0, err
+	}
+
+	newCount := 0
+	if counted {
+		newCount = a.MsgCount - old
+	}
+	return newCount, nil
+
+}
+
+func (a *Account) CheckForNew() {
+	if newCount, err := a.checkForNew(); err != nil {
+		a.log.Warnf("got error checking for new: %v", err)
+	} else {
+		a.signalCount(newCount)
+	}
+}
+
+// signalCount signals a change in the INBOX message count found selecting it.
+func (a *Account) signalCount(newCount int) {
+	if newCount > 0 {
+		a.signalNew(newCount)
+	} else if newCount < 0 {
+		// Expunged while we weren't watching, which ones isn't known
+		a.signalChange(ExpungeChange, -newCount, nil, nil)
+	} else {
+		a.log.Tracef("CheckForNew returns 0")
+	}
+}
+
+func (a *Account) Idle() {
+
+	if a.stopc != nil {
+		a.log.Errorf("Idle called while idling, restarting IDLE")
+		a.StopIdle()
+	}
+
+	a.log.Debugf("Starting to IDLE")
+
+	a.stopc = make(chan struct{}) // Our channel to stop the command
+	a.donec = make(chan error, 1) // Our channel to here that the command completed
+	a.updLock.Lock()
+	a.queueing = true
+	a.updLock.Unlock()
+	idleInt := a.IdleInt
+	if idleInt == 0 {
+		idleInt = IdleTimeout
+	}
+	a.t = a.clock().NewTimer(idleInt) // Timer for refreshing the command
+	now := a.clock().Now()
+	a.updateStats(func(s *AccountStats) {
+		s.LastIdle = now
+	})
+	a.setState(Idling, "")
+
+	// Run the command, StopIdle may clear our fields before it starts.
+	c, donec := a.c, a.donec
+	res := &Response{
+		Stop:      a.stopc,
+		Log:       a.log,
+		RepliesCh: make(chan []byte, 10),
+		wg:        &a.wg,
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		res.Log.Tracef("go-idle: Executing")
+		if status, err := c.Execute(&Command{}, res); err != nil {
+			res.Log.Tracef("go-idle: Sending error: %v", err)
+			donec <- err
+		} else {
+			res.Log.Tracef("go-idle: Sending status: %v", status)
+			donec <- status.Err()
+		}
+	}()
+}
+
+// StopIdle stops the IDLE command waiting for it to finish unless it already
+// has.
+func (a *Account) StopIdle() {
+	a.log.Debugf("stopping IDLE")
+
+	if a.t != nil {
+		if !a.t.Stop() {
+			<-a.t.C()
+		}
+		a.t = nil
+	}
+
+	close(a.stopc)
+	a.stopc = nil
+	a.updLock.Lock()
+	a.queueing = false
+	a.updates = nil
+	a.updLock.Unlock()
+	a.setState(Selecting, "")
+
+	if a.donec != nil {
+		err := <-a.donec
+		a.log.Tracef("IDLE done: %v", err)
+		a.donec = nil
+	}
+}
+
+// abandon closes the connection without logging out and forgets any IDLE
+// command, it's used to recover from a panic.
+func (a *Account) abandon() {
+	if a.t != nil {
+		a.t.Stop()
+		a.t = nil
+	}
+	if a.stopc != nil {
+		close(a.stopc)
+		a.stopc = nil
+	}
+	a.donec = nil
+	a.updLock.Lock()
+	a.queueing = false
+	a.updates = nil
+	a.updLock.Unlock()
+	a.disconnect()
+	a.setState(Disconnected, "panic")
+}
+
+// queueUpdate queues an update from the connection if IDLE is running.
+func (a *Account) queueUpdate(u client.Update, log *log.Entry) {
+	a.updLock.Lock()
+	defer a.updLock.Unlock()
+	if !a.queueing {
+		log.Tracef("dropping update outside IDLE: %v", u)
+		return
+	}
+	a.updates = append(a.updates, u)
+	select {
+	case a.updatec <- struct{}{}:
+	default:
+	}
+}
+
+// takeUpdates returns the queued updates.
+func (a *Account) takeUpdates() []client.Update {
+	a.updLock.Lock()
+	defer a.updLock.Unlock()
+	updates := a.updates
+	a.updates = nil
+	return updates
+}
+
+// defaultClass returns the class of changes that aren't rule checked.
+func (a *Account) defaultClass() Class {
+	if len(a.Store.Rules.Rules) == 0 {
+		return UrgentClass
+	}
+	return a.Store.Rules.Default
+}
+
+// classifyNew FETCHes the new messages first through last and returns the
+// most urgent class the account rules give them and their UIDs. Without rules
+// all new mail is urgent and nothing is fetched.
+func (a *Account) classifyNew(first, last int) (Class, []uint32) {
+	if len(a.Store.Rules.Rules) == 0 {
+		return UrgentClass, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(uint32(first), uint32(last))
+	msgs := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.c.Fetch(seqset, append([]imap.FetchItem{imap.FetchUid}, ruleFetchItems...), msgs)
+	}()
+
+	class := IgnoreClass
+	var uids []uint32
+	for msg := range msgs {
+		uids = append(uids, msg.Uid)
+		c, r := a.Store.Rules.Classify(msg)
+		if r != nil {
+			a.log.Debugf("message %d matched rule: %v", msg.SeqNum, r)
+		} else {
+			a.log.Debugf("message %d matched no rule: %v", msg.SeqNum, c)
+		}
+		if c > class {
+			class = c
+		}
+	}
+	if err := <-done; err != nil {
+		a.log.Warnf("got error fetching new messages, treating as urgent: %v", err)
+		return UrgentClass, nil
+	}
+	return class, uids
+}
+
+// seqRange returns the sequence numbers first through last.
+func seqRange(first, last int) []uint32 {
+	var seqNums []uint32
+	for i := first; i <= last && i > 0; i++ {
+		seqNums = append(seqNums, uint32(i))
+	}
+	return seqNums
+}
+
+func (a *Account) CheckMail(count int, class Class) {
+	a.newMail(count, class, nil)
+}
+
+// signalNew classifies and signals the last count messages of INBOX.
+func (a *Account) signalNew(count int) {
+	class, uids := a.classifyNew(a.MsgCount-count+1, a.MsgCount)
+	a.newMail(count, class, uids)
+}
+
+func (a *Account) newMail(count int, class Class, uids []uint32) {
+	if count == 0 {
+		a.log.Debugf("signaling FULL update")
+		a.send(Event{E: FullUpdateEvent, A: a, C: NormalClass})
+	} else if class == IgnoreClass {
+		a.log.Debugf("ignoring NEW mail until next full update: %d", count)
+	} else if a.Store.Trigger(NewMailChange) == IgnoreAction {
+		a.log.Debugf("ignoring NEW mail by trigger: %d", count)
+	} else {
+		a.log.Debugf("signaling NEW mail: %d (%v)", count, class)
+		a.send(Event{
+			E:       CheckMailEvent,
+			A:       a,
+			C:       class,
+			N:       count,
+			Total:   a.MsgCount,
+			SeqNums: seqRange(a.MsgCount-count+1, a.MsgCount),
+			UIDs:    uids,
+		})
+	}
+}
+
+// signalChange signals count expunged or changed messages unless the store
+// ignores the change.
+func (a *Account) signalChange(c Change, count int, seqNums, uids []uint32) {
+	if a.Store.Trigger(c) == IgnoreAction {
+		a.log.Debugf("ignoring %v change: %v", c, seqNums)
+		return
+	}
+	code := EventCode(ExpungeEvent)
+	if c == FlagsChange {
+		code = FlagsChangedEvent
+	}
+	a.log.Debugf("signaling %v change: %v", c, seqNums)
+	a.send(Event{
+		E:       code,
+		A:       a,
+		C:       a.defaultClass(),
+		N:       count,
+		Total:   a.MsgCount,
+		SeqNums: seqNums,
+		UIDs:    uids,
+	})
+}
+
+// updateType returns the name of the update type for stats.
+func updateType(u client.Update) string {
+	switch u.(type) {
+	case *client.MailboxUpdate:
+		return "mailbox"
+	case *client.StatusUpdate:
+		return "status"
+	case *client.ExpungeUpdate:
+		return "expunge"
+	case *client.MessageUpdate:
+		return "message"
+	}
+	return "unknown"
+}
+
+// handleUpdate handles an update received while IDLE, returning true if the
+// INBOX counts may have changed.
+func (a *Account) handleUpdate(u client.Update) bool {
+	a.updateStats(func(s *AccountStats) {
+		if s.Updates == nil {
+			s.Updates = make(map[string]int)
+		}
+		s.Updates[updateType(u)]++
+	})
+	if mu, ok := u.(*client.MailboxUpdate); ok {
+		newCount := int(mu.Mailbox.Messages) - a.MsgCount
+		for n := a.MsgCount + 1; n <= int(mu.Mailbox.Messages); n++ {
+			a.trackSeen(uint32(n), false)
+		}
+		a.MsgCount = int(mu.Mailbox.Messages)
+		a.recent = int(mu.Mailbox.Recent)
+		a.log.Debugf("got MailboxUpdate: Num Messages %v New Count %v", int(mu.Mailbox.Messages), newCount)
+		if newCount > 0 {
+			if len(a.Store.Rules.Rules) != 0 {
+				// Need the connection to FETCH, IDLE is
+				// restarted at the top of the loop.
+				a.StopIdle()
+			}
+			a.signalNew(newCount)
+		} else if newCount < 0 {
+			a.signalChange(ExpungeChange, -newCount, nil, nil)
+		}
+		return true
+	} else if su, ok := u.(*client.StatusUpdate); ok {
+		a.log.Debugf("got StatusUpdate: Tag %v Type %v Code %v Info %v", su.Status.Tag, su.Status.Type,
+			su.Status.Code, su.Status.Info)
+	} else if eu, ok := u.(*client.ExpungeUpdate); ok {
+		a.log.Debugf("got ExpungeUpdate: Expunge SeqNum %v", eu.SeqNum)
+		// The server needn't follow EXPUNGE with EXISTS, see RFC 3501
+		// section 7.4.1.
+		if a.MsgCount > 0 {
+			a.MsgCount--
+		}
+		a.trackExpunge(eu.SeqNum)
+		a.signalChange(ExpungeChange, 1, []uint32{eu.SeqNum}, nil)
+		return true
+	} else if msgu, ok := u.(*client.MessageUpdate); ok {
+		a.log.Debugf("got MessageUpdate: Message SeqNum %v Flags %v", msgu.Message.SeqNum, msgu.Message.Flags)
+		if _, ok := msgu.Message.Items[imap.FetchFlags]; ok {
+			seen := false
+			for _, f := range msgu.Message.Flags {
+				seen = seen || f == imap.SeenFlag
+			}
+			a.trackSeen(msgu.Message.SeqNum, seen)
+		}
+		var uids []uint32
+		if msgu.Message.Uid != 0 {
+			uids = []uint32{msgu.Message.Uid}
+		}
+		a.signalChange(FlagsChange, 1, []uint32{msgu.Message.SeqNum}, uids)
+		return true
+	} else {
+		a.log.Debugf("got Unknown update: %v", u)
+	}
+	return false
+}
+
+// nextByeBackoff returns how long to wait before reconnecting after the
+// server said BYE or that it's unavailable, doubling each time in a row.
+func (a *Account) nextByeBackoff() time.Duration {
+	if a.byeBackoff == 0 {
+		a.byeBackoff = a.ByeBackoff
+		if a.byeBackoff == 0 {
+			a.byeBackoff = DefByeBackoff
+		}
+		if a.byeBackoff < a.PollInt {
+			a.byeBackoff = a.PollInt
+		}
+	} else if a.byeBackoff *= 2; a.byeBackoff > maxByeBackoff {
+		a.byeBackoff = maxByeBackoff
+	}
+	return a.byeBackoff
+}
+
+// connLost returns true if the connection has been closed.
+func (a *Account) connLost() bool {
+	select {
+	case <-a.c.LoggedOut():
+		return true
+	default:
+		return false
+	}
+}
+
+// lost handles losing the connection for reason. After a BYE or an
+// [UNAVAILABLE] or [INUSE] response the server is given time before
+// reconnecting and true is returned, otherwise reconnecting is left to the
+// caller.
+func (a *Account) lost(ctx context.Context, reason string) bool {
+	code := a.codes.take()
+	if bye, byeCode := a.codes.takeBye(); bye != "" {
+		reason = "server said BYE: " + bye
+		code = byeCode
+	} else if code != "UNAVAILABLE" && code != "INUSE" {
+		a.logout(reason)
+		a.setError(reason)
+		return false
+	}
+	if code != "" {
+		reason = fmt.Sprintf("%s [%s]", reason, code)
+	}
+	a.log.Warnf("%s", reason)
+	a.logout(reason)
+	a.setState(Backoff, reason)
+	a.signalState()
+	a.pause(ctx, a.nextByeBackoff())
+	return true
+}
+
+// reportAlerts passes on the [ALERT]s from the server, RFC 3501 requires
+// they're shown to the user.
+func (a *Account) reportAlerts() {
+	if a.codes == nil {
+		return
+	}
+	for _, alert := range a.codes.takeAlerts() {
+		a.notice("server ALERT: " + alert)
+	}
+}
+
+// Online configures the account to go online and attempt to stay that way
+// until ctx is done. Errors connecting will be logged and retried after some
+// delay. Events are sent on c.
+func (a *Account) Online(ctx context.Context, c chan<- Event) {
+	a.initLog()
+	if a.primary != nil {
+		// The primary sends the events, see Share.
+		a.log.Debugf("sharing the connections of %s", a.primary.Name)
+		<-ctx.Done()
+		return
+	}
+	if a.eventc != nil {
+		a.log.Errorf("Account already online")
+		return
+	}
+
+	a.eventc = c
+	a.done = ctx.Done()
+	defer func() {
+		a.log.Debugf("Taking offline")
+		if r := recover(); r != nil {
+			// The connection state can't be trusted, drop it and
+			// let the watcher deal with the panic.
+			a.abandon()
+			a.wg.Wait()
+			a.eventc = nil
+			a.done = nil
+			panic(r)
+		}
+		a.logout("offline")
+		// Everything started for the connections is done once the
+		// connection is closed.
+		a.wg.Wait()
+		a.eventc = nil
+		a.done = nil
+	}()
+
+	a.log.Debugf("Taking online\n")
+
+	poller := a.newStatusPoller()
+	if poller != nil && !a.pollOnConn {
+		// Stopped before the deferred wait for the goroutines above
+		pctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			poller.run(pctx)
+		}()
+	}
+
+	var err error
+	refreshed := false // credentials refreshed after a refused login
+	for ctx.Err() == nil {
+		if !a.handleControl(ctx) {
+			break
+		}
+		wait := a.PollInterval()
+		if a.c == nil {
+			err := a.Login(ctx)
+			a.reportAlerts()
+			if err != nil {
+				a.updateStats(func(s *AccountStats) {
+					s.LoginFailures++
+				})
+			}
+			var lerr *LoginError
+			if err == nil {
+				refreshed = false
+				// Power saving polls log in each time
+				if !a.PowerSave() || len(a.Mailboxes()) == 0 {
+					a.discover()
+				}
+			} else if !errors.As(err, &lerr) || lerr.Failure.Retried() {
+				a.log.Warnf("login failed will retry: %v", err)
+				if lerr != nil && lerr.Failure == UnavailableFailure {
+					wait = a.nextByeBackoff()
+				}
+			} else if lerr.Failure == CredentialFailure && a.PassCmd != "" && !refreshed {
+				// The password may have changed, try once more.
+				a.log.Warnf("login refused, refreshing credentials: %v", err)
+				a.setPassword("")
+				refreshed = true
+				a.signalState()
+				continue
+			} else {
+				// Retrying could get the account locked.
+				a.log.Errorf("login refused, waiting for retry: %v", err)
+				a.notice(fmt.Sprintf("login refused, waiting for retry: %v", err))
+				a.signalState()
+				if !a.waitRetry(ctx) {
+					break
+				}
+				if a.PassCmd != "" {
+					a.setPassword("")
+				}
+				refreshed = false
+				continue
+			}
+			a.signalState()
+		}
+		if a.c == nil {
+			// No connnect, wait, then try and reconnect
+			a.pause(ctx, wait)
+			continue
+		} else if a.PowerSave() {
+			a.powerPoll(ctx)
+			continue
+		} else if !a.idleOk || a.pollOnConn {
+			// No IDLE, wait, then check for new messages
+			a.setState(Polling, "")
+			if a.pollOnConn && poller != nil {
+				a.pause(ctx, poller.interval())
+			} else {
+				a.PollPause(ctx)
+			}
+			if ctx.Err() == nil {
+				a.setState(Selecting, "")
+				a.CheckForNew()
+				if a.pollOnConn && poller != nil {
+					poller.pollOn(ctx, a.c)
+				}
+				a.reportAlerts()
+				if a.connLost() {
+					a.lost(ctx, "connection lost")
+				} else {
+					a.byeBackoff = 0
+				}
+			}
+			continue
+		} else if a.stopc == nil {
+			// If we have a client, but we are not IDLEing, start that.
+			a.codes.take()
+			newCount, err := a.checkForNew()
+			if err != nil {
+				// On error, logout, pause and try again
+				a.log.Warnf("got error selecting INBOX reconnecting: %v", err)
+				a.reportAlerts()
+				if !a.lost(ctx, err.Error()) {
+					a.setState(Backoff, err.Error())
+					a.PollPause(ctx)
+				}
+				continue
+			}
+			a.byeBackoff = 0
+			// Updates sent while IDLE was stopping are dropped
+			a.signalCount(newCount)
+			// Enable IDLE
+			a.Idle()
+			a.signalState()
+		}
+
+		a.log.Tracef("Selecting")
+
+		select {
+		case <-a.updatec:
+			a.reportAlerts()
+			recount := false
+			for _, u := range a.takeUpdates() {
+				if a.handleUpdate(u) {
+					recount = true
+				}
+			}
+			if recount {
+				a.setCounts("INBOX", a.inboxCounts())
+			}
+		case err = <-a.donec:
+			// Since we didn't ask for this it probably means the
+			// connection is lost.
+			a.log.Debugf("IDLE has stopped: %v", err)
+			a.donec = nil
+			reason := "IDLE stopped"
+			if err != nil {
+				reason = err.Error()
+			}
+			a.reportAlerts()
+			a.lost(ctx, reason)
+		case <-ctx.Done():
+			// Logged out on the way out.
+		case <-a.ctlChan():
+			// Handled at the top of the loop.
+		case <-a.t.C():
+			// Time to re-issue the command.
+			a.log.Debugf("IDLE refresh")
+			a.t = nil // we're done with this timer.
+			a.StopIdle()
+			a.updateStats(func(s *AccountStats) {
+				s.IdleRefreshes++
+			})
+		}
+		a.log.Tracef("out of select")
+	}
+}
@@ -0,0 +1,540 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/emersion/go-imap"
+)
+
+const testMessage = `From: Someone <someone@example.com>
+To: user@example.com
+Subject: Hello
+Date: Sun, 18 Oct 2026 10:00:00 +0000
+Message-ID: <1@example.com>
+
+Hello.
+`
+
+const testNewsletter = `From: News <news@lists.example.com>
+To: user@example.com
+Subject: Weekly news
+List-Id: Weekly News <news.lists.example.com>
+Date: Sun, 18 Oct 2026 10:00:00 +0000
+Message-ID: <2@example.com>
+
+News.
+`
+
+// startOnline takes the account online and waits for it to be idling (or
+// polling if the server doesn't support IDLE).
+func startOnline(t *testing.T, a *Account) chan Event {
+	t.Helper()
+	eventc := make(chan Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Online(ctx, eventc)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Account didn't go offline")
+		}
+	})
+	waitFor(t, 5*time.Second, "account to connect", func() bool {
+		s := a.Stats()
+		return s.State == Idling || s.State == Polling
+	})
+	return eventc
+}
+
+func TestLogin(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	if !a.idleOk {
+		t.Errorf("IDLE support not detected")
+	}
+	if s := a.Stats(); !s.Connected || s.Logins != 1 {
+		t.Errorf("Unexpected stats after login: %+v", s)
+	}
+
+	bad := fs.account()
+	bad.Password = "wrong"
+	if err := bad.Login(context.Background()); err == nil {
+		t.Errorf("Login with bad password succeeded")
+	}
+}
+
+func TestLoginNoIdle(t *testing.T) {
+	fs := newFakeServer(t, false)
+
+	a := fs.account()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	if a.idleOk {
+		t.Errorf("IDLE support detected on server without IDLE")
+	}
+}
+
+func TestIdleStopIdle(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.Deliver(testMessage)
+
+	a := fs.account()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	if _, err := a.selectInbox(); err != nil {
+		t.Fatalf("selectInbox: %v", err)
+	}
+	if a.MsgCount != 1 {
+		t.Errorf("MsgCount %d expected 1", a.MsgCount)
+	}
+
+	a.Idle()
+	if s := a.Stats(); !s.Idling {
+		t.Errorf("Not idling after Idle")
+	}
+	a.StopIdle()
+	if s := a.Stats(); s.Idling {
+		t.Errorf("Still idling after StopIdle")
+	}
+
+	// The connection is usable once IDLE is done
+	if _, err := a.selectInbox(); err != nil {
+		t.Fatalf("selectInbox after StopIdle: %v", err)
+	}
+}
+
+func TestIdleNewMail(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+
+	fs.Deliver(testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.A != a || e.C != UrgentClass {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if a.Stats().Updates["mailbox"] == 0 {
+		t.Errorf("Mailbox update not counted")
+	}
+}
+
+func TestIdleExpungeAndFlags(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.Deliver(testMessage)
+	fs.Deliver(testMessage)
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+
+	fs.SetFlags(1, []string{imap.SeenFlag})
+	e := waitEvent(t, eventc, FlagsChangedEvent, 5*time.Second)
+	if !reflect.DeepEqual(e.SeqNums, []uint32{1}) || e.N != 1 || e.Trigger() != FullAction {
+		t.Errorf("Unexpected flags event %+v", e)
+	}
+	// Recounted before IDLE is restarted
+	waitEvent(t, eventc, CountsEvent, 5*time.Second)
+
+	fs.Expunge(1)
+	e = waitEvent(t, eventc, ExpungeEvent, 5*time.Second)
+	if !reflect.DeepEqual(e.SeqNums, []uint32{1}) || e.Total != 1 || e.Trigger() != NowAction {
+		t.Errorf("Unexpected expunge event %+v", e)
+	}
+
+	// The expunge counts against the next EXISTS
+	waitEvent(t, eventc, CountsEvent, 5*time.Second)
+	fs.Deliver(testMessage)
+	e = waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if !reflect.DeepEqual(e.SeqNums, []uint32{2}) || e.N != 1 || e.Total != 2 {
+		t.Errorf("Unexpected new mail event %+v", e)
+	}
+}
+
+func TestNoStoreConfig(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.Deliver(testMessage)
+
+	// Accounts without imapidle settings use the defaults
+	a := fs.account()
+	a.Store = nil
+	eventc := startOnline(t, a)
+
+	fs.SetFlags(1, []string{imap.SeenFlag})
+	if e := waitEvent(t, eventc, FlagsChangedEvent, 5*time.Second); e.C != UrgentClass {
+		t.Errorf("Unexpected flags event %+v", e)
+	}
+	waitEvent(t, eventc, CountsEvent, 5*time.Second)
+	fs.Deliver(testMessage)
+	if e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second); e.C != UrgentClass {
+		t.Errorf("Unexpected new mail event %+v", e)
+	}
+}
+
+func TestIgnoreTrigger(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.Deliver(testMessage)
+
+	a := fs.account()
+	a.Store.Triggers[FlagsChange] = IgnoreAction
+	eventc := startOnline(t, a)
+
+	fs.SetFlags(1, []string{imap.SeenFlag})
+	noEvent(t, eventc, FlagsChangedEvent, 500*time.Millisecond)
+	fs.waitIdle()
+	fs.Expunge(1)
+	waitEvent(t, eventc, ExpungeEvent, 5*time.Second)
+}
+
+func TestIdleRefresh(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	a.IdleInt = 200 * time.Millisecond
+	eventc := startOnline(t, a)
+
+	waitFor(t, 5*time.Second, "IDLE refresh", func() bool {
+		return a.Stats().IdleRefreshes >= 2
+	})
+	if s := a.Stats(); s.Reconnects != 0 {
+		t.Errorf("Refresh reconnected: %+v", s)
+	}
+
+	// Still getting updates after the refresh
+	fs.Deliver(testMessage)
+	waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+}
+
+func TestPollFallback(t *testing.T) {
+	fs := newFakeServer(t, false)
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+	// Let the first poll count the messages
+	time.Sleep(3 * a.PollInt)
+
+	fs.Deliver(testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.A != a {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if s := a.Stats(); s.Idling {
+		t.Errorf("Idling on server without IDLE")
+	}
+}
+
+func TestReconnectOnDrop(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+
+	fs.Drop()
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 1 && s.Idling
+	})
+
+	fs.Deliver(testMessage)
+	waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+}
+
+func TestReconnectOnBye(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	a.ByeBackoff = 300 * time.Millisecond
+	eventc := startOnline(t, a)
+
+	start := time.Now()
+	fs.Bye("UNAVAILABLE", "Server shutting down")
+	waitFor(t, 5*time.Second, "backoff", func() bool {
+		return a.Stats().State == Backoff
+	})
+	s := a.Stats()
+	if s.LastError != "server said BYE: Server shutting down [UNAVAILABLE]" {
+		t.Errorf("Unexpected LastError %q", s.LastError)
+	}
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 1 && s.Idling
+	})
+	if d := time.Since(start); d < a.ByeBackoff {
+		t.Errorf("Reconnected after %v expected at least %v", d, a.ByeBackoff)
+	}
+	noEvent(t, eventc, NoticeEvent, 100*time.Millisecond)
+}
+
+func TestAlert(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+
+	fs.waitIdle()
+	fs.Status(imap.StatusRespOk, "ALERT", "Mailbox nearly full")
+	e := waitEvent(t, eventc, NoticeEvent, 5*time.Second)
+	if e.A != a || e.M != "server ALERT: Mailbox nearly full" {
+		t.Errorf("Unexpected notice %+v", e)
+	}
+}
+
+func TestLoginAlert(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.RefuseLogins("ALERT")
+
+	a := fs.account()
+	eventc := make(chan Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Online(ctx, eventc)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	e := waitEvent(t, eventc, NoticeEvent, 5*time.Second)
+	if e.M != "server ALERT: login refused" {
+		t.Errorf("Unexpected notice %+v", e)
+	}
+}
+
+func TestRules(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	for _, r := range [][]string{
+		{"ignore", "List-Id", "lists\\.example\\.com"},
+		{"urgent", "From", "someone@example\\.com"},
+	} {
+		rule, err := NewRule(r[0], r[1], r[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Store.Rules.Rules = append(a.Store.Rules.Rules, rule)
+	}
+	eventc := startOnline(t, a)
+
+	fs.Deliver(testNewsletter)
+	noEvent(t, eventc, CheckMailEvent, 500*time.Millisecond)
+
+	fs.Deliver(testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.C != UrgentClass {
+		t.Errorf("Expected urgent class got %v", e.C)
+	}
+	if !reflect.DeepEqual(e.UIDs, []uint32{2}) || !reflect.DeepEqual(e.SeqNums, []uint32{2}) {
+		t.Errorf("Unexpected UIDs %v and sequence numbers %v", e.UIDs, e.SeqNums)
+	}
+}
+
+func TestIdleRefreshClock(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fc := clock.NewFake()
+
+	a := fs.account()
+	a.Clock = fc
+	eventc := startOnline(t, a)
+	waitPending(t, fc, 1)
+
+	fc.Advance(IdleTimeout - time.Second)
+	noEvent(t, eventc, CheckMailEvent, 100*time.Millisecond)
+	if s := a.Stats(); s.IdleRefreshes != 0 {
+		t.Fatalf("IDLE refreshed early: %+v", s)
+	}
+
+	fc.Advance(time.Second)
+	waitPending(t, fc, 1)
+	waitFor(t, 5*time.Second, "IDLE refresh", func() bool {
+		s := a.Stats()
+		return s.IdleRefreshes == 1 && s.Idling
+	})
+	s := a.Stats()
+	if s.Reconnects != 0 || !s.LastIdle.Equal(fc.Now()) {
+		t.Errorf("Unexpected stats after refresh: %+v", s)
+	}
+	noEvent(t, eventc, CheckMailEvent, 100*time.Millisecond)
+}
+
+func TestPollClock(t *testing.T) {
+	fs := newFakeServer(t, false)
+	fc := clock.NewFake()
+
+	a := fs.account()
+	a.Clock = fc
+	a.PollInt = DefPollInterval
+	eventc := startOnline(t, a)
+
+	// The first poll counts the messages
+	waitPending(t, fc, 1)
+	fc.Advance(DefPollInterval)
+	waitPending(t, fc, 1)
+
+	fs.Deliver(testMessage)
+	fc.Advance(DefPollInterval - time.Second)
+	noEvent(t, eventc, CheckMailEvent, 100*time.Millisecond)
+	fc.Advance(time.Second)
+	waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	waitPending(t, fc, 1)
+	noEvent(t, eventc, CheckMailEvent, 100*time.Millisecond)
+}
+
+func TestPause(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	startOnline(t, a)
+
+	a.Pause(true)
+	waitFor(t, 5*time.Second, "pause", func() bool {
+		return a.Stats().State == Paused
+	})
+	if !a.Paused() {
+		t.Errorf("Paused account not Paused()")
+	}
+	logins := fs.Logins()
+	time.Sleep(5 * a.PollInt)
+	if n := fs.Logins(); n != logins {
+		t.Errorf("%d logins while paused", n-logins)
+	}
+
+	a.Pause(false)
+	waitFor(t, 5*time.Second, "resume", func() bool {
+		return a.Stats().Idling
+	})
+	if n := invalid(a); n != 0 {
+		t.Errorf("%d invalid transitions", n)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	a.ByeBackoff = time.Hour
+	startOnline(t, a)
+
+	// Drops a working connection
+	a.Reconnect()
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 1 && s.Idling
+	})
+
+	// And cuts a backoff short
+	fs.Bye("UNAVAILABLE", "Server shutting down")
+	waitFor(t, 5*time.Second, "backoff", func() bool {
+		return a.Stats().State == Backoff
+	})
+	a.Reconnect()
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 2 && s.Idling
+	})
+}
+
+func TestPowerSave(t *testing.T) {
+	fs := newFakeServer(t, true)
+
+	a := fs.account()
+	a.PowerPollInt = 300 * time.Millisecond
+	eventc := startOnline(t, a)
+
+	a.SetPowerSave(true)
+	waitFor(t, 5*time.Second, "sleep", func() bool {
+		return a.Stats().State == Sleeping
+	})
+	logins := fs.Logins()
+	fs.Deliver(testMessage)
+	if e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second); e.N != 1 || e.Total != 1 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if fs.Logins() == logins {
+		t.Errorf("Polled without logging in")
+	}
+
+	a.SetPowerSave(false)
+	waitFor(t, 5*time.Second, "IDLE", func() bool {
+		return a.Stats().Idling
+	})
+	if s := a.Stats(); s.Reconnects != 1 {
+		t.Errorf("%d reconnects expected 1", s.Reconnects)
+	}
+	if n := invalid(a); n != 0 {
+		t.Errorf("%d invalid transitions", n)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.Deliver(testMessage)
+	fs.Deliver(testMessage)
+	fs.SetFlags(1, []string{imap.SeenFlag})
+
+	a := fs.account()
+	eventc := startOnline(t, a)
+	expect := func(want Counts) {
+		t.Helper()
+		e := waitEvent(t, eventc, CountsEvent, 5*time.Second)
+		if got := a.Stats().Counts[e.Mailbox]; e.Mailbox != "INBOX" || got != want {
+			t.Errorf("%s counts %+v expected %+v", e.Mailbox, got, want)
+		}
+	}
+	expect(Counts{Messages: 2, Unseen: 1})
+
+	// Changes are counted right away from the updates
+	fs.Deliver(testMessage)
+	expect(Counts{Messages: 3, Unseen: 2})
+	fs.SetFlags(2, []string{imap.SeenFlag})
+	expect(Counts{Messages: 3, Unseen: 1})
+	fs.Expunge(3)
+	expect(Counts{Messages: 2, Unseen: 0})
+	fs.Deliver(testMessage)
+	expect(Counts{Messages: 3, Unseen: 1})
+	fs.SetFlags(3, []string{imap.SeenFlag, imap.FlaggedFlag})
+	expect(Counts{Messages: 3, Unseen: 0})
+	fs.SetFlags(1, nil)
+	expect(Counts{Messages: 3, Unseen: 1})
+
+	// Without searching again
+	if n := fs.Searches(); n != 1 {
+		t.Errorf("%d searches expected 1", n)
+	}
+}
@@ -0,0 +1,113 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Change is a kind of INBOX change seen while watching a store.
+type Change int
+
+const (
+	NewMailChange Change = iota // messages arrived
+	ExpungeChange               // messages were removed
+	FlagsChange                 // message flags changed, e.g., read elsewhere
+)
+
+var changeNames = []string{"new", "expunge", "flags"}
+
+func (c Change) String() string {
+	if c >= 0 && int(c) < len(changeNames) {
+		return changeNames[c]
+	}
+	return fmt.Sprintf("Change(%d)", int(c))
+}
+
+// ParseChange returns the change with the given name.
+func ParseChange(s string) (Change, error) {
+	for i, n := range changeNames {
+		if strings.EqualFold(s, n) {
+			return Change(i), nil
+		}
+	}
+	return NewMailChange, fmt.Errorf("Unknown change %s", s)
+}
+
+// Action is what a change triggers.
+type Action int
+
+const (
+	NowAction    Action = iota // an update of the INBOX
+	FullAction                 // only an event, the next full update syncs it
+	IgnoreAction               // nothing, not even an event
+)
+
+var actionNames = []string{"now", "full", "ignore"}
+
+func (a Action) String() string {
+	if a >= 0 && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ParseAction returns the action with the given name.
+func ParseAction(s string) (Action, error) {
+	for i, n := range actionNames {
+		if strings.EqualFold(s, n) {
+			return Action(i), nil
+		}
+	}
+	return NowAction, fmt.Errorf("Unknown action %s", s)
+}
+
+// DefaultTriggers are the actions of each change unless configured, flag
+// changes are usually someone reading mail in another client.
+var DefaultTriggers = [...]Action{
+	NewMailChange: NowAction,
+	ExpungeChange: NowAction,
+	FlagsChange:   FullAction,
+}
+
+// Trigger returns the action the store takes for a change.
+func (sc *StoreConfig) Trigger(c Change) Action {
+	if sc == nil {
+		return DefaultTriggers[c]
+	}
+	return sc.Triggers[c]
+}
+
+// eventChanges are the changes signalled by each change event.
+var eventChanges = map[EventCode]Change{
+	CheckMailEvent:    NewMailChange,
+	ExpungeEvent:      ExpungeChange,
+	FlagsChangedEvent: FlagsChange,
+}
+
+// Trigger returns the store's action for a change event, NowAction for other
+// events.
+func (e *Event) Trigger() Action {
+	if c, ok := eventChanges[e.E]; ok && e.A != nil {
+		return e.A.Store.Trigger(c)
+	}
+	return NowAction
+}
@@ -0,0 +1,309 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/choppsv1/imapidle/logging"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/choppsv1/imapidle/proxy"
+	"github.com/choppsv1/imapidle/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// StoreConfig holds the imapidle specific settings for an IMAPStore, these
+// are kept in the imapidle config file rather than the .mbsyncrc so mbsync
+// doesn't choke on them.
+type StoreConfig struct {
+	Name  string
+	Rules RuleSet
+	Sinks []sink.Config // where to send the store's events
+
+	Schedule *Schedule // when the store is active, always if nil
+	OffHours Policy    // outside the schedule
+	Priority Priority  // when saving power or data
+
+	Triggers [len(DefaultTriggers)]Action // by Change
+
+	Polls        []PollTarget  // mailboxes checked with STATUS besides INBOX
+	PollInterval time.Duration // between STATUS polls, DefStatusPollInt if 0
+
+	// Proxy is the URL of the proxy to connect through, "none" to connect
+	// directly, the environment's if empty, see proxy.Environment.
+	Proxy string `json:"-"`
+
+	// Connecting to the server (or proxy), see ConnectTimes.
+	DialTimeout   time.Duration // DefDialTimeout if 0
+	TLSTimeout    time.Duration // DefTLSTimeout if 0
+	AddressFamily AddressFamily
+	BindAddress   net.IP // local address to connect from
+	BindInterface string // interface whose address to connect from
+	Resolver      string // DNS server host[:port], the system's if empty
+}
+
+// Config is the parsed imapidle config file.
+type Config struct {
+	Stores map[string]*StoreConfig
+}
+
+// NewStoreConfig returns the default config for the named store.
+func NewStoreConfig(name string) *StoreConfig {
+	return &StoreConfig{
+		Name: name,
+		Rules: RuleSet{
+			Default: NormalClass,
+		},
+		OffHours: QuietPolicy,
+		Triggers: DefaultTriggers,
+	}
+}
+
+// parseOffHours sets an OffHours setting of sc.
+func parseOffHours(sc *StoreConfig, v []string) error {
+	if len(v) != 2 {
+		return fmt.Errorf("OffHours requires a setting and a value")
+	}
+	var err error
+	switch strings.ToLower(v[0]) {
+	case "sync":
+		sc.OffHours.Sync, err = ParseSyncPolicy(v[1])
+	case "fullinterval":
+		if strings.EqualFold(v[1], "off") {
+			sc.OffHours.FullInterval = -1
+		} else if sc.OffHours.FullInterval, err = time.ParseDuration(v[1]); err == nil && sc.OffHours.FullInterval < 0 {
+			err = fmt.Errorf("Negative FullInterval %s", v[1])
+		}
+	case "notify":
+		switch strings.ToLower(v[1]) {
+		case "yes":
+			sc.OffHours.Notify = true
+		case "no":
+			sc.OffHours.Notify = false
+		default:
+			err = fmt.Errorf("Notify requires yes or no")
+		}
+	default:
+		err = fmt.Errorf("Unknown OffHours setting %s", v[0])
+	}
+	return err
+}
+
+// parseTimeout parses a positive duration.
+func parseTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	return d, err
+}
+
+// Store returns the config for the named store, or the defaults if there is
+// none.
+func (c *Config) Store(name string) *StoreConfig {
+	if sc, ok := c.Stores[name]; ok {
+		return sc
+	}
+	return NewStoreConfig(name)
+}
+
+// ParseConfig parses the imapidle config file. The format follows .mbsyncrc:
+// sections start with a "Store <name>" line and are terminated by a blank
+// line. A missing file is not an error.
+func ParseConfig(fileName string) (*Config, error) {
+	config := &Config{
+		Stores: make(map[string]*StoreConfig),
+	}
+
+	f, err := os.Open(mbsyncrc.ExpandTilde(fileName))
+	if os.IsNotExist(err) {
+		log.Debugf("No config file %s", fileName)
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sc *StoreConfig
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno += 1
+
+		l := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		// Every line is either read or an error, so check them all
+		if err := mbsyncrc.CheckQuotes(l); err != nil {
+			return nil, fmt.Errorf("%d: %v", lineno, err)
+		}
+
+		// Blank lines terminate a section
+		if l == "" {
+			sc = nil
+			continue
+		}
+
+		if sc == nil {
+			if ok, v := mbsyncrc.GetValue(l, "Store"); ok {
+				if _, ok := config.Stores[v]; ok {
+					return nil, fmt.Errorf("%d: Duplicate Store %v", lineno, v)
+				}
+				sc = NewStoreConfig(v)
+				config.Stores[v] = sc
+			} else {
+				return nil, fmt.Errorf("%d: Expected Store section: \"%v\"", lineno, l)
+			}
+			continue
+		}
+
+		if ok, v := mbsyncrc.GetValues(l, "Rule"); ok {
+			if len(v) != 3 {
+				return nil, fmt.Errorf("%d: Rule requires class, field and pattern", lineno)
+			}
+			r, err := NewRule(v[0], v[1], v[2])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+			sc.Rules.Rules = append(sc.Rules.Rules, r)
+		} else if ok, v := mbsyncrc.GetValues(l, "Sink"); ok {
+			if len(v) < 2 || len(v) > 3 || (len(v) == 3 && v[0] != "webhook") {
+				return nil, fmt.Errorf("%d: Sink requires type, target and for webhooks an optional secret", lineno)
+			}
+			c := sink.Config{Type: v[0], Target: v[1]}
+			switch c.Type {
+			case "webhook":
+				if len(v) == 3 {
+					c.Secret = v[2]
+				}
+			case "socket", "pipe":
+				c.Target = mbsyncrc.ExpandTilde(c.Target)
+			default:
+				return nil, fmt.Errorf("%d: Unknown sink type %s", lineno, c.Type)
+			}
+			sc.Sinks = append(sc.Sinks, c)
+		} else if ok, v := mbsyncrc.GetValues(l, "Schedule"); ok {
+			if len(v) != 2 {
+				return nil, fmt.Errorf("%d: Schedule requires days and hours", lineno)
+			}
+			w, err := ParseWindow(v[0], v[1])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+			if sc.Schedule == nil {
+				sc.Schedule = &Schedule{}
+			}
+			sc.Schedule.Windows = append(sc.Schedule.Windows, w)
+		} else if ok, v := mbsyncrc.GetValues(l, "OffHours"); ok {
+			if err := parseOffHours(sc, v); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValues(l, "Trigger"); ok {
+			if len(v) != 2 {
+				return nil, fmt.Errorf("%d: Trigger requires a change and an action", lineno)
+			}
+			c, err := ParseChange(v[0])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+			if sc.Triggers[c], err = ParseAction(v[1]); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValues(l, "Poll"); ok {
+			if len(v) < 1 || len(v) > 2 {
+				return nil, fmt.Errorf("%d: Poll requires a mailbox or pattern and an optional channel", lineno)
+			}
+			t := PollTarget{Pattern: v[0]}
+			if !IsSpecialUse(t.Pattern) {
+				if t.Pattern, err = NormalizeMailbox(t.Pattern); err != nil {
+					return nil, fmt.Errorf("%d: %v", lineno, err)
+				}
+			}
+			if len(v) == 2 {
+				t.Channel = v[1]
+			}
+			sc.Polls = append(sc.Polls, t)
+		} else if ok, v := mbsyncrc.GetValue(l, "PollInterval"); ok {
+			if sc.PollInterval, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			} else if sc.PollInterval <= 0 {
+				return nil, fmt.Errorf("%d: PollInterval must be positive", lineno)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "Proxy"); ok {
+			if !strings.EqualFold(v, "none") {
+				u, err := proxy.Parse(v)
+				if err != nil {
+					return nil, fmt.Errorf("%d: %v", lineno, err)
+				}
+				if pass, ok := u.User.Password(); ok {
+					logging.AddSecret(pass)
+				}
+			}
+			sc.Proxy = v
+		} else if ok, v := mbsyncrc.GetValue(l, "DialTimeout"); ok {
+			if sc.DialTimeout, err = parseTimeout(v); err != nil {
+				return nil, fmt.Errorf("%d: DialTimeout %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "TLSTimeout"); ok {
+			if sc.TLSTimeout, err = parseTimeout(v); err != nil {
+				return nil, fmt.Errorf("%d: TLSTimeout %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "AddressFamily"); ok {
+			if sc.AddressFamily, err = ParseAddressFamily(v); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "BindAddress"); ok {
+			if sc.BindAddress = net.ParseIP(v); sc.BindAddress == nil {
+				return nil, fmt.Errorf("%d: Bad BindAddress %s", lineno, v)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "BindInterface"); ok {
+			sc.BindInterface = v
+		} else if ok, v := mbsyncrc.GetValue(l, "Resolver"); ok {
+			host := v
+			if h, _, err := net.SplitHostPort(v); err == nil {
+				host = h
+			}
+			if net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("%d: Resolver requires an IP address", lineno)
+			}
+			sc.Resolver = v
+		} else if ok, v := mbsyncrc.GetValue(l, "Priority"); ok {
+			if sc.Priority, err = ParsePriority(v); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+		} else if ok, v := mbsyncrc.GetValue(l, "RuleDefault"); ok {
+			if sc.Rules.Default, err = ParseClass(v); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineno, err)
+			}
+		} else {
+			return nil, fmt.Errorf("%d: Unknown keyword: \"%v\"", lineno, l)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,160 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/sink"
+)
+
+const testConfig = `# imapidle config
+Store work-remote
+RuleDefault ignore
+Rule urgent From "boss@example\\.com"
+Sink webhook https://bot.example.com/mail "s3cret"
+Sink pipe /run/user/1000/imapidle.fifo
+Schedule Mon-Fri 08:00-19:00
+Schedule Sat 10:00-12:00
+OffHours Sync urgent
+OffHours FullInterval 4h
+Priority urgent
+Trigger flags ignore
+Trigger expunge full
+Poll "Lists/*" lists
+Poll Archive
+Poll Entw&APw-rfe drafts
+PollInterval 10m
+Proxy socks5h://127.0.0.1:9050
+DialTimeout 10s
+TLSTimeout 5s
+AddressFamily prefer-ipv6
+BindAddress 192.0.2.10
+BindInterface wlan0
+Resolver 192.0.2.53
+
+Store home-remote
+Sink socket /run/user/1000/ha.sock
+`
+
+func writeConfig(t *testing.T, config string) string {
+	fileName := filepath.Join(t.TempDir(), "imapidlerc")
+	if err := ioutil.WriteFile(fileName, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	work := config.Store("work-remote")
+	if work.Rules.Default != IgnoreClass || len(work.Rules.Rules) != 1 {
+		t.Errorf("Unexpected work-remote rules %+v", work.Rules)
+	} else if work.Rules.Rules[0].Pattern != `boss@example\.com` {
+		t.Errorf("Rule pattern %q not unescaped", work.Rules.Rules[0].Pattern)
+	}
+	want := []sink.Config{
+		{Type: "webhook", Target: "https://bot.example.com/mail", Secret: "s3cret"},
+		{Type: "pipe", Target: "/run/user/1000/imapidle.fifo"},
+	}
+	if !reflect.DeepEqual(work.Sinks, want) {
+		t.Errorf("work-remote sinks %+v expected %+v", work.Sinks, want)
+	}
+	if work.Schedule == nil || len(work.Schedule.Windows) != 2 {
+		t.Errorf("Unexpected work-remote schedule %+v", work.Schedule)
+	}
+	wantPolicy := Policy{Sync: SyncUrgent, FullInterval: 4 * time.Hour}
+	if work.OffHours != wantPolicy {
+		t.Errorf("work-remote off hours %+v expected %+v", work.OffHours, wantPolicy)
+	}
+	if work.Priority != UrgentPriority {
+		t.Errorf("work-remote priority %v expected urgent", work.Priority)
+	}
+	if work.Triggers != [...]Action{NowAction, FullAction, IgnoreAction} {
+		t.Errorf("work-remote triggers %v", work.Triggers)
+	}
+	wantPolls := []PollTarget{{Pattern: "Lists/*", Channel: "lists"}, {Pattern: "Archive"}, {Pattern: "Entwürfe", Channel: "drafts"}}
+	if !reflect.DeepEqual(work.Polls, wantPolls) || work.PollInterval != 10*time.Minute {
+		t.Errorf("work-remote polls %+v every %v expected %+v", work.Polls, work.PollInterval, wantPolls)
+	}
+	if work.Proxy != "socks5h://127.0.0.1:9050" {
+		t.Errorf("work-remote Proxy %q", work.Proxy)
+	}
+	if work.DialTimeout != 10*time.Second || work.TLSTimeout != 5*time.Second || work.AddressFamily != PreferIPv6 ||
+		!work.BindAddress.Equal(net.ParseIP("192.0.2.10")) || work.BindInterface != "wlan0" || work.Resolver != "192.0.2.53" {
+		t.Errorf("work-remote dialer settings %+v", work)
+	}
+	home := config.Store("home-remote")
+	want = []sink.Config{{Type: "socket", Target: "/run/user/1000/ha.sock"}}
+	if !reflect.DeepEqual(home.Sinks, want) {
+		t.Errorf("home-remote sinks %+v expected %+v", home.Sinks, want)
+	}
+	if home.Schedule != nil || home.OffHours != QuietPolicy || home.Priority != NormalPriority || home.Triggers != DefaultTriggers {
+		t.Errorf("Unexpected home-remote schedule %+v %+v %v", home.Schedule, home.OffHours, home.Priority)
+	}
+	if other := config.Store("other"); len(other.Sinks) != 0 || other.Rules.Default != NormalClass {
+		t.Errorf("Unexpected default config %+v", other)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"Rule urgent From x\n",
+		"Store s\nRule urgent From\n",
+		"Store s\nSink carrier-pigeon coop\n",
+		"Store s\nSink socket /tmp/s secret\n",
+		"Store s\nSink webhook\n",
+		"Store s\nBogus x\n",
+		"Store s\nSchedule Mon-Fri\n",
+		"Store s\nSchedule Mon-Fry 08:00-19:00\n",
+		"Store s\nSchedule Mon 8-19\n",
+		"Store s\nSchedule Mon 08:00-25:00\n",
+		"Store s\nOffHours Sync later\n",
+		"Store s\nOffHours FullInterval -1h\n",
+		"Store s\nOffHours Notify maybe\n",
+		"Store s\nOffHours Volume low\n",
+		"Store s\nPriority high\n",
+		"Store s\nTrigger flags\n",
+		"Store s\nTrigger seen now\n",
+		"Store s\nTrigger flags later\n",
+		"Store s\nPoll\n",
+		"Store s\nPoll a b c\n",
+		"Store s\nPoll Entw\xfcrfe\n",
+		"Store s\nPollInterval often\n",
+		"Store s\nPollInterval 0s\n",
+		"Store s\nProxy gopher://proxy\n",
+		"Store s\nDialTimeout 0s\n",
+		"Store s\nTLSTimeout soon\n",
+		"Store s\nAddressFamily ipx\n",
+		"Store s\nBindAddress eth0\n",
+		"Store s\nResolver dns.example.com\n",
+	} {
+		if _, err := ParseConfig(writeConfig(t, config)); err == nil {
+			t.Errorf("No error parsing %q", config)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choppsv1/imapidle/proxy"
+	"github.com/emersion/go-imap/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefDialTimeout = 30 * time.Second // resolving, connecting and the greeting
+	DefTLSTimeout  = 30 * time.Second // the TLS handshake
+)
+
+// AddressFamily selects which addresses of the server are connected to and in
+// what order.
+type AddressFamily int
+
+const (
+	AnyFamily  AddressFamily = iota // in the order they were resolved
+	IPv4Only                        // only IPv4 addresses
+	IPv6Only                        // only IPv6 addresses
+	PreferIPv4                      // IPv4 addresses first
+	PreferIPv6                      // IPv6 addresses first
+)
+
+var familyNames = []string{"any", "ipv4", "ipv6", "prefer-ipv4", "prefer-ipv6"}
+
+func (f AddressFamily) String() string {
+	if f >= 0 && int(f) < len(familyNames) {
+		return familyNames[f]
+	}
+	return fmt.Sprintf("AddressFamily(%d)", int(f))
+}
+
+// ParseAddressFamily returns the address family with the given name.
+func ParseAddressFamily(s string) (AddressFamily, error) {
+	for i, n := range familyNames {
+		if strings.EqualFold(s, n) {
+			return AddressFamily(i), nil
+		}
+	}
+	return AnyFamily, fmt.Errorf("Unknown address family %s", s)
+}
+
+// order returns the addresses of the family in the order to try them.
+func (f AddressFamily) order(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch f {
+	case IPv4Only:
+		return v4
+	case IPv6Only:
+		return v6
+	case PreferIPv4:
+		return append(v4, v6...)
+	case PreferIPv6:
+		return append(v6, v4...)
+	}
+	return ips
+}
+
+// ConnectTimes are how long each phase of connecting and logging in to the
+// server took.
+type ConnectTimes struct {
+	DNS      time.Duration // resolving the server's (or proxy's) name
+	TCP      time.Duration // connecting, through the proxy if any
+	TLS      time.Duration // the TLS handshake, or STARTTLS
+	Greeting time.Duration // waiting for the server's greeting
+	Auth     time.Duration // logging in
+}
+
+// Total returns the time taken by all the phases.
+func (t ConnectTimes) Total() time.Duration {
+	return t.DNS + t.TCP + t.TLS + t.Greeting + t.Auth
+}
+
+func (t ConnectTimes) fields() log.Fields {
+	return log.Fields{
+		"dns":      t.DNS,
+		"tcp":      t.TCP,
+		"tls":      t.TLS,
+		"greeting": t.Greeting,
+		"auth":     t.Auth,
+	}
+}
+
+// netDialer connects directly as the store's config says, timing resolving
+// the name.
+type netDialer struct {
+	family   AddressFamily
+	bind     net.IP // local address, if set
+	iface    string // interface whose address is the local address, if set
+	resolver *net.Resolver
+	times    *ConnectTimes
+}
+
+// DialContext connects to the addresses of addr in turn, each being given an
+// equal share of the time left as net.Dialer does.
+func (d *netDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		start := time.Now()
+		addrs, err := d.resolver.LookupIPAddr(ctx, host)
+		d.times.DNS += time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if ips = d.family.order(ips); len(ips) == 0 {
+		return nil, fmt.Errorf("No %s address for %s", d.family, host)
+	}
+
+	var firstErr error
+	for i, ip := range ips {
+		local, err := d.localAddr(ip)
+		if err == nil {
+			dialer := net.Dialer{LocalAddr: local}
+			if deadline, ok := ctx.Deadline(); ok {
+				dialer.Deadline = time.Now().Add(time.Until(deadline) / time.Duration(len(ips)-i))
+			}
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// localAddr returns the local address to connect to ip from, nil for any.
+func (d *netDialer) localAddr(ip net.IP) (net.Addr, error) {
+	v4 := ip.To4() != nil
+	if d.bind != nil {
+		if (d.bind.To4() != nil) != v4 {
+			return nil, fmt.Errorf("BindAddress %s can't reach %s", d.bind, ip)
+		}
+		return &net.TCPAddr{IP: d.bind}, nil
+	}
+	if d.iface == "" {
+		return nil, nil
+	}
+	ifi, err := net.InterfaceByName(d.iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || (n.IP.To4() != nil) != v4 || n.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return &net.TCPAddr{IP: n.IP}, nil
+	}
+	family := "IPv6"
+	if v4 {
+		family = "IPv4"
+	}
+	return nil, fmt.Errorf("No %s address on %s to reach %s", family, d.iface, ip)
+}
+
+// newResolver returns a resolver asking the DNS server at addr, port 53 if it
+// has none.
+func newResolver(addr string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// dialer returns the Dialer connecting to the server: Dialer if set,
+// otherwise one using the store's dialer settings, through its Proxy or the
+// environment's if any.
+func (a *Account) dialer(times *ConnectTimes) (proxy.Dialer, error) {
+	if a.Dialer != nil {
+		return a.Dialer, nil
+	}
+	direct := &netDialer{resolver: net.DefaultResolver, times: times}
+	sc := a.Store
+	if sc == nil {
+		sc = NewStoreConfig(a.Name)
+	}
+	direct.family, direct.bind, direct.iface = sc.AddressFamily, sc.BindAddress, sc.BindInterface
+	if sc.Resolver != "" {
+		direct.resolver = newResolver(sc.Resolver)
+	}
+
+	var u *url.URL
+	var err error
+	switch {
+	case strings.EqualFold(sc.Proxy, "none"):
+		return direct, nil
+	case sc.Proxy == "":
+		u, err = proxy.Environment(a.Host)
+	default:
+		u, err = proxy.Parse(sc.Proxy)
+	}
+	if err != nil || u == nil {
+		return direct, err
+	}
+	a.baseLog.Debugf("connecting through proxy %s", u.Redacted())
+	d, err := proxy.FromURL(u, direct)
+	if s, ok := d.(*proxy.SOCKS5); ok {
+		s.Resolver = direct.resolver
+	}
+	return d, err
+}
+
+func (a *Account) dialTimeout() time.Duration {
+	if a.Store == nil || a.Store.DialTimeout == 0 {
+		return DefDialTimeout
+	}
+	return a.Store.DialTimeout
+}
+
+func (a *Account) tlsTimeout() time.Duration {
+	if a.Store == nil || a.Store.TLSTimeout == 0 {
+		return DefTLSTimeout
+	}
+	return a.Store.TLSTimeout
+}
+
+// dial opens a TCP connection to the server within the dial timeout.
+func (a *Account) dial(ctx context.Context, times *ConnectTimes) (net.Conn, error) {
+	dialer, err := a.dialer(times)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.dialTimeout())
+	defer cancel()
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(a.Host, strconv.Itoa(a.Port)))
+	times.TCP = time.Since(start) - times.DNS
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", a.Host, err)
+	}
+	return conn, nil
+}
+
+// handshake runs the TLS handshake of an IMAPS connection within the TLS
+// timeout.
+func (a *Account) handshake(conn *tls.Conn, times *ConnectTimes) error {
+	start := time.Now()
+	conn.SetDeadline(start.Add(a.tlsTimeout()))
+	err := conn.Handshake()
+	conn.SetDeadline(time.Time{})
+	times.TLS = time.Since(start)
+	if err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	return nil
+}
+
+// greet returns the client of a connection once the server's greeting is read
+// within what's left of the dial timeout after resolving and connecting,
+// starting TLS within the TLS timeout if the account uses STARTTLS. Deadlines
+// are set on raw, the TCP connection.
+func (a *Account) greet(raw, conn net.Conn, tlsConfig *tls.Config, times *ConnectTimes) (*client.Client, error) {
+	start := time.Now()
+	raw.SetDeadline(start.Add(a.dialTimeout() - times.DNS - times.TCP))
+	c, err := client.New(conn)
+	raw.SetDeadline(time.Time{})
+	times.Greeting = time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("greeting: %w", err)
+	}
+	if a.StartTLS {
+		start = time.Now()
+		raw.SetDeadline(start.Add(a.tlsTimeout()))
+		err = c.StartTLS(tlsConfig)
+		raw.SetDeadline(time.Time{})
+		times.TLS = time.Since(start)
+		if err != nil {
+			c.Terminate()
+			return nil, fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	return c, nil
+}
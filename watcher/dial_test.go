@@ -0,0 +1,387 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectProxy is an HTTP CONNECT proxy counting the tunnels it opens.
+type connectProxy struct {
+	l net.Listener
+
+	mu      sync.Mutex
+	tunnels []string
+}
+
+func newConnectProxy(t *testing.T) *connectProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &connectProxy{l: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != "CONNECT" {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	p.mu.Lock()
+	p.tunnels = append(p.tunnels, req.Host)
+	p.mu.Unlock()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+func (p *connectProxy) Tunnels() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.tunnels...)
+}
+
+func TestLoginThroughProxy(t *testing.T) {
+	fs := newFakeServer(t, true)
+	p := newConnectProxy(t)
+	a := fs.account()
+	a.Store.Proxy = "http://" + p.l.Addr().String()
+	eventc := startOnline(t, a)
+
+	tunnels := p.Tunnels()
+	if len(tunnels) != 1 || tunnels[0] != fs.l.Addr().String() {
+		t.Errorf("Tunnels %v expected one to %s", tunnels, fs.l.Addr())
+	}
+	fs.Deliver(testMessage)
+	waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+}
+
+func TestProxyNone(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	a.Store.Proxy = "none"
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	a.Logout()
+}
+
+// countingDialer counts the connections it dials.
+type countingDialer struct {
+	net.Dialer
+	mu    sync.Mutex
+	count int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.count++
+	d.mu.Unlock()
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+func TestDialer(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("Archive")
+	d := &countingDialer{}
+	a := fs.account()
+	a.Dialer = d
+	// The Dialer is used rather than the store's proxy.
+	a.Store.Proxy = "http://127.0.0.1:1"
+	a.Store.Polls = []PollTarget{{Pattern: "Archive"}}
+	a.Store.PollInterval = 50 * time.Millisecond
+	startOnline(t, a)
+	waitFor(t, 5*time.Second, "the status poller to connect", func() bool {
+		_, ok := a.Stats().Counts["Archive"]
+		return ok
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.count != 2 {
+		t.Errorf("Dialed %d connections expected 2", d.count)
+	}
+}
+
+// testHost is resolved by dnsServer, the fake server's certificate has it.
+const testHost = "imap.test"
+
+// dnsServer answers A queries for testHost with 127.0.0.1 and others with no
+// addresses, counting the queries.
+type dnsServer struct {
+	pc net.PacketConn
+
+	mu      sync.Mutex
+	queries int
+}
+
+func newDNSServer(t *testing.T) *dnsServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	d := &dnsServer{pc: pc}
+	go d.serve()
+	return d
+}
+
+func (d *dnsServer) serve() {
+	b := make([]byte, 512)
+	for {
+		n, addr, err := d.pc.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if n < 12 {
+			continue
+		}
+		d.mu.Lock()
+		d.queries++
+		d.mu.Unlock()
+
+		// The question is the name's labels, a type and a class.
+		q := b[12:n]
+		var name []string
+		i := 0
+		for i < len(q) && q[i] != 0 {
+			name = append(name, string(q[i+1:i+1+int(q[i])]))
+			i += 1 + int(q[i])
+		}
+		question := q[:i+5]
+		qtype := int(q[i+1])<<8 | int(q[i+2])
+
+		resp := append([]byte{b[0], b[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, question...)
+		if strings.Join(name, ".") == testHost && qtype == 1 {
+			resp[7] = 1
+			// Name pointer to the question, A, IN, TTL 60, 127.0.0.1
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+		}
+		d.pc.WriteTo(resp, addr)
+	}
+}
+
+func (d *dnsServer) Queries() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries
+}
+
+func TestConnectTimes(t *testing.T) {
+	fs := newFakeServer(t, true)
+	dns := newDNSServer(t)
+	a := fs.account()
+	a.Host = testHost
+	a.Store.Resolver = dns.pc.LocalAddr().String()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	if dns.Queries() == 0 {
+		t.Errorf("Resolver not asked")
+	}
+	ct := a.Stats().Connect
+	if ct.DNS <= 0 || ct.TCP <= 0 || ct.TLS <= 0 || ct.Greeting <= 0 || ct.Auth <= 0 {
+		t.Errorf("Connect times %+v", ct)
+	}
+	if ct.Total() != ct.DNS+ct.TCP+ct.TLS+ct.Greeting+ct.Auth {
+		t.Errorf("Total %v", ct.Total())
+	}
+}
+
+func TestResolverNoAddress(t *testing.T) {
+	fs := newFakeServer(t, true)
+	dns := newDNSServer(t)
+	a := fs.account()
+	a.Host = "other.test"
+	a.Store.Resolver = dns.pc.LocalAddr().String()
+	if err := a.Login(context.Background()); err == nil {
+		a.Logout()
+		t.Errorf("Logged in to a host without addresses")
+	}
+}
+
+// silentServer accepts connections and never writes to them.
+func silentServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTimeouts(t *testing.T) {
+	fs := newFakeServer(t, true)
+	l := silentServer(t)
+	for _, tc := range []struct {
+		startTLS bool
+		phase    string
+	}{
+		{false, "TLS handshake"},
+		{true, "greeting"},
+	} {
+		a := fs.account()
+		a.Port = l.Addr().(*net.TCPAddr).Port
+		a.StartTLS = tc.startTLS
+		a.Store.DialTimeout = 100 * time.Millisecond
+		a.Store.TLSTimeout = 100 * time.Millisecond
+		start := time.Now()
+		err := a.Login(context.Background())
+		if err == nil {
+			a.Logout()
+			t.Fatalf("Logged in to a silent server")
+		}
+		if !strings.HasPrefix(err.Error(), tc.phase) {
+			t.Errorf("Error %v expected a %s error", err, tc.phase)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("Took %v to time out", d)
+		}
+	}
+}
+
+func TestGreetingTimeout(t *testing.T) {
+	// The greeting gets what's left of the dial timeout
+	fs := newFakeServer(t, true)
+	l := silentServer(t)
+	a := fs.account()
+	a.Store.DialTimeout = 2 * time.Second
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	start := time.Now()
+	times := &ConnectTimes{DNS: 500 * time.Millisecond, TCP: 1400 * time.Millisecond}
+	if c, err := a.greet(raw, raw, nil, times); err == nil {
+		c.Terminate()
+		t.Fatalf("Greeted by a silent server")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Took %v to time out", d)
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	v4a, v4b, v6 := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")
+	ips := []net.IP{v4a, v6, v4b}
+	for f, want := range map[AddressFamily][]net.IP{
+		AnyFamily:  {v4a, v6, v4b},
+		IPv4Only:   {v4a, v4b},
+		IPv6Only:   {v6},
+		PreferIPv4: {v4a, v4b, v6},
+		PreferIPv6: {v6, v4a, v4b},
+	} {
+		if got := f.order(ips); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v ordered %v expected %v", f, got, want)
+		}
+		if p, err := ParseAddressFamily(f.String()); err != nil || p != f {
+			t.Errorf("ParseAddressFamily(%s) %v %v", f, p, err)
+		}
+	}
+	if _, err := ParseAddressFamily("ipv5"); err == nil {
+		t.Errorf("No error parsing ipv5")
+	}
+
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	a.Store.AddressFamily = IPv6Only
+	if err := a.Login(context.Background()); err == nil {
+		a.Logout()
+		t.Errorf("Logged in to an IPv4 address with IPv6Only")
+	}
+}
+
+func TestBind(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	a.Store.BindAddress = net.ParseIP("127.0.0.1")
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login from 127.0.0.1: %v", err)
+	}
+	a.Logout()
+
+	a = fs.account()
+	a.Store.BindAddress = net.ParseIP("::1")
+	if err := a.Login(context.Background()); err == nil {
+		a.Logout()
+		t.Errorf("Logged in to an IPv4 address from ::1")
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No lo interface: %v", err)
+	}
+	a = fs.account()
+	a.Store.BindInterface = lo.Name
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login from %s: %v", lo.Name, err)
+	}
+	a.Logout()
+
+	a = fs.account()
+	a.Store.BindInterface = "imapidle-none"
+	if err := a.Login(context.Background()); err == nil {
+		a.Logout()
+		t.Errorf("Logged in from a missing interface")
+	}
+}
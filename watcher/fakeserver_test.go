@@ -0,0 +1,822 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choppsv1/imapidle/clock"
+	"github.com/choppsv1/imapidle/mbsyncrc"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		// Accounts left running by tests log reconnect failures
+		logrus.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+const (
+	fakeUsername = "user"
+	fakePassword = "password"
+)
+
+// fakeMessage is a message held by the fake backend.
+type fakeMessage struct {
+	uid   uint32
+	date  time.Time
+	flags []string
+	body  []byte
+}
+
+func (m *fakeMessage) fetch(seqNum uint32, items []imap.FetchItem) (*imap.Message, error) {
+	fetched := imap.NewMessage(seqNum, items)
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope:
+			hdr, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(m.body)))
+			if err != nil {
+				return nil, err
+			}
+			fetched.Envelope, _ = backendutil.FetchEnvelope(hdr)
+		case imap.FetchFlags:
+			fetched.Flags = m.flags
+		case imap.FetchInternalDate:
+			fetched.InternalDate = m.date
+		case imap.FetchRFC822Size:
+			fetched.Size = uint32(len(m.body))
+		case imap.FetchUid:
+			fetched.Uid = m.uid
+		default:
+			section, err := imap.ParseBodySectionName(item)
+			if err != nil {
+				return nil, err
+			}
+			body := bufio.NewReader(bytes.NewReader(m.body))
+			hdr, err := textproto.ReadHeader(body)
+			if err != nil {
+				return nil, err
+			}
+			if fetched.Body[section], err = backendutil.FetchBodySection(hdr, body, section); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fetched, nil
+}
+
+// fakeBackend is an in-memory backend with a single user that can have
+// changes injected which are pushed to idling clients. The go-imap server
+// update mechanism isn't used as it races with command handling.
+type fakeBackend struct {
+	lock      sync.Mutex
+	mailboxes map[string]*fakeMailbox
+	idlers    map[chan imap.WriterTo]bool // connections running IDLE
+	pending   []imap.WriterTo             // responses waiting for an idler
+	logins    int                         // LOGIN commands
+	searches  int                         // SEARCH commands
+	loginCode imap.StatusRespCode         // refuse logins with this code
+	refused   map[string]bool             // commands answered with a plain NO
+}
+
+func newFakeBackend() *fakeBackend {
+	be := &fakeBackend{
+		mailboxes: make(map[string]*fakeMailbox),
+		idlers:    make(map[chan imap.WriterTo]bool),
+		refused:   make(map[string]bool),
+	}
+	be.mailboxes["INBOX"] = &fakeMailbox{be: be, name: "INBOX", uidNext: 1}
+	return be
+}
+
+func (be *fakeBackend) Login(_ *imap.ConnInfo, username, password string) (backend.User, error) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	be.logins++
+	if be.loginCode != "" {
+		return nil, &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: be.loginCode,
+			Info: "login refused",
+		}}
+	}
+	if username != fakeUsername || password != fakePassword {
+		return nil, backend.ErrInvalidCredentials
+	}
+	return &fakeUser{be: be}, nil
+}
+
+// push sends an untagged response to the idling clients, or the next client
+// to idle if there are none.
+func (be *fakeBackend) push(resp imap.WriterTo) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	if len(be.idlers) == 0 {
+		be.pending = append(be.pending, resp)
+		return
+	}
+	for c := range be.idlers {
+		c <- resp
+	}
+}
+
+// idle registers an idling connection, its channel receives the responses
+// to write.
+func (be *fakeBackend) idle() chan imap.WriterTo {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	c := make(chan imap.WriterTo, 100)
+	for _, resp := range be.pending {
+		c <- resp
+	}
+	be.pending = nil
+	be.idlers[c] = true
+	return c
+}
+
+func (be *fakeBackend) unidle(c chan imap.WriterTo) {
+	be.lock.Lock()
+	delete(be.idlers, c)
+	be.lock.Unlock()
+}
+
+type fakeUser struct {
+	be *fakeBackend
+}
+
+func (u *fakeUser) Username() string {
+	return fakeUsername
+}
+
+func (u *fakeUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	u.be.lock.Lock()
+	defer u.be.lock.Unlock()
+	var mailboxes []backend.Mailbox
+	for _, mbox := range u.be.mailboxes {
+		if !subscribed || mbox.subscribed {
+			mailboxes = append(mailboxes, mbox)
+		}
+	}
+	return mailboxes, nil
+}
+
+func (u *fakeUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.be.lock.Lock()
+	defer u.be.lock.Unlock()
+	if mbox, ok := u.be.mailboxes[name]; ok {
+		return mbox, nil
+	}
+	return nil, backend.ErrNoSuchMailbox
+}
+
+func (u *fakeUser) CreateMailbox(name string) error {
+	u.be.lock.Lock()
+	defer u.be.lock.Unlock()
+	if _, ok := u.be.mailboxes[name]; ok {
+		return backend.ErrMailboxAlreadyExists
+	}
+	u.be.mailboxes[name] = &fakeMailbox{be: u.be, name: name, uidNext: 1}
+	return nil
+}
+
+func (u *fakeUser) DeleteMailbox(name string) error {
+	return errors.New("Not supported")
+}
+
+func (u *fakeUser) RenameMailbox(existingName, newName string) error {
+	return errors.New("Not supported")
+}
+
+func (u *fakeUser) Logout() error {
+	return nil
+}
+
+type fakeMailbox struct {
+	be         *fakeBackend
+	name       string
+	attrs      []string
+	subscribed bool
+	uidNext    uint32
+	messages   []*fakeMessage
+}
+
+func (mbox *fakeMailbox) Name() string {
+	return mbox.name
+}
+
+func (mbox *fakeMailbox) Info() (*imap.MailboxInfo, error) {
+	return &imap.MailboxInfo{Attributes: mbox.attrs, Delimiter: "/", Name: mbox.name}, nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// status must be called with the backend lock held.
+func (mbox *fakeMailbox) status(items []imap.StatusItem) *imap.MailboxStatus {
+	status := imap.NewMailboxStatus(mbox.name, items)
+	status.Flags = []string{imap.SeenFlag, imap.DeletedFlag, imap.FlaggedFlag}
+	status.PermanentFlags = []string{"\\*"}
+	for _, name := range items {
+		switch name {
+		case imap.StatusMessages:
+			status.Messages = uint32(len(mbox.messages))
+		case imap.StatusUidNext:
+			status.UidNext = mbox.uidNext
+		case imap.StatusUidValidity:
+			status.UidValidity = 1
+		case imap.StatusRecent:
+			status.Recent = 0
+		case imap.StatusUnseen:
+			for _, msg := range mbox.messages {
+				if !hasFlag(msg.flags, imap.SeenFlag) {
+					status.Unseen++
+				}
+			}
+		}
+	}
+	return status
+}
+
+func (mbox *fakeMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	mbox.be.lock.Lock()
+	defer mbox.be.lock.Unlock()
+	return mbox.status(items), nil
+}
+
+func (mbox *fakeMailbox) SetSubscribed(subscribed bool) error {
+	mbox.be.lock.Lock()
+	mbox.subscribed = subscribed
+	mbox.be.lock.Unlock()
+	return nil
+}
+
+func (mbox *fakeMailbox) Check() error {
+	return nil
+}
+
+func (mbox *fakeMailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+
+	mbox.be.lock.Lock()
+	var fetched []*imap.Message
+	for i, msg := range mbox.messages {
+		seqNum := uint32(i + 1)
+		id := seqNum
+		if uid {
+			id = msg.uid
+		}
+		if !seqSet.Contains(id) {
+			continue
+		}
+		m, err := msg.fetch(seqNum, items)
+		if err != nil {
+			continue
+		}
+		fetched = append(fetched, m)
+	}
+	mbox.be.lock.Unlock()
+
+	for _, m := range fetched {
+		ch <- m
+	}
+	return nil
+}
+
+func (mbox *fakeMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	mbox.be.lock.Lock()
+	defer mbox.be.lock.Unlock()
+	mbox.be.searches++
+	var ids []uint32
+	for i, msg := range mbox.messages {
+		seqNum := uint32(i + 1)
+		e, err := message.Read(bytes.NewReader(msg.body))
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := backendutil.Match(e, seqNum, msg.uid, msg.date, msg.flags, criteria); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if uid {
+			ids = append(ids, msg.uid)
+		} else {
+			ids = append(ids, seqNum)
+		}
+	}
+	return ids, nil
+}
+
+func (mbox *fakeMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	mbox.be.lock.Lock()
+	defer mbox.be.lock.Unlock()
+	mbox.add(flags, b)
+	return nil
+}
+
+// add must be called with the backend lock held.
+func (mbox *fakeMailbox) add(flags []string, body []byte) {
+	mbox.messages = append(mbox.messages, &fakeMessage{
+		uid:   mbox.uidNext,
+		date:  time.Now(),
+		flags: flags,
+		body:  body,
+	})
+	mbox.uidNext++
+}
+
+func (mbox *fakeMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	mbox.be.lock.Lock()
+	defer mbox.be.lock.Unlock()
+	for i, msg := range mbox.messages {
+		id := uint32(i + 1)
+		if uid {
+			id = msg.uid
+		}
+		if seqset.Contains(id) {
+			msg.flags = backendutil.UpdateFlags(msg.flags, op, flags)
+		}
+	}
+	return nil
+}
+
+func (mbox *fakeMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, destName string) error {
+	return errors.New("Not supported")
+}
+
+func (mbox *fakeMailbox) Expunge() error {
+	return errors.New("Not supported")
+}
+
+// idleExtension adds the IDLE command (RFC 2177) to the server.
+type idleExtension struct {
+	be *fakeBackend
+}
+
+func (ext *idleExtension) Capabilities(c server.Conn) []string {
+	return []string{"IDLE"}
+}
+
+func (ext *idleExtension) Command(name string) server.HandlerFactory {
+	if name != "IDLE" {
+		return nil
+	}
+	return func() server.Handler {
+		return &idleHandler{be: ext.be}
+	}
+}
+
+type idleHandler struct {
+	be *fakeBackend
+}
+
+func (h *idleHandler) Parse(fields []interface{}) error {
+	return nil
+}
+
+func (h *idleHandler) Handle(conn server.Conn) error {
+	if err := conn.WriteResp(&imap.ContinuationReq{Info: "idling"}); err != nil {
+		return err
+	}
+	updates := h.be.idle()
+	defer h.be.unidle(updates)
+
+	// Write updates while waiting for DONE
+	donec := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				donec <- err
+			} else {
+				donec <- errors.New("Connection closed while idling")
+			}
+		} else if strings.ToUpper(scanner.Text()) != "DONE" {
+			donec <- errors.New("Expected DONE")
+		} else {
+			donec <- nil
+		}
+	}()
+	for {
+		select {
+		case resp := <-updates:
+			if err := conn.WriteResp(resp); err != nil {
+				return err
+			}
+		case err := <-donec:
+			return err
+		}
+	}
+}
+
+// refuseExtension answers the commands refused by the backend with a NO
+// without a response code.
+type refuseExtension struct {
+	be *fakeBackend
+}
+
+func (ext *refuseExtension) Capabilities(c server.Conn) []string {
+	return nil
+}
+
+func (ext *refuseExtension) Command(name string) server.HandlerFactory {
+	ext.be.lock.Lock()
+	defer ext.be.lock.Unlock()
+	if !ext.be.refused[name] {
+		return nil
+	}
+	return func() server.Handler {
+		return refuseHandler{}
+	}
+}
+
+type refuseHandler struct{}
+
+func (refuseHandler) Parse(fields []interface{}) error {
+	return nil
+}
+
+func (refuseHandler) Handle(conn server.Conn) error {
+	return errors.New("refused")
+}
+
+// trackingListener remembers accepted connections so they can be dropped.
+type trackingListener struct {
+	net.Listener
+
+	lock          sync.Mutex
+	conns         []net.Conn
+	plainGreeting bool // greet without the capabilities
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.lock.Lock()
+		l.conns = append(l.conns, c)
+		if l.plainGreeting {
+			c = &plainGreetingConn{Conn: c}
+		}
+		l.lock.Unlock()
+	}
+	return c, err
+}
+
+// plainGreetingConn drops the capabilities from the server greeting so the
+// client has to ask for them.
+type plainGreetingConn struct {
+	net.Conn
+	greeted bool
+}
+
+func (c *plainGreetingConn) Write(b []byte) (int, error) {
+	if c.greeted {
+		return c.Conn.Write(b)
+	}
+	c.greeted = true
+	i := bytes.Index(b, []byte("\r\n"))
+	if i < 0 {
+		return 0, errors.New("partial greeting")
+	}
+	if _, err := c.Conn.Write([]byte("* OK IMAP4rev1 Service Ready\r\n")); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b[i+2:])
+	return i + 2 + n, err
+}
+
+// fakeServer is an in-process IMAPS server for testing Account.
+type fakeServer struct {
+	t        *testing.T
+	be       *fakeBackend
+	srv      *server.Server
+	l        *trackingListener
+	certFile string
+}
+
+// newTestCert writes a self-signed certificate for 127.0.0.1 to dir,
+// returning the certificate and its file name.
+func newTestCert(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "imapidle test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost", testHost},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certFile
+}
+
+// newFakeServer starts a fake server, with IDLE support if idle is true. It
+// is shut down when the test completes.
+func newFakeServer(t *testing.T, idle bool) *fakeServer {
+	cert, certFile := newTestCert(t, t.TempDir())
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &fakeServer{
+		t:        t,
+		be:       newFakeBackend(),
+		l:        &trackingListener{Listener: l},
+		certFile: certFile,
+	}
+	fs.srv = server.New(fs.be)
+	fs.srv.ErrorLog = log.New(ioutil.Discard, "", 0)
+	fs.srv.Enable(&refuseExtension{be: fs.be})
+	if idle {
+		fs.srv.Enable(&idleExtension{be: fs.be})
+	}
+	go fs.srv.Serve(fs.l)
+	t.Cleanup(func() {
+		fs.srv.Close()
+		fs.Drop()
+	})
+	return fs
+}
+
+// account returns an Account configured to connect to the server.
+func (fs *fakeServer) account() *Account {
+	addr := fs.l.Addr().(*net.TCPAddr)
+	return &Account{
+		AccountConfig: mbsyncrc.AccountConfig{
+			Name:       "test",
+			Host:       "127.0.0.1",
+			Port:       addr.Port,
+			SSLVersion: "TLSv1.2",
+			User:       fakeUsername,
+			CertFile:   fs.certFile,
+			Password:   fakePassword,
+		},
+		Channels:   []*mbsyncrc.Channel{{Name: "test-channel", Far: ":test:"}},
+		UpdateName: "test-channel:INBOX",
+		PollInt:    100 * time.Millisecond,
+		Store:      NewStoreConfig("test"),
+	}
+}
+
+// RefuseLogins has logins refused with code, or as usual if code is empty.
+func (fs *fakeServer) RefuseLogins(code imap.StatusRespCode) {
+	fs.be.lock.Lock()
+	fs.be.loginCode = code
+	fs.be.lock.Unlock()
+}
+
+// RefuseCommand has cmd answered with a NO without a response code.
+func (fs *fakeServer) RefuseCommand(cmd string) {
+	fs.be.lock.Lock()
+	fs.be.refused[cmd] = true
+	fs.be.lock.Unlock()
+}
+
+// PlainGreeting has the server greet new connections without the
+// capabilities.
+func (fs *fakeServer) PlainGreeting() {
+	fs.l.lock.Lock()
+	fs.l.plainGreeting = true
+	fs.l.lock.Unlock()
+}
+
+// Searches returns the number of SEARCH commands received.
+func (fs *fakeServer) Searches() int {
+	fs.be.lock.Lock()
+	defer fs.be.lock.Unlock()
+	return fs.be.searches
+}
+
+// Logins returns the number of LOGIN commands received.
+func (fs *fakeServer) Logins() int {
+	fs.be.lock.Lock()
+	defer fs.be.lock.Unlock()
+	return fs.be.logins
+}
+
+func (fs *fakeServer) inbox() *fakeMailbox {
+	return fs.be.mailboxes["INBOX"]
+}
+
+// Deliver adds a message to INBOX and notifies clients.
+func (fs *fakeServer) Deliver(body string) {
+	fs.be.lock.Lock()
+	mbox := fs.inbox()
+	mbox.add(nil, []byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	count := uint32(len(mbox.messages))
+	fs.be.lock.Unlock()
+
+	fs.be.push(&imap.DataResp{Fields: []interface{}{count, imap.RawString("EXISTS")}})
+}
+
+// AddMailbox creates a mailbox with attributes, e.g., a special use.
+func (fs *fakeServer) AddMailbox(name string, attrs ...string) {
+	fs.be.lock.Lock()
+	fs.be.mailboxes[name] = &fakeMailbox{be: fs.be, name: name, attrs: attrs, uidNext: 1}
+	fs.be.lock.Unlock()
+}
+
+// DeliverTo adds a message to a mailbox other than INBOX, clients only find
+// it by asking.
+func (fs *fakeServer) DeliverTo(name, body string) {
+	fs.be.lock.Lock()
+	fs.be.mailboxes[name].add(nil, []byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	fs.be.lock.Unlock()
+}
+
+// Expunge removes message seqNum from INBOX and notifies clients.
+func (fs *fakeServer) Expunge(seqNum uint32) {
+	fs.be.lock.Lock()
+	mbox := fs.inbox()
+	mbox.messages = append(mbox.messages[:seqNum-1], mbox.messages[seqNum:]...)
+	fs.be.lock.Unlock()
+
+	fs.be.push(&imap.DataResp{Fields: []interface{}{seqNum, imap.RawString("EXPUNGE")}})
+}
+
+// SetFlags sets the flags of INBOX message seqNum and notifies clients.
+func (fs *fakeServer) SetFlags(seqNum uint32, flags []string) {
+	fs.be.lock.Lock()
+	fs.inbox().messages[seqNum-1].flags = flags
+	fs.be.lock.Unlock()
+
+	fields := make([]interface{}, len(flags))
+	for i, f := range flags {
+		fields[i] = imap.RawString(f)
+	}
+	fs.be.push(&imap.DataResp{Fields: []interface{}{
+		seqNum, imap.RawString("FETCH"), []interface{}{imap.RawString("FLAGS"), fields},
+	}})
+}
+
+// Status sends an untagged status response (e.g., an ALERT) to clients.
+func (fs *fakeServer) Status(typ imap.StatusRespType, code imap.StatusRespCode, info string) {
+	fs.be.push(&imap.StatusResp{
+		Type: typ,
+		Code: code,
+		Info: info,
+	})
+}
+
+// Bye sends BYE with code to the idling clients and closes the connections.
+func (fs *fakeServer) Bye(code imap.StatusRespCode, info string) {
+	fs.waitIdle()
+	fs.Status(imap.StatusRespBye, code, info)
+	// Give the BYE a chance to be written
+	time.Sleep(50 * time.Millisecond)
+	fs.Drop()
+}
+
+// waitIdle waits for a client to be running IDLE.
+func (fs *fakeServer) waitIdle() {
+	waitFor(fs.t, 5*time.Second, "client IDLE", func() bool {
+		fs.be.lock.Lock()
+		defer fs.be.lock.Unlock()
+		return len(fs.be.idlers) != 0
+	})
+}
+
+// Accepted returns the number of connections accepted since the last Drop.
+func (fs *fakeServer) Accepted() int {
+	fs.l.lock.Lock()
+	defer fs.l.lock.Unlock()
+	return len(fs.l.conns)
+}
+
+// Drop closes all client connections without warning.
+func (fs *fakeServer) Drop() {
+	fs.l.lock.Lock()
+	defer fs.l.lock.Unlock()
+	for _, c := range fs.l.conns {
+		c.Close()
+	}
+	fs.l.conns = nil
+}
+
+// waitEvent waits for an event of type code from eventc, skipping others.
+func waitEvent(t *testing.T, eventc <-chan Event, code EventCode, timeout time.Duration) Event {
+	t.Helper()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case e := <-eventc:
+			if e.E == code {
+				return e
+			}
+		case <-timer.C:
+			t.Fatalf("Timeout waiting for event %d", code)
+		}
+	}
+}
+
+// noEvent checks that no event of type code arrives within d.
+func noEvent(t *testing.T, eventc <-chan Event, code EventCode, d time.Duration) {
+	t.Helper()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case e := <-eventc:
+			if e.E == code {
+				t.Fatalf("Unexpected event %d", code)
+			}
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// waitFor polls cond until it's true or timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitPending waits for n timers to be active on fc, i.e., for the account
+// to be waiting on the clock.
+func waitPending(t *testing.T, fc *clock.Fake, n int) {
+	t.Helper()
+	waitFor(t, 5*time.Second, fmt.Sprintf("%d pending timers", n), func() bool {
+		return fc.Pending() == n
+	})
+}
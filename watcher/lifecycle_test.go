@@ -0,0 +1,173 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStallServer starts a server that writes greeting, answers the commands
+// in answers and never answers anything else. It returns an account for the
+// server and a channel receiving each accepted connection.
+func newStallServer(t *testing.T, greeting string, answers ...string) (*Account, <-chan net.Conn) {
+	cert, certFile := newTestCert(t, t.TempDir())
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl := &trackingListener{Listener: l}
+	t.Cleanup(func() {
+		l.Close()
+		(&fakeServer{l: tl}).Drop()
+	})
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			if greeting == "" {
+				// Never even complete the TLS handshake
+				continue
+			}
+			go func() {
+				conn.Write([]byte(greeting))
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					fields := strings.Fields(scanner.Text())
+					if len(fields) < 2 {
+						continue
+					}
+					for _, cmd := range answers {
+						if strings.EqualFold(fields[1], cmd) {
+							if cmd == "CAPABILITY" {
+								conn.Write([]byte("* CAPABILITY IMAP4rev1 IDLE\r\n"))
+							}
+							fmt.Fprintf(conn, "%s OK %s done\r\n", fields[0], cmd)
+						}
+					}
+				}
+			}()
+		}
+	}()
+
+	fs := &fakeServer{l: tl, certFile: certFile}
+	return fs.account(), accepted
+}
+
+// checkStops runs the account until ready returns then checks cancelling its
+// context takes it offline promptly.
+func checkStops(t *testing.T, a *Account, ready func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	eventc := make(chan Event, 10)
+	done := make(chan struct{})
+	go func() {
+		a.Online(ctx, eventc)
+		close(done)
+	}()
+	ready()
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Account still online after cancel")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Took %v to go offline", d)
+	}
+	if s := a.Stats(); s.Connected || s.Idling {
+		t.Errorf("Still connected after going offline: %+v", s)
+	}
+}
+
+func TestStopDuringHandshake(t *testing.T) {
+	a, accepted := newStallServer(t, "")
+	checkStops(t, a, func() {
+		<-accepted
+	})
+}
+
+func TestStopDuringLogin(t *testing.T) {
+	a, accepted := newStallServer(t, "* OK [CAPABILITY IMAP4rev1 IDLE] ready\r\n")
+	checkStops(t, a, func() {
+		<-accepted
+		time.Sleep(100 * time.Millisecond)
+	})
+}
+
+func TestStopDuringSelect(t *testing.T) {
+	a, accepted := newStallServer(t, "* OK [CAPABILITY IMAP4rev1 IDLE] ready\r\n", "LOGIN", "CAPABILITY")
+	checkStops(t, a, func() {
+		<-accepted
+		waitFor(t, 5*time.Second, "login", func() bool {
+			return a.Stats().Connected
+		})
+	})
+}
+
+func TestStopDuringPollPause(t *testing.T) {
+	fs := newFakeServer(t, false)
+	a := fs.account()
+	a.PollInt = time.Hour
+	checkStops(t, a, func() {
+		waitFor(t, 5*time.Second, "login", func() bool {
+			return a.Stats().Connected
+		})
+	})
+}
+
+func TestStopDuringReconnectPause(t *testing.T) {
+	a, _ := newStallServer(t, "")
+	a.Password = "" // PassCmd fails
+	a.PassCmd = "exit 1"
+	a.PollInt = time.Hour
+	checkStops(t, a, func() {
+		waitFor(t, 5*time.Second, "login failure", func() bool {
+			return a.Stats().LoginFailures != 0
+		})
+	})
+}
+
+func TestStopWhileIdling(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	checkStops(t, a, func() {
+		waitFor(t, 5*time.Second, "IDLE", func() bool {
+			return a.Stats().Idling
+		})
+	})
+
+	// Online again on the same account
+	eventc := startOnline(t, a)
+	fs.Deliver(testMessage)
+	waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+}
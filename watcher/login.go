@@ -0,0 +1,223 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// LoginFailure is the kind of a login failure.
+type LoginFailure int
+
+const (
+	NetworkFailure     LoginFailure = iota // network, TLS, protocol or other error, retried
+	UnavailableFailure                     // the server is temporarily unavailable, retried
+	CredentialFailure                      // bad or expired credentials
+	AdminFailure                           // the account needs administrator attention
+)
+
+var loginFailureNames = []string{
+	"network",
+	"unavailable",
+	"credentials",
+	"contact-admin",
+}
+
+func (f LoginFailure) String() string {
+	if f >= 0 && int(f) < len(loginFailureNames) {
+		return loginFailureNames[f]
+	}
+	return fmt.Sprintf("LoginFailure(%d)", int(f))
+}
+
+// Retried returns true if logins are retried after this kind of failure,
+// otherwise the account waits for Retry.
+func (f LoginFailure) Retried() bool {
+	return f == NetworkFailure || f == UnavailableFailure
+}
+
+// LoginError is a failed login classified by the server's response code
+// (RFC 5530).
+type LoginError struct {
+	Failure LoginFailure
+	Code    string // response code of the NO, empty if none
+	Err     error
+}
+
+func (e *LoginError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%v: [%s] %v", e.Failure, e.Code, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Failure, e.Err)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// authCmdError is an error answering the command logging in, LOGIN or
+// AUTHENTICATE, rather than one before it or of the client.
+type authCmdError struct {
+	Cmd string
+	Err error
+}
+
+func (e *authCmdError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *authCmdError) Unwrap() error {
+	return e.Err
+}
+
+// classifyLogin classifies err from logging in. cmd is the command err
+// answers, LOGIN or AUTHENTICATE, empty for others, code is the response code
+// of the last tagged status and refused is true if the server answered rather
+// than the connection failing.
+func classifyLogin(err error, cmd, code string, refused bool) *LoginError {
+	e := &LoginError{Failure: NetworkFailure, Code: code, Err: err}
+	if !refused {
+		return e
+	}
+	switch code {
+	case "UNAVAILABLE", "INUSE":
+		e.Failure = UnavailableFailure
+	case "CONTACTADMIN":
+		e.Failure = AdminFailure
+	case "AUTHENTICATIONFAILED", "AUTHORIZATIONFAILED", "EXPIRED":
+		e.Failure = CredentialFailure
+	case "":
+		// The many servers that just say NO to a bad password
+		if cmd == "LOGIN" {
+			e.Failure = CredentialFailure
+		}
+	}
+	return e
+}
+
+// A status response: tag, type, response code and text.
+var statusRe = regexp.MustCompile(`^([^+ ]+) (OK|NO|BAD|BYE|PREAUTH) (?:\[([A-Za-z0-9-]+)[^\]]*\] ?)?(.*?)\r?$`)
+
+// The longest line prefix kept looking for a response code.
+const respCodeLineMax = 256
+
+// respCodes watches the status responses from the server. The client doesn't
+// return the response code of failed commands, or pass on [ALERT]s in tagged
+// responses or greetings. It's written by the client's reader.
+type respCodes struct {
+	lock    sync.Mutex
+	line    []byte
+	skip    bool   // rest of a long line
+	code    string // code of the last tagged status
+	alerts  []string
+	bye     string // text of a BYE
+	byeCode string
+	notify  func() // called after an alert or BYE, if set
+}
+
+func (r *respCodes) status(m [][]byte) bool {
+	tag, typ, code, text := string(m[1]), string(m[2]), string(m[3]), string(m[4])
+	if tag != "*" {
+		r.code = code
+	}
+	if code == "ALERT" {
+		r.alerts = append(r.alerts, text)
+		return true
+	}
+	if typ == "BYE" {
+		r.bye, r.byeCode = text, code
+		if r.bye == "" {
+			r.bye = "BYE"
+		}
+		return true
+	}
+	return false
+}
+
+func (r *respCodes) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	notify := false
+	for _, b := range p {
+		if b == '\n' {
+			if m := statusRe.FindSubmatch(r.line); m != nil && r.status(m) {
+				notify = true
+			}
+			r.line = r.line[:0]
+			r.skip = false
+		} else if !r.skip {
+			if len(r.line) == respCodeLineMax {
+				r.skip = true
+			} else {
+				r.line = append(r.line, b)
+			}
+		}
+	}
+	f := r.notify
+	r.lock.Unlock()
+	if notify && f != nil {
+		f()
+	}
+	return len(p), nil
+}
+
+// take returns and clears the last tagged response code.
+func (r *respCodes) take() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	code := r.code
+	r.code = ""
+	return code
+}
+
+// takeAlerts returns and clears the [ALERT] texts.
+func (r *respCodes) takeAlerts() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	alerts := r.alerts
+	r.alerts = nil
+	return alerts
+}
+
+// takeBye returns and clears the text and response code of a BYE, the text
+// is empty if there wasn't one.
+func (r *respCodes) takeBye() (string, string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	bye, code := r.bye, r.byeCode
+	r.bye, r.byeCode = "", ""
+	return bye, code
+}
+
+// teeConn copies what is read from the connection to w.
+type teeConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newTeeConn(conn net.Conn, w io.Writer) *teeConn {
+	return &teeConn{Conn: conn, r: io.TeeReader(conn, w)}
+}
+
+func (c *teeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,173 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestRespCodes(t *testing.T) {
+	var r respCodes
+	for _, s := range []string{
+		"* OK [CAPABILITY IMAP4rev1] ready\r\n",
+		"* NO [ALERT] untagged\r\na1 NO [AUTHENTI",
+		"CATIONFAILED] Invalid credentials\r\n",
+	} {
+		r.Write([]byte(s))
+	}
+	if code := r.take(); code != "AUTHENTICATIONFAILED" {
+		t.Errorf("Got code %q", code)
+	}
+	if code := r.take(); code != "" {
+		t.Errorf("Code %q not cleared", code)
+	}
+
+	// Codes inside long lines (e.g., literals) are ignored
+	long := make([]byte, respCodeLineMax)
+	for i := range long {
+		long[i] = 'x'
+	}
+	r.Write(long)
+	r.Write([]byte(" a2 NO [EXPIRED] x\r\na3 NO no code\r\n"))
+	if code := r.take(); code != "" {
+		t.Errorf("Got code %q", code)
+	}
+}
+
+func TestClassifyLogin(t *testing.T) {
+	err := errors.New("failed")
+	for _, c := range []struct {
+		cmd     string
+		code    string
+		refused bool
+		want    LoginFailure
+	}{
+		{"LOGIN", "", false, NetworkFailure},
+		{"LOGIN", "AUTHENTICATIONFAILED", false, NetworkFailure},
+		{"LOGIN", "", true, CredentialFailure},
+		{"AUTHENTICATE", "", true, NetworkFailure},
+		{"", "", true, NetworkFailure},
+		{"", "AUTHENTICATIONFAILED", true, CredentialFailure},
+		{"AUTHENTICATE", "AUTHENTICATIONFAILED", true, CredentialFailure},
+		{"LOGIN", "AUTHORIZATIONFAILED", true, CredentialFailure},
+		{"LOGIN", "EXPIRED", true, CredentialFailure},
+		{"LOGIN", "UNAVAILABLE", true, UnavailableFailure},
+		{"LOGIN", "CONTACTADMIN", true, AdminFailure},
+		{"LOGIN", "SERVERBUG", true, NetworkFailure},
+	} {
+		if got := classifyLogin(err, c.cmd, c.code, c.refused); got.Failure != c.want || got.Err != err {
+			t.Errorf("%s %q refused %v: got %v expected %v", c.cmd, c.code, c.refused, got.Failure, c.want)
+		}
+	}
+}
+
+func TestLoginCodes(t *testing.T) {
+	fs := newFakeServer(t, true)
+	for _, c := range []struct {
+		code  string
+		want  LoginFailure
+		state State
+	}{
+		{"AUTHENTICATIONFAILED", CredentialFailure, AuthFailed},
+		{"UNAVAILABLE", UnavailableFailure, Backoff},
+		{"CONTACTADMIN", AdminFailure, AuthFailed},
+	} {
+		fs.RefuseLogins(imap.StatusRespCode(c.code))
+		a := fs.account()
+		err := a.Login(context.Background())
+		var lerr *LoginError
+		if !errors.As(err, &lerr) {
+			t.Fatalf("%s: Login returned %v", c.code, err)
+		}
+		if lerr.Failure != c.want || lerr.Code != c.code {
+			t.Errorf("%s: got %v [%s]", c.code, lerr.Failure, lerr.Code)
+		}
+		if s, _ := a.State(); s != c.state {
+			t.Errorf("%s: state %v expected %v", c.code, s, c.state)
+		}
+	}
+}
+
+func TestLoginOtherNo(t *testing.T) {
+	// A plain NO to a command other than LOGIN isn't a bad password.
+	// XOAUTH2 asks for the capabilities before AUTHENTICATE.
+	fs := newFakeServer(t, true)
+	fs.PlainGreeting()
+	fs.RefuseCommand("CAPABILITY")
+	a := fs.account()
+	a.UseXOAuth2 = true
+	err := a.Login(context.Background())
+	if err == nil {
+		a.Logout()
+		t.Fatalf("Logged in without capabilities")
+	}
+	var lerr *LoginError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Login returned %v", err)
+	}
+	if lerr.Failure != NetworkFailure || lerr.Code != "" {
+		t.Errorf("Got %v [%s]", lerr.Failure, lerr.Code)
+	}
+	if s, _ := a.State(); s != Backoff {
+		t.Errorf("State %v expected %v", s, Backoff)
+	}
+}
+
+func TestLoginRefusedParks(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	a.Password = ""
+	a.PassCmd = "echo wrong"
+
+	eventc := make(chan Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Online(ctx, eventc)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	e := waitEvent(t, eventc, NoticeEvent, 5*time.Second)
+	if e.A != a || e.M == "" {
+		t.Errorf("Unexpected notice %+v", e)
+	}
+	// Refreshed once then parked
+	time.Sleep(5 * a.PollInt)
+	if n := fs.Logins(); n != 2 {
+		t.Errorf("%d logins expected 2", n)
+	}
+	if s := a.Stats(); s.State != AuthFailed || s.LoginFailures != 2 {
+		t.Errorf("Unexpected stats while parked: %+v", s)
+	}
+
+	a.PassCmd = "echo " + fakePassword
+	a.Retry()
+	waitFor(t, 5*time.Second, "login after retry", func() bool {
+		return a.Stats().Idling
+	})
+}
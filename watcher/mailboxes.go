@@ -0,0 +1,233 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// SpecialUses are the mailbox attributes of RFC 6154 and Gmail's \Important,
+// plus \Inbox for INBOX. They can be used in place of a mailbox name.
+var SpecialUses = []string{
+	`\Inbox`,
+	`\All`,
+	`\Archive`,
+	`\Drafts`,
+	`\Flagged`,
+	`\Important`,
+	`\Junk`,
+	`\Sent`,
+	`\Trash`,
+}
+
+// subscribedAttr is returned by LIST with RETURN (SUBSCRIBED), see RFC 5258.
+const subscribedAttr = `\Subscribed`
+
+// Mailbox is a mailbox of an account found by LIST.
+type Mailbox struct {
+	Name       string
+	Delimiter  string
+	Attributes []string
+	Subscribed bool
+}
+
+// SpecialUse returns the special use of the mailbox, empty if it has none.
+func (m *Mailbox) SpecialUse() string {
+	if strings.EqualFold(m.Name, "INBOX") {
+		return `\Inbox`
+	}
+	for _, su := range SpecialUses {
+		if hasAttr(m.Attributes, su) {
+			return su
+		}
+	}
+	return ""
+}
+
+// Selectable returns false if the mailbox only holds other mailboxes.
+func (m *Mailbox) Selectable() bool {
+	return !hasAttr(m.Attributes, imap.NoSelectAttr) && !hasAttr(m.Attributes, `\NonExistent`)
+}
+
+// IsSpecialUse returns true if target names a special use, e.g., \Sent,
+// rather than a mailbox.
+func IsSpecialUse(target string) bool {
+	return strings.HasPrefix(target, `\`)
+}
+
+// ResolveMailbox returns the name of the mailbox target refers to, target
+// itself unless it's a special use. It returns an error if no mailbox has the
+// special use.
+func ResolveMailbox(mailboxes []Mailbox, target string) (string, error) {
+	if !IsSpecialUse(target) {
+		return target, nil
+	}
+	if strings.EqualFold(target, `\Inbox`) {
+		return "INBOX", nil
+	}
+	for _, m := range mailboxes {
+		if hasAttr(m.Attributes, target) && m.Selectable() {
+			return m.Name, nil
+		}
+	}
+	return "", fmt.Errorf("No %s mailbox", target)
+}
+
+// listCommand is LIST with return options, see RFC 5258.
+type listCommand struct {
+	Pattern string
+	Return  []string
+}
+
+func (cmd *listCommand) Command() *imap.Command {
+	pattern := EncodeMailbox(cmd.Pattern)
+	ret := make([]interface{}, len(cmd.Return))
+	for i, r := range cmd.Return {
+		ret[i] = imap.RawString(r)
+	}
+	return &imap.Command{
+		Name:      "LIST",
+		Arguments: []interface{}{"", pattern, imap.RawString("RETURN"), ret},
+	}
+}
+
+// listMailboxes returns all the mailboxes of c sorted by name. Servers
+// supporting LIST-EXTENDED are asked for the special use and subscription of
+// each in one go, otherwise LSUB finds the subscribed ones.
+func listMailboxes(c *client.Client) ([]Mailbox, error) {
+	extended, err := c.Support("LIST-EXTENDED")
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	if extended {
+		ret = append(ret, "SUBSCRIBED")
+		if ok, _ := c.Support("SPECIAL-USE"); ok {
+			ret = append(ret, "SPECIAL-USE")
+		}
+	}
+
+	infos := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		if extended {
+			status, err := c.Execute(&listCommand{Pattern: "*", Return: ret}, &responses.List{Mailboxes: infos})
+			close(infos)
+			if err == nil {
+				err = status.Err()
+			}
+			done <- err
+		} else {
+			done <- c.List("", "*", infos)
+		}
+	}()
+	var mailboxes []Mailbox
+	for info := range infos {
+		mailboxes = append(mailboxes, Mailbox{
+			Name:       info.Name,
+			Delimiter:  info.Delimiter,
+			Attributes: info.Attributes,
+			Subscribed: hasAttr(info.Attributes, subscribedAttr),
+		})
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	if !extended {
+		subscribed := make(map[string]bool)
+		infos := make(chan *imap.MailboxInfo, 10)
+		go func() {
+			done <- c.Lsub("", "*", infos)
+		}()
+		for info := range infos {
+			subscribed[info.Name] = true
+		}
+		if err := <-done; err != nil {
+			return nil, err
+		}
+		for i := range mailboxes {
+			mailboxes[i].Subscribed = subscribed[mailboxes[i].Name]
+		}
+	}
+	sort.Slice(mailboxes, func(i, j int) bool {
+		return mailboxes[i].Name < mailboxes[j].Name
+	})
+	return mailboxes, nil
+}
+
+// ListMailboxes lists the mailboxes of the logged in account, they are kept
+// for Mailboxes.
+func (a *Account) ListMailboxes() ([]Mailbox, error) {
+	if a.c == nil {
+		return nil, errors.New("Not logged in")
+	}
+	mailboxes, err := listMailboxes(a.c)
+	if err != nil {
+		return nil, err
+	}
+	a.mboxLock.Lock()
+	a.mailboxes = mailboxes
+	a.mboxLock.Unlock()
+	return mailboxes, nil
+}
+
+// Mailboxes returns the mailboxes found when the account last logged in, nil
+// if it hasn't. It is safe to call from any goroutine.
+func (a *Account) Mailboxes() []Mailbox {
+	a = a.owner()
+	a.mboxLock.Lock()
+	defer a.mboxLock.Unlock()
+	return append([]Mailbox(nil), a.mailboxes...)
+}
+
+// Resolve returns the name of the mailbox target refers to, see
+// ResolveMailbox. It is safe to call from any goroutine.
+func (a *Account) Resolve(target string) (string, error) {
+	return ResolveMailbox(a.Mailboxes(), target)
+}
+
+// ChannelArg returns the channel:mailbox update script argument of a mailbox,
+// using the channel of the account's UpdateName if channel is empty. The
+// mailbox is given as mbsync names it, see MbsyncName.
+func (a *Account) ChannelArg(channel, mailbox string) string {
+	if channel == "" {
+		channel = a.updateChannel()
+	}
+	return fmt.Sprintf("%s:%s", channel, a.MbsyncName(mailbox))
+}
+
+// discover lists the mailboxes after logging in, a failure is logged and the
+// mailboxes found before are kept.
+func (a *Account) discover() {
+	mailboxes, err := a.ListMailboxes()
+	if err != nil {
+		a.log.Warnf("listing mailboxes: %v", err)
+		return
+	}
+	a.log.Debugf("found %d mailboxes", len(mailboxes))
+}
@@ -0,0 +1,122 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// newGmailServer returns a fake server with Gmail's mailboxes.
+func newGmailServer(t *testing.T) *fakeServer {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("[Gmail]", imap.NoSelectAttr)
+	fs.AddMailbox("[Gmail]/Important", `\Important`)
+	fs.AddMailbox("[Gmail]/Starred", `\Flagged`)
+	fs.AddMailbox("[Gmail]/Sent Mail", `\Sent`)
+	fs.AddMailbox("Lists")
+	fs.be.mailboxes["Lists"].subscribed = true
+	fs.be.mailboxes["INBOX"].subscribed = true
+	return fs
+}
+
+func TestListMailboxes(t *testing.T) {
+	fs := newGmailServer(t)
+	a := fs.account()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	mailboxes, err := a.ListMailboxes()
+	if err != nil {
+		t.Fatalf("ListMailboxes: %v", err)
+	}
+	var names, uses []string
+	for _, m := range mailboxes {
+		names = append(names, m.Name)
+		uses = append(uses, m.SpecialUse())
+	}
+	wantNames := []string{"INBOX", "Lists", "[Gmail]", "[Gmail]/Important", "[Gmail]/Sent Mail", "[Gmail]/Starred"}
+	wantUses := []string{`\Inbox`, "", "", `\Important`, `\Sent`, `\Flagged`}
+	if !reflect.DeepEqual(names, wantNames) || !reflect.DeepEqual(uses, wantUses) {
+		t.Errorf("Mailboxes %q uses %q expected %q %q", names, uses, wantNames, wantUses)
+	}
+	if !mailboxes[1].Subscribed || mailboxes[3].Subscribed || mailboxes[2].Selectable() {
+		t.Errorf("Unexpected mailboxes %+v", mailboxes)
+	}
+	if len(a.Mailboxes()) != len(mailboxes) {
+		t.Errorf("Mailboxes not kept")
+	}
+
+	for target, want := range map[string]string{
+		`\Inbox`:     "INBOX",
+		`\Important`: "[Gmail]/Important",
+		`\Flagged`:   "[Gmail]/Starred",
+		"Lists":      "Lists",
+	} {
+		if got, err := a.Resolve(target); err != nil || got != want {
+			t.Errorf("Resolve(%s) %q %v expected %q", target, got, err, want)
+		}
+	}
+	if got, err := a.Resolve(`\Junk`); err == nil {
+		t.Errorf("Resolve(\\Junk) %q expected an error", got)
+	}
+	if got := a.ChannelArg("", "[Gmail]/Important"); got != "test-channel:[Gmail]/Important" {
+		t.Errorf("ChannelArg %q", got)
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	var b bytes.Buffer
+	cmd := (&listCommand{Pattern: "*", Return: []string{"SUBSCRIBED", "SPECIAL-USE"}}).Command()
+	cmd.Tag = "A1"
+	if err := cmd.WriteTo(imap.NewWriter(&b)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "A1 LIST \"\" \"*\" RETURN (SUBSCRIBED SPECIAL-USE)\r\n"; b.String() != want {
+		t.Errorf("Command %q expected %q", b.String(), want)
+	}
+}
+
+func TestPollSpecialUse(t *testing.T) {
+	fs := newGmailServer(t)
+	a := fs.account()
+	a.Store.Polls = []PollTarget{{Pattern: `\Important`}}
+	a.Store.PollInterval = 50 * time.Millisecond
+	eventc := startOnline(t, a)
+	if len(a.Mailboxes()) == 0 {
+		t.Errorf("Mailboxes not listed logging in")
+	}
+	waitFor(t, 5*time.Second, "mailbox to be counted", func() bool {
+		_, ok := a.Stats().Counts["[Gmail]/Important"]
+		return ok
+	})
+
+	fs.DeliverTo("[Gmail]/Important", testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "[Gmail]/Important" || e.UpdateName() != "test-channel:[Gmail]/Important" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
@@ -0,0 +1,102 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Mailbox names are UTF-8 everywhere but on the wire, where they are modified
+// UTF-7 (RFC 3501 5.1.3). go-imap converts names in the commands it sends and
+// the responses it parses, names given by the user are made UTF-8 with
+// NormalizeMailbox.
+
+// NormalizeMailbox returns the UTF-8 name of a mailbox given in the config or
+// on the command line. Names may be given in UTF-8 or, as copied from a server
+// or mbsync's output, modified UTF-7: an ASCII name with an & that decodes as
+// modified UTF-7 is decoded, others are kept, e.g., "R&D". An error is
+// returned if the name isn't valid UTF-8.
+func NormalizeMailbox(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("Mailbox name %q is not UTF-8", name)
+	}
+	if !strings.Contains(name, "&") || !isASCII(name) {
+		return name, nil
+	}
+	decoded, err := utf7.Encoding.NewDecoder().String(name)
+	if err != nil {
+		return name, nil
+	}
+	return decoded, nil
+}
+
+// EncodeMailbox returns the modified UTF-7 name of a mailbox as sent to the
+// server.
+func EncodeMailbox(name string) string {
+	encoded, err := utf7.Encoding.NewEncoder().String(name)
+	if err != nil {
+		return name
+	}
+	return encoded
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// delimiter returns the hierarchy delimiter of a mailbox, PathDelimiter if
+// set, otherwise the one found listing mailboxes, empty if not known.
+func (a *Account) delimiter(mailbox string) string {
+	if a.PathDelimiter != "" {
+		return a.PathDelimiter
+	}
+	for _, m := range a.Mailboxes() {
+		if m.Name == mailbox {
+			return m.Delimiter
+		}
+	}
+	return ""
+}
+
+// MbsyncName returns a mailbox name the way mbsync names it: UTF-8 with the
+// IMAPStore Path prefix removed and / as the hierarchy delimiter. INBOX is
+// always INBOX.
+func (a *Account) MbsyncName(mailbox string) string {
+	if strings.EqualFold(mailbox, "INBOX") {
+		return "INBOX"
+	}
+	delim := a.delimiter(mailbox)
+	if a.Path != "" && strings.HasPrefix(mailbox, a.Path) && mailbox != a.Path {
+		mailbox = mailbox[len(a.Path):]
+	}
+	if delim != "" && delim != "/" {
+		mailbox = strings.ReplaceAll(mailbox, delim, "/")
+	}
+	return mailbox
+}
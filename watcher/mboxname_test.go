@@ -0,0 +1,146 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNormalizeMailbox(t *testing.T) {
+	for name, want := range map[string]string{
+		"INBOX":           "INBOX",
+		"Entwürfe":        "Entwürfe",
+		"Entw&APw-rfe":    "Entwürfe",
+		"&ZeVnLIqe-":      "日本語",
+		"A&-B":            "A&B",
+		"R&D":             "R&D",
+		"Tom & Jerry":     "Tom & Jerry",
+		"Lists/*":         "Lists/*",
+		"Brouillons & co": "Brouillons & co",
+	} {
+		if got, err := NormalizeMailbox(name); err != nil || got != want {
+			t.Errorf("NormalizeMailbox(%q) %q %v expected %q", name, got, err, want)
+		}
+	}
+	if got, err := NormalizeMailbox("Entw\xfcrfe"); err == nil {
+		t.Errorf("NormalizeMailbox of Latin-1 %q expected an error", got)
+	}
+}
+
+func TestMailboxRoundTrip(t *testing.T) {
+	for name, want := range map[string]string{
+		"INBOX":                  "INBOX",
+		"Entwürfe":               "Entw&APw-rfe",
+		"日本語":                    "&ZeVnLIqe-",
+		"~peter/mail/台北/日本語":     "~peter/mail/&U,BTFw-/&ZeVnLIqe-",
+		"R&D":                    "R&-D",
+		"Корзина":                "&BBoEPgRABDcEOAQ9BDA-",
+		"[Gmail]/Entwürfe & Co.": "[Gmail]/Entw&APw-rfe &- Co.",
+	} {
+		encoded := EncodeMailbox(name)
+		if encoded != want {
+			t.Errorf("EncodeMailbox(%q) %q expected %q", name, encoded, want)
+		}
+		if got, err := NormalizeMailbox(encoded); err != nil || got != name {
+			t.Errorf("NormalizeMailbox(%q) %q %v expected %q", encoded, got, err, name)
+		}
+		if got, err := NormalizeMailbox(name); err != nil || got != name {
+			t.Errorf("NormalizeMailbox(%q) %q %v expected it unchanged", name, got, err)
+		}
+	}
+}
+
+func TestMbsyncName(t *testing.T) {
+	a := &Account{UpdateName: "work:INBOX", Path: "INBOX.", PathDelimiter: "."}
+	for name, want := range map[string]string{
+		"INBOX":               "INBOX",
+		"inbox":               "INBOX",
+		"INBOX.Entwürfe":      "Entwürfe",
+		"INBOX.Lists.日本語":     "Lists/日本語",
+		"Archive.2026":        "Archive/2026",
+		"INBOX.Entwürfe.2026": "Entwürfe/2026",
+	} {
+		if got := a.MbsyncName(name); got != want {
+			t.Errorf("MbsyncName(%q) %q expected %q", name, got, want)
+		}
+	}
+	if got := a.ChannelArg("", "INBOX.Entwürfe"); got != "work:Entwürfe" {
+		t.Errorf("ChannelArg %q", got)
+	}
+
+	// Without PathDelimiter the delimiter LIST returned is used.
+	a = &Account{mailboxes: []Mailbox{{Name: "Lists.Café", Delimiter: "."}}}
+	if got := a.ChannelArg("lists", "Lists.Café"); got != "lists:Lists/Café" {
+		t.Errorf("ChannelArg %q", got)
+	}
+	if got := a.ChannelArg("lists", "Other.Café"); got != "lists:Other.Café" {
+		t.Errorf("ChannelArg of unknown mailbox %q", got)
+	}
+}
+
+func TestPollNonASCII(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("Entwürfe")
+	fs.AddMailbox("日本語")
+	a := fs.account()
+	// The mailbox given as copied from the server.
+	name, err := NormalizeMailbox("Entw&APw-rfe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Store.Polls = []PollTarget{{Pattern: name}, {Pattern: "日本*", Channel: "cjk"}}
+	a.Store.PollInterval = 50 * time.Millisecond
+	eventc := startOnline(t, a)
+	waitFor(t, 5*time.Second, "mailboxes to be counted", func() bool {
+		counts := a.Stats().Counts
+		_, ok1 := counts["Entwürfe"]
+		_, ok2 := counts["日本語"]
+		return ok1 && ok2
+	})
+
+	fs.DeliverTo("Entwürfe", testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "Entwürfe" || e.UpdateName() != "test-channel:Entwürfe" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	fs.DeliverTo("日本語", testMessage)
+	e = waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "日本語" || e.UpdateName() != "cjk:日本語" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
+
+func TestListNonASCII(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("Entwürfe", `\Drafts`)
+	a := fs.account()
+	if err := a.Login(context.Background()); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	defer a.Logout()
+	if _, err := a.ListMailboxes(); err != nil {
+		t.Fatalf("ListMailboxes: %v", err)
+	}
+	if got, err := a.Resolve(`\Drafts`); err != nil || got != "Entwürfe" {
+		t.Errorf("Resolve(\\Drafts) %q %v", got, err)
+	}
+}
@@ -0,0 +1,219 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// Class is the classification given to newly arrived mail.
+type Class int
+
+const (
+	IgnoreClass Class = iota // Wait for the next full update
+	NormalClass              // Coalesce with other updates for a while
+	UrgentClass              // Sync (and notify) right away
+)
+
+func (c Class) String() string {
+	switch c {
+	case IgnoreClass:
+		return "ignore"
+	case NormalClass:
+		return "normal"
+	case UrgentClass:
+		return "urgent"
+	}
+	return fmt.Sprintf("Class(%d)", int(c))
+}
+
+// ParseClass parses a class name.
+func ParseClass(s string) (Class, error) {
+	switch strings.ToLower(s) {
+	case "ignore":
+		return IgnoreClass, nil
+	case "normal":
+		return NormalClass, nil
+	case "urgent":
+		return UrgentClass, nil
+	}
+	return IgnoreClass, fmt.Errorf("Unknown rule class %s", s)
+}
+
+// The header fields rules can match on.
+var ruleFields = []string{"From", "To", "Cc", "List-Id", "Subject", "Size"}
+
+// Rule classifies a message if Field matches.
+type Rule struct {
+	Class   Class
+	Field   string
+	Pattern string
+
+	re     *regexp.Regexp
+	sizeOp byte // '<' or '>'
+	size   uint32
+}
+
+// NewRule returns a rule giving matching messages class.
+func NewRule(class, field, pattern string) (*Rule, error) {
+	var err error
+	r := &Rule{Pattern: pattern}
+	if r.Class, err = ParseClass(class); err != nil {
+		return nil, err
+	}
+	for _, f := range ruleFields {
+		if strings.EqualFold(f, field) {
+			r.Field = f
+		}
+	}
+	if r.Field == "" {
+		return nil, fmt.Errorf("Unknown rule field %s", field)
+	}
+	if r.Field == "Size" {
+		if len(pattern) < 2 || (pattern[0] != '<' && pattern[0] != '>') {
+			return nil, fmt.Errorf("Size rule must be <N or >N: %s", pattern)
+		}
+		r.sizeOp = pattern[0]
+		if r.size, err = parseSize(pattern[1:]); err != nil {
+			return nil, fmt.Errorf("Bad Size rule %s: %v", pattern, err)
+		}
+		return r, nil
+	}
+	if r.re, err = regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("Bad %s rule pattern: %v", r.Field, err)
+	}
+	return r, nil
+}
+
+// parseSize parses a size in bytes with an optional k or M suffix (and b)
+// for kibibytes or mebibytes as mbsync's MaxSize does.
+func parseSize(s string) (uint32, error) {
+	v := strings.TrimSuffix(strings.ToLower(s), "b")
+	mult := uint64(1)
+	if strings.HasSuffix(v, "k") {
+		mult, v = 1024, v[:len(v)-1]
+	} else if strings.HasSuffix(v, "m") {
+		mult, v = 1024*1024, v[:len(v)-1]
+	}
+	size, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if size*mult > math.MaxUint32 {
+		return 0, fmt.Errorf("%s is too large", s)
+	}
+	return uint32(size * mult), nil
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s %s %q", r.Class, r.Field, r.Pattern)
+}
+
+func formatAddresses(addrs []*imap.Address) []string {
+	s := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		a := addr.MailboxName + "@" + addr.HostName
+		if addr.PersonalName != "" {
+			a = fmt.Sprintf("%s <%s>", addr.PersonalName, a)
+		}
+		s = append(s, a)
+	}
+	return s
+}
+
+// ruleFieldValues returns the values of the fields rules match on.
+func ruleFieldValues(msg *imap.Message) map[string][]string {
+	values := map[string][]string{
+		"List-Id": {listID(msg)},
+	}
+	if env := msg.Envelope; env != nil {
+		values["From"] = formatAddresses(env.From)
+		values["To"] = formatAddresses(env.To)
+		values["Cc"] = formatAddresses(env.Cc)
+		values["Subject"] = []string{env.Subject}
+	}
+	return values
+}
+
+func (r *Rule) match(values map[string][]string, size uint32) bool {
+	if r.Field == "Size" {
+		if r.sizeOp == '<' {
+			return size < r.size
+		}
+		return size > r.size
+	}
+	for _, v := range values[r.Field] {
+		if r.re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleSet is the ordered list of rules for an account, the first match wins.
+type RuleSet struct {
+	Rules   []*Rule
+	Default Class // Class of messages not matching any rule
+}
+
+// Classify returns the class of msg and the rule that matched (if any).
+func (rs *RuleSet) Classify(msg *imap.Message) (Class, *Rule) {
+	values := ruleFieldValues(msg)
+	for _, r := range rs.Rules {
+		if r.match(values, msg.Size) {
+			return r.Class, r
+		}
+	}
+	return rs.Default, nil
+}
+
+var listIDSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{
+		Specifier: imap.HeaderSpecifier,
+		Fields:    []string{"LIST-ID"},
+	},
+	Peek: true,
+}
+
+// The items to FETCH for evaluating rules.
+var ruleFetchItems = []imap.FetchItem{
+	imap.FetchEnvelope,
+	imap.FetchRFC822Size,
+	listIDSection.FetchItem(),
+}
+
+func listID(msg *imap.Message) string {
+	lit := msg.GetBody(listIDSection)
+	if lit == nil {
+		return ""
+	}
+	hdr, err := textproto.NewReader(bufio.NewReader(lit)).ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		return ""
+	}
+	return hdr.Get("List-Id")
+}
@@ -0,0 +1,171 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestNewRule(t *testing.T) {
+	for _, c := range []struct {
+		class, field, pattern string
+		want                  Class
+		wantField             string
+		ok                    bool
+	}{
+		{"urgent", "From", `boss@example\.com`, UrgentClass, "From", true},
+		{"Normal", "list-id", ".+", NormalClass, "List-Id", true},
+		{"IGNORE", "subject", "^\\[spam\\]", IgnoreClass, "Subject", true},
+		{"ignore", "size", ">5000000", IgnoreClass, "Size", true},
+		{"later", "From", ".", IgnoreClass, "", false},
+		{"urgent", "Bcc", ".", IgnoreClass, "", false},
+		{"urgent", "Subject", "(", IgnoreClass, "", false},
+		{"urgent", "Size", "5000", IgnoreClass, "", false},
+		{"urgent", "Size", ">", IgnoreClass, "", false},
+	} {
+		r, err := NewRule(c.class, c.field, c.pattern)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("No error for %s %s %q", c.class, c.field, c.pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewRule %s %s %q: %v", c.class, c.field, c.pattern, err)
+		} else if r.Class != c.want || r.Field != c.wantField || r.Pattern != c.pattern {
+			t.Errorf("NewRule %s %s %q gave %v", c.class, c.field, c.pattern, r)
+		}
+	}
+}
+
+func TestSizeRule(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		op      byte
+		size    uint32
+	}{
+		{">0", '>', 0},
+		{"<100", '<', 100},
+		{">5k", '>', 5 * 1024},
+		{">5K", '>', 5 * 1024},
+		{"<2M", '<', 2 * 1024 * 1024},
+		{"<2mb", '<', 2 * 1024 * 1024},
+		{">10kb", '>', 10 * 1024},
+		{">4095M", '>', 4095 * 1024 * 1024},
+	} {
+		r, err := NewRule("ignore", "Size", c.pattern)
+		if err != nil {
+			t.Errorf("Size %s: %v", c.pattern, err)
+		} else if r.sizeOp != c.op || r.size != c.size {
+			t.Errorf("Size %s gave %c%d expected %c%d", c.pattern, r.sizeOp, r.size, c.op, c.size)
+		}
+	}
+	for _, pattern := range []string{"=5", "5k", ">", ">k", ">-1", ">1.5M", ">5G", ">4096M", ">4294967296", ">5 k", "<0x10"} {
+		if _, err := NewRule("ignore", "Size", pattern); err == nil {
+			t.Errorf("No error for Size %q", pattern)
+		}
+	}
+}
+
+// listIDResponse is listIDSection as the server answers it.
+var listIDResponse = &imap.BodySectionName{BodyPartName: listIDSection.BodyPartName}
+
+// testRuleMessage returns a message as FETCHed for rules.
+func testRuleMessage(from, subject, listID string, size uint32) *imap.Message {
+	msg := &imap.Message{
+		Envelope: &imap.Envelope{
+			Subject: subject,
+			From:    []*imap.Address{{PersonalName: "Sender", MailboxName: from, HostName: "example.com"}},
+			To:      []*imap.Address{{MailboxName: "me", HostName: "example.com"}},
+		},
+		Size: size,
+		Body: make(map[*imap.BodySectionName]imap.Literal),
+	}
+	if listID != "" {
+		msg.Body[listIDResponse] = bytes.NewBufferString("List-Id: " + listID + "\r\n\r\n")
+	}
+	return msg
+}
+
+func TestClassify(t *testing.T) {
+	var rules []*Rule
+	for _, r := range [][3]string{
+		{"urgent", "From", `^Sender <boss@example\.com>$`},
+		{"ignore", "List-Id", `<news\.lists\.example\.com>`},
+		{"ignore", "Size", ">1M"},
+		{"normal", "Subject", `(?i)^re:`},
+		{"urgent", "To", `^me@`},
+	} {
+		rule, err := NewRule(r[0], r[1], r[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	rs := &RuleSet{Rules: rules, Default: IgnoreClass}
+
+	for _, c := range []struct {
+		name string
+		msg  *imap.Message
+		want Class
+		rule int // index of the matching rule, -1 for none
+	}{
+		{"boss", testRuleMessage("boss", "Hi", "", 100), UrgentClass, 0},
+		{"boss first", testRuleMessage("boss", "Hi", "News <news.lists.example.com>", 2<<20), UrgentClass, 0},
+		{"list", testRuleMessage("news", "Weekly", "News <news.lists.example.com>", 100), IgnoreClass, 1},
+		{"large", testRuleMessage("someone", "Photos", "", 2<<20), IgnoreClass, 2},
+		{"reply", testRuleMessage("someone", "RE: lunch", "", 100), NormalClass, 3},
+		{"to me", testRuleMessage("someone", "Hello", "", 100), UrgentClass, 4},
+		{"no envelope", &imap.Message{Size: 100}, IgnoreClass, -1},
+	} {
+		class, r := rs.Classify(c.msg)
+		if class != c.want {
+			t.Errorf("%s classified %v expected %v", c.name, class, c.want)
+		}
+		if (c.rule == -1 && r != nil) || (c.rule != -1 && r != rules[c.rule]) {
+			t.Errorf("%s matched rule %v", c.name, r)
+		}
+	}
+}
+
+func TestListID(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		want   string
+	}{
+		{"List-Id: Go Nuts <golang-nuts.googlegroups.com>\r\n\r\n", "Go Nuts <golang-nuts.googlegroups.com>"},
+		{"list-id: <lower.example.com>\r\n\r\n", "<lower.example.com>"},
+		{"List-Id: Folded\r\n <folded.example.com>\r\n\r\n", "Folded <folded.example.com>"},
+		{"\r\n", ""},
+		{"", ""},
+	} {
+		msg := &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{
+			listIDResponse: bytes.NewBufferString(c.header),
+		}}
+		if got := listID(msg); got != c.want {
+			t.Errorf("listID(%q) = %q expected %q", c.header, got, c.want)
+		}
+	}
+	if got := listID(&imap.Message{}); got != "" {
+		t.Errorf("listID without the header section = %q", got)
+	}
+}
@@ -0,0 +1,249 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncPolicy selects the new mail that's synced right away.
+type SyncPolicy int
+
+const (
+	SyncAll    SyncPolicy = iota // all new mail, normal mail after the normal delay
+	SyncUrgent                   // only urgent mail
+	SyncNone                     // nothing until the schedule is active again
+)
+
+var syncPolicyNames = []string{"all", "urgent", "none"}
+
+func (p SyncPolicy) String() string {
+	if p >= 0 && int(p) < len(syncPolicyNames) {
+		return syncPolicyNames[p]
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// ParseSyncPolicy returns the sync policy with the given name.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for i, n := range syncPolicyNames {
+		if strings.EqualFold(s, n) {
+			return SyncPolicy(i), nil
+		}
+	}
+	return SyncAll, fmt.Errorf("Unknown sync policy %s", s)
+}
+
+// Policy is how the events of a store are handled.
+type Policy struct {
+	Sync SyncPolicy
+	// FullInterval is the least time between full updates of the store, 0
+	// to include it in every full update and < 0 for none.
+	FullInterval time.Duration
+	// Notify passes on urgent mail as urgent and notices to the user.
+	Notify bool
+}
+
+// DefaultPolicy applies while a store's schedule is active, or always if it
+// has no schedule.
+var DefaultPolicy = Policy{Sync: SyncAll, Notify: true}
+
+// QuietPolicy is the default outside a store's schedule.
+var QuietPolicy = Policy{Sync: SyncNone, FullInterval: -1}
+
+// Suppresses returns true if mail of class c is held by the policy.
+func (p Policy) Suppresses(c Class) bool {
+	return p.Sync == SyncNone || (p.Sync == SyncUrgent && c != UrgentClass)
+}
+
+// Priority is how much a store matters when saving power or data.
+type Priority int
+
+const (
+	NormalPriority Priority = iota // keeps IDLE, syncs wait for a full update
+	LowPriority                    // polls with STATUS instead of IDLE
+	UrgentPriority                 // unchanged
+)
+
+var priorityNames = []string{"normal", "low", "urgent"}
+
+func (p Priority) String() string {
+	if p >= 0 && int(p) < len(priorityNames) {
+		return priorityNames[p]
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority returns the priority with the given name.
+func ParsePriority(s string) (Priority, error) {
+	for i, n := range priorityNames {
+		if strings.EqualFold(s, n) {
+			return Priority(i), nil
+		}
+	}
+	return NormalPriority, fmt.Errorf("Unknown priority %s", s)
+}
+
+// Window is a time of day range on some days of the week. A window ending at
+// or before its start continues to the next day.
+type Window struct {
+	Days       [7]bool // by time.Weekday the window starts on
+	Start, End int     // minutes after midnight
+}
+
+// parseWeekday parses a day name or its first three letters.
+func parseWeekday(s string) (int, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return int(d), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown day %s", s)
+}
+
+// parseDays parses a comma separated list of days and day ranges (e.g.,
+// Mon-Fri), "Daily" or "*" for all.
+func parseDays(s string) (days [7]bool, err error) {
+	if s == "*" || strings.EqualFold(s, "daily") {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		var first, last int
+		ends := strings.SplitN(r, "-", 2)
+		if first, err = parseWeekday(ends[0]); err != nil {
+			return
+		}
+		last = first
+		if len(ends) == 2 {
+			if last, err = parseWeekday(ends[1]); err != nil {
+				return
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseTimeOfDay parses HH:MM returning minutes after midnight, 24:00 is
+// allowed.
+func parseTimeOfDay(s string) (int, error) {
+	hm := strings.SplitN(s, ":", 2)
+	if len(hm) == 2 {
+		h, herr := strconv.Atoi(hm[0])
+		m, merr := strconv.Atoi(hm[1])
+		if herr == nil && merr == nil && h >= 0 && m >= 0 && m < 60 && h*60+m <= 24*60 {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("Bad time %s, expected HH:MM", s)
+}
+
+// ParseWindow parses a window from its days (e.g., Mon-Fri) and hours (e.g.,
+// 08:00-19:00).
+func ParseWindow(days, hours string) (w Window, err error) {
+	if w.Days, err = parseDays(days); err != nil {
+		return
+	}
+	se := strings.SplitN(hours, "-", 2)
+	if len(se) != 2 {
+		return w, fmt.Errorf("Bad hours %s, expected HH:MM-HH:MM", hours)
+	}
+	if w.Start, err = parseTimeOfDay(se[0]); err != nil {
+		return
+	}
+	if w.End, err = parseTimeOfDay(se[1]); err != nil {
+		return
+	}
+	return w, nil
+}
+
+// occurrence returns the window's occurrence starting on the day of t, ok is
+// false if it doesn't start that day.
+func (w *Window) occurrence(t time.Time) (start, end time.Time, ok bool) {
+	if !w.Days[t.Weekday()] {
+		return
+	}
+	y, m, d := t.Date()
+	start = time.Date(y, m, d, w.Start/60, w.Start%60, 0, 0, t.Location())
+	if w.End > w.Start {
+		end = time.Date(y, m, d, w.End/60, w.End%60, 0, 0, t.Location())
+	} else {
+		end = time.Date(y, m, d+1, w.End/60, w.End%60, 0, 0, t.Location())
+	}
+	return start, end, true
+}
+
+// Schedule is when a store is active, in the time zone of the times given.
+type Schedule struct {
+	Windows []Window
+}
+
+// Active returns true if t is in one of the windows.
+func (s *Schedule) Active(t time.Time) bool {
+	for i := range s.Windows {
+		// Windows may have started the day before
+		for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+			start, end, ok := s.Windows[i].occurrence(day)
+			if ok && !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next returns the first time after t that a window starts or ends, the zero
+// time if there are no windows.
+func (s *Schedule) Next(t time.Time) time.Time {
+	var next time.Time
+	for i := range s.Windows {
+		for d := -1; d <= 7; d++ {
+			start, end, ok := s.Windows[i].occurrence(t.AddDate(0, 0, d))
+			if !ok {
+				continue
+			}
+			for _, b := range []time.Time{start, end} {
+				if b.After(t) && (next.IsZero() || b.Before(next)) {
+					next = b
+				}
+			}
+		}
+	}
+	return next
+}
+
+// Policy returns the policy for the store at t and whether its schedule is
+// active.
+func (sc *StoreConfig) Policy(t time.Time) (Policy, bool) {
+	if sc == nil || sc.Schedule == nil || sc.Schedule.Active(t) {
+		return DefaultPolicy, true
+	}
+	return sc.OffHours, false
+}
@@ -0,0 +1,112 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func mustWindow(t *testing.T, days, hours string) Window {
+	t.Helper()
+	w, err := ParseWindow(days, hours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// at returns a time in October 2026, the 19th is a Monday.
+func at(day, hour, min int) time.Time {
+	return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestScheduleActive(t *testing.T) {
+	s := &Schedule{Windows: []Window{
+		mustWindow(t, "Mon-Fri", "08:00-19:00"),
+		mustWindow(t, "Saturday", "22:00-02:00"),
+	}}
+	for _, c := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(18, 12, 0), false}, // Sunday
+		{at(19, 7, 59), false},
+		{at(19, 8, 0), true},
+		{at(23, 18, 59), true},
+		{at(23, 19, 0), false},
+		{at(24, 21, 59), false}, // Saturday
+		{at(24, 22, 0), true},
+		{at(25, 1, 59), true}, // Sunday morning
+		{at(25, 2, 0), false},
+	} {
+		if got := s.Active(c.t); got != c.want {
+			t.Errorf("Active(%v) = %v expected %v", c.t, got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		t, want time.Time
+	}{
+		{at(18, 12, 0), at(19, 8, 0)},
+		{at(19, 8, 0), at(19, 19, 0)},
+		{at(23, 19, 0), at(24, 22, 0)},
+		{at(24, 23, 0), at(25, 2, 0)},
+	} {
+		if got := s.Next(c.t); !got.Equal(c.want) {
+			t.Errorf("Next(%v) = %v expected %v", c.t, got, c.want)
+		}
+	}
+	if !(&Schedule{}).Next(at(18, 12, 0)).IsZero() {
+		t.Errorf("Empty schedule has a next change")
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	w := mustWindow(t, "Fri-Mon,wed", "00:00-24:00")
+	want := [7]bool{true, true, false, true, false, true, true}
+	if w.Days != want {
+		t.Errorf("Days %v expected %v", w.Days, want)
+	}
+	if w := mustWindow(t, "*", "09:30-17:15"); w.Days != [7]bool{true, true, true, true, true, true, true} ||
+		w.Start != 9*60+30 || w.End != 17*60+15 {
+		t.Errorf("Unexpected window %+v", w)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	sc := NewStoreConfig("work")
+	if p, active := sc.Policy(at(18, 12, 0)); !active || p != DefaultPolicy {
+		t.Errorf("Unscheduled store policy %+v %v", p, active)
+	}
+	sc.Schedule = &Schedule{Windows: []Window{mustWindow(t, "Mon-Fri", "08:00-19:00")}}
+	if p, active := sc.Policy(at(18, 12, 0)); active || p != QuietPolicy {
+		t.Errorf("Off hours policy %+v %v", p, active)
+	}
+	if p, active := sc.Policy(at(19, 12, 0)); !active || p != DefaultPolicy {
+		t.Errorf("Active policy %+v %v", p, active)
+	}
+	if !QuietPolicy.Suppresses(UrgentClass) || DefaultPolicy.Suppresses(NormalClass) {
+		t.Errorf("Bad Suppresses")
+	}
+	p := Policy{Sync: SyncUrgent}
+	if p.Suppresses(UrgentClass) || !p.Suppresses(NormalClass) {
+		t.Errorf("Bad Suppresses for urgent only")
+	}
+}
@@ -0,0 +1,178 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ConnKey identifies a server login, accounts with the same key see the same
+// mailboxes and can share connections.
+type ConnKey struct {
+	Host string
+	Port int
+	User string
+}
+
+func (k ConnKey) String() string {
+	return fmt.Sprintf("%s@%s:%d", k.User, k.Host, k.Port)
+}
+
+// ConnKey returns the server login of the account.
+func (a *Account) ConnKey() ConnKey {
+	return ConnKey{Host: a.Host, Port: a.Port, User: a.User}
+}
+
+// owner returns the account whose connections the account uses.
+func (a *Account) owner() *Account {
+	if a.primary != nil {
+		return a.primary
+	}
+	return a
+}
+
+// Primary returns the name of the store whose connections the account
+// shares, empty if it has its own.
+func (a *Account) Primary() string {
+	if a.primary != nil {
+		return a.primary.Name
+	}
+	return ""
+}
+
+// Connections returns the most connections the account keeps open to the
+// server: one watching INBOX and one polling other mailboxes if any are.
+func (a *Account) Connections() int {
+	switch {
+	case a.primary != nil:
+		return 0
+	case a.pollOnConn || a.newStatusPoller() == nil:
+		return 1
+	}
+	return 2
+}
+
+// sameRules returns true if the stores classify and trigger on INBOX changes
+// the same way.
+func sameRules(a, b *StoreConfig) bool {
+	if a == nil {
+		a = NewStoreConfig("")
+	}
+	if b == nil {
+		b = NewStoreConfig("")
+	}
+	if a.Triggers != b.Triggers || len(a.Rules.Rules) != len(b.Rules.Rules) {
+		return false
+	}
+	if len(a.Rules.Rules) != 0 && a.Rules.Default != b.Rules.Default {
+		return false
+	}
+	for i, r := range a.Rules.Rules {
+		o := b.Rules.Rules[i]
+		if r.Class != o.Class || r.Field != o.Field || r.Pattern != o.Pattern {
+			return false
+		}
+	}
+	return true
+}
+
+// sameConnect returns true if the stores connect to the server and save power
+// the same way.
+func sameConnect(a, b *StoreConfig) bool {
+	if a == nil {
+		a = NewStoreConfig("")
+	}
+	if b == nil {
+		b = NewStoreConfig("")
+	}
+	return a.Priority == b.Priority && a.Proxy == b.Proxy &&
+		a.DialTimeout == b.DialTimeout && a.TLSTimeout == b.TLSTimeout &&
+		a.AddressFamily == b.AddressFamily && a.BindAddress.Equal(b.BindAddress) &&
+		a.BindInterface == b.BindInterface && a.Resolver == b.Resolver
+}
+
+// Share has accounts with the same ConnKey share connections before they go
+// online. The first by store name becomes the primary: it watches INBOX for
+// all of them, sending its INBOX events for each, and polls all their
+// Store.Polls mailboxes over one connection. The others don't connect, their
+// state and stats are the primary's. INBOX mail is classified and triggers
+// applied by the primary, and it connects and saves power as configured for
+// itself, so only stores with the same Rules and Triggers, Priority and
+// connection settings (Proxy, timeouts, address family, bind address and
+// resolver) share, a store whose differ has connections of its own.
+//
+// The budget is the most connections for each server login (ConnKey) of all
+// its primaries, primaries poll INBOX along with the other mailboxes rather
+// than IDLE to stay within it, a budget of 0 is unlimited.
+func Share(accounts map[string]*Account, budget int) {
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	primaries := make(map[ConnKey][]*Account)
+	for _, name := range names {
+		a := accounts[name]
+		a.initLog()
+		key := a.ConnKey()
+		var p *Account
+		for _, q := range primaries[key] {
+			if sameRules(a.Store, q.Store) && sameConnect(a.Store, q.Store) {
+				p = q
+				break
+			}
+		}
+		if p == nil {
+			if len(primaries[key]) != 0 {
+				a.log.Infof("not sharing connections of store %s, rules, triggers, priority or connection settings differ", primaries[key][0].Name)
+			}
+			primaries[key] = append(primaries[key], a)
+			continue
+		}
+		a.log.Infof("sharing connections of store %s", p.Name)
+		a.primary = p
+		p.followers = append(p.followers, a)
+	}
+
+	if budget == 0 {
+		return
+	}
+	for key, ps := range primaries {
+		total := 0
+		for _, p := range ps {
+			total += p.Connections()
+		}
+		for _, p := range ps {
+			if total <= budget {
+				break
+			}
+			if p.Connections() > 1 {
+				p.log.Infof("polling INBOX with the other mailboxes to stay within %d connections to %v", budget, key)
+				p.pollOnConn = true
+				total--
+			}
+		}
+		if total > budget {
+			ps[0].log.Warnf("%d connections to %v needed for stores with different settings, more than %d", total, key, budget)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// sameLogin returns accounts of fs for the stores names, all logging in the
+// same way.
+func sameLogin(fs *fakeServer, names ...string) map[string]*Account {
+	accounts := make(map[string]*Account)
+	for _, name := range names {
+		a := fs.account()
+		a.Name = name
+		a.UpdateName = name + "-channel:INBOX"
+		a.Store = NewStoreConfig(name)
+		accounts[name] = a
+	}
+	return accounts
+}
+
+// run shares the accounts and takes them online, returning their events.
+func run(t *testing.T, accounts map[string]*Account, budget int) chan Event {
+	t.Helper()
+	Share(accounts, budget)
+	w := New(accounts)
+	w.Start(context.Background())
+	eventc := make(chan Event, 100)
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case e := <-w.Events():
+				eventc <- e
+			case <-quit:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		w.Stop()
+		close(quit)
+	})
+	return eventc
+}
+
+// waitEvents waits for events of type code for each of the accounts.
+func waitEvents(t *testing.T, eventc <-chan Event, code EventCode, accounts ...*Account) []Event {
+	t.Helper()
+	var events []Event
+	for range accounts {
+		events = append(events, waitEvent(t, eventc, code, 5*time.Second))
+	}
+	for _, a := range accounts {
+		found := false
+		for _, e := range events {
+			found = found || e.A == a
+		}
+		if !found {
+			t.Errorf("No %v event for %s: %+v", code, a.Name, events)
+		}
+	}
+	return events
+}
+
+func TestShare(t *testing.T) {
+	fs := newFakeServer(t, true)
+	other := newFakeServer(t, true)
+	accounts := sameLogin(fs, "a", "b", "c")
+	d := other.account()
+	d.Name = "d"
+	accounts["d"] = d
+	eventc := run(t, accounts, 0)
+	a, b, c := accounts["a"], accounts["b"], accounts["c"]
+
+	waitFor(t, 5*time.Second, "accounts to connect", func() bool {
+		return b.Stats().Idling && c.Stats().Idling && d.Stats().Idling
+	})
+	if b.Primary() != "a" || c.Primary() != "a" || a.Primary() != "" || d.Primary() != "" {
+		t.Errorf("Unexpected primaries %q %q %q %q", a.Primary(), b.Primary(), c.Primary(), d.Primary())
+	}
+	if n := fs.Accepted(); n != 1 {
+		t.Errorf("%d connections expected 1", n)
+	}
+
+	// INBOX changes are sent for all the stores sharing it
+	fs.Deliver(testMessage)
+	for _, e := range waitEvents(t, eventc, CheckMailEvent, a, b, c) {
+		if e.UpdateName() != e.A.UpdateName || e.N != 1 {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	}
+
+	// Pausing any of them pauses the connection
+	c.Pause(true)
+	waitFor(t, 5*time.Second, "accounts to pause", func() bool {
+		return a.Stats().State == Paused && b.Stats().State == Paused
+	})
+	if !b.Paused() || d.Paused() {
+		t.Errorf("Unexpected paused b %v d %v", b.Paused(), d.Paused())
+	}
+	b.Pause(false)
+	waitFor(t, 5*time.Second, "accounts to resume", func() bool {
+		return a.Stats().Idling
+	})
+}
+
+func TestShareCounts(t *testing.T) {
+	fs := newFakeServer(t, true)
+	accounts := sameLogin(fs, "a", "b")
+	eventc := run(t, accounts, 0)
+	a, b := accounts["a"], accounts["b"]
+	waitFor(t, 5*time.Second, "accounts to connect", func() bool {
+		return b.Stats().Idling
+	})
+
+	// The shared INBOX is counted once, as the primary's
+	fs.Deliver(testMessage)
+	n := 0
+	timer := time.NewTimer(500 * time.Millisecond)
+	defer timer.Stop()
+	for done := false; !done; {
+		select {
+		case e := <-eventc:
+			if e.E != CountsEvent {
+				continue
+			}
+			if e.A != a {
+				t.Errorf("Counts event for %s", e.A.Name)
+			}
+			n++
+		case <-timer.C:
+			done = true
+		}
+	}
+	if n == 0 {
+		t.Errorf("No counts events")
+	}
+}
+
+func TestShareSettings(t *testing.T) {
+	fs := newFakeServer(t, true)
+	for name, set := range map[string]func(sc *StoreConfig){
+		"priority": func(sc *StoreConfig) { sc.Priority = UrgentPriority },
+		"proxy":    func(sc *StoreConfig) { sc.Proxy = "none" },
+		"dial":     func(sc *StoreConfig) { sc.DialTimeout = time.Second },
+		"tls":      func(sc *StoreConfig) { sc.TLSTimeout = time.Second },
+		"family":   func(sc *StoreConfig) { sc.AddressFamily = IPv4Only },
+		"bind":     func(sc *StoreConfig) { sc.BindAddress = net.ParseIP("127.0.0.1") },
+		"iface":    func(sc *StoreConfig) { sc.BindInterface = "lo" },
+		"resolver": func(sc *StoreConfig) { sc.Resolver = "127.0.0.53" },
+	} {
+		accounts := sameLogin(fs, "a", "b")
+		set(accounts["b"].Store)
+		Share(accounts, 0)
+		if p := accounts["b"].Primary(); p != "" {
+			t.Errorf("%s: b shares the connections of %q", name, p)
+		}
+	}
+
+	// The same settings share
+	accounts := sameLogin(fs, "a", "b")
+	for _, a := range accounts {
+		a.Store.Proxy = "none"
+		a.Store.BindAddress = net.ParseIP("127.0.0.1")
+	}
+	Share(accounts, 0)
+	if p := accounts["b"].Primary(); p != "a" {
+		t.Errorf("b shares the connections of %q", p)
+	}
+}
+
+func TestShareBudget(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("Lists/go")
+	fs.AddMailbox("Archive")
+	accounts := sameLogin(fs, "a", "b")
+	a, b := accounts["a"], accounts["b"]
+	a.Store.Polls = []PollTarget{{Pattern: "Archive"}}
+	b.Store.Polls = []PollTarget{{Pattern: "Lists/*"}}
+	b.Store.PollInterval = 50 * time.Millisecond
+
+	// Without a budget both stores' mailboxes are polled on a second
+	// connection.
+	Share(accounts, 0)
+	if a.Connections() != 2 || b.Connections() != 0 {
+		t.Errorf("Connections a %d b %d expected 2 and 0", a.Connections(), b.Connections())
+	}
+	a.primary, a.followers, b.primary = nil, nil, nil
+
+	// Otherwise INBOX is polled along with them
+	eventc := run(t, accounts, 1)
+	if a.Connections() != 1 {
+		t.Errorf("Connections %d expected 1", a.Connections())
+	}
+	waitFor(t, 5*time.Second, "mailboxes to be counted", func() bool {
+		counts := b.Stats().Counts
+		_, golang := counts["Lists/go"]
+		_, inbox := counts["INBOX"]
+		return golang && inbox
+	})
+	if s := b.Stats(); s.State != Polling && s.State != Selecting {
+		t.Errorf("Unexpected state %v", s.State)
+	}
+
+	fs.DeliverTo("Lists/go", testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.A != b || e.UpdateName() != "b-channel:Lists/go" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	fs.DeliverTo("Archive", testMessage)
+	e = waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.A != a || e.UpdateName() != "a-channel:Archive" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	fs.Deliver(testMessage)
+	waitEvents(t, eventc, CheckMailEvent, a, b)
+	if n := fs.Accepted(); n != 1 {
+		t.Errorf("%d connections expected 1", n)
+	}
+}
+
+func TestShareRules(t *testing.T) {
+	fs := newFakeServer(t, true)
+	accounts := sameLogin(fs, "a", "b", "c", "d")
+	a, b, c, d := accounts["a"], accounts["b"], accounts["c"], accounts["d"]
+	for _, sc := range []*StoreConfig{c.Store, d.Store} {
+		r, err := NewRule("ignore", "From", `someone@example\.com`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc.Rules.Rules = []*Rule{r}
+	}
+	b.Store.Triggers[FlagsChange] = NowAction
+	a.Store.Polls = []PollTarget{{Pattern: "Archive"}}
+
+	// A login's budget covers the connections of all its primaries
+	Share(accounts, 3)
+	if a.Primary() != "" || b.Primary() != "" || c.Primary() != "" || d.Primary() != "c" {
+		t.Errorf("Unexpected primaries %q %q %q %q", a.Primary(), b.Primary(), c.Primary(), d.Primary())
+	}
+	if a.Connections() != 1 || b.Connections() != 1 || c.Connections() != 1 {
+		t.Errorf("Connections %d %d %d expected 1 each", a.Connections(), b.Connections(), c.Connections())
+	}
+	for _, p := range []*Account{a, b, c, d} {
+		p.primary, p.followers, p.pollOnConn = nil, nil, false
+	}
+	a.Store.Polls = nil
+
+	// Each store's rules classify its INBOX mail
+	eventc := run(t, accounts, 0)
+	waitFor(t, 5*time.Second, "accounts to connect", func() bool {
+		return a.Stats().Idling && b.Stats().Idling && c.Stats().Idling
+	})
+	if n := fs.Accepted(); n != 3 {
+		t.Errorf("%d connections expected 3", n)
+	}
+	fs.Deliver(testMessage)
+	for _, e := range waitEvents(t, eventc, CheckMailEvent, a, b) {
+		if e.C != UrgentClass {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	}
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case e := <-eventc:
+			if e.E == CheckMailEvent {
+				t.Errorf("Ignored mail signalled for %s", e.A.Name)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
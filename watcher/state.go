@@ -0,0 +1,156 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the connection state of an account.
+type State int
+
+const (
+	Disconnected   State = iota // not connected
+	Connecting                  // dialing and waiting for the greeting
+	Authenticating              // logging in
+	Selecting                   // selecting INBOX or checking it for new mail
+	Idling                      // running IDLE
+	Polling                     // waiting to poll as IDLE isn't supported
+	Backoff                     // waiting to reconnect after an error
+	AuthFailed                  // the server refused the login
+	Failed                      // given up on after restarting too often
+	Paused                      // taken offline by the user, see Account.Pause
+	Sleeping                    // logged out between power saving polls
+)
+
+var stateNames = []string{
+	"disconnected",
+	"connecting",
+	"authenticating",
+	"selecting",
+	"idling",
+	"polling",
+	"backoff",
+	"auth-failed",
+	"failed",
+	"paused",
+	"sleeping",
+}
+
+func (s State) String() string {
+	if s >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Connected returns true if the state has a logged in connection.
+func (s State) Connected() bool {
+	return s == Selecting || s == Idling || s == Polling
+}
+
+// The valid transitions from each state. Any state can go to Disconnected
+// when the account is taken offline or the connection is lost.
+var transitions = map[State][]State{
+	Disconnected:   {Connecting, Backoff, Failed, Paused, Sleeping},
+	Connecting:     {Authenticating, Backoff},
+	Authenticating: {Selecting, Backoff, AuthFailed},
+	Selecting:      {Idling, Polling, Backoff},
+	Idling:         {Selecting, Backoff},
+	Polling:        {Selecting, Backoff},
+	Backoff:        {Connecting},
+	AuthFailed:     {Connecting},
+	Paused:         {Connecting},
+	Sleeping:       {Connecting},
+}
+
+func validTransition(from, to State) bool {
+	if to == Disconnected {
+		return true
+	}
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition is a change of account state.
+type Transition struct {
+	From   State
+	To     State
+	At     time.Time
+	Reason string // why, e.g., the error, empty for the normal flow
+}
+
+// HistoryLen is the number of transitions kept per account.
+const HistoryLen = 32
+
+// stateMachine tracks the account state, it's safe for concurrent use.
+type stateMachine struct {
+	lock    sync.Mutex
+	state   State
+	since   time.Time
+	history []Transition // ring buffer of the last HistoryLen transitions
+	next    int          // next history slot
+	invalid int          // rejected transitions, a bug if not 0
+}
+
+// set moves to state to returning false if that isn't a valid transition.
+func (sm *stateMachine) set(to State, at time.Time, reason string) (Transition, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	t := Transition{From: sm.state, To: to, At: at, Reason: reason}
+	if !validTransition(sm.state, to) {
+		sm.invalid++
+		return t, false
+	}
+	sm.state = to
+	sm.since = at
+	if len(sm.history) < HistoryLen {
+		sm.history = append(sm.history, t)
+	} else {
+		sm.history[sm.next] = t
+	}
+	sm.next = (sm.next + 1) % HistoryLen
+	return t, true
+}
+
+func (sm *stateMachine) get() (State, time.Time) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return sm.state, sm.since
+}
+
+// transitions returns the history oldest first.
+func (sm *stateMachine) transitions() []Transition {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	h := make([]Transition, 0, len(sm.history))
+	if len(sm.history) == HistoryLen {
+		h = append(h, sm.history[sm.next:]...)
+		h = append(h, sm.history[:sm.next]...)
+	} else {
+		h = append(h, sm.history...)
+	}
+	return h
+}
@@ -0,0 +1,183 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateTransitions(t *testing.T) {
+	var sm stateMachine
+	at := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	if _, ok := sm.set(Idling, at, ""); ok {
+		t.Errorf("disconnected -> idling allowed")
+	}
+	if s, _ := sm.get(); s != Disconnected || sm.invalid != 1 {
+		t.Errorf("Invalid transition changed state to %v (invalid %d)", s, sm.invalid)
+	}
+
+	for _, s := range []State{Connecting, Authenticating, AuthFailed, Connecting, Authenticating,
+		Selecting, Idling, Selecting, Polling, Backoff, Disconnected} {
+		at = at.Add(time.Second)
+		if _, ok := sm.set(s, at, ""); !ok {
+			t.Fatalf("%v -> %v not allowed", sm.state, s)
+		}
+	}
+	if s, since := sm.get(); s != Disconnected || !since.Equal(at) {
+		t.Errorf("Unexpected state %v since %v", s, since)
+	}
+	if h := sm.transitions(); len(h) != 11 || h[0].To != Connecting || h[10].From != Backoff {
+		t.Errorf("Unexpected history %+v", h)
+	}
+}
+
+func TestStateHistoryBound(t *testing.T) {
+	var sm stateMachine
+	at := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < HistoryLen+5; i++ {
+		at = at.Add(time.Second)
+		sm.set(Connecting, at, "")
+		at = at.Add(time.Second)
+		sm.set(Backoff, at, "")
+	}
+	h := sm.transitions()
+	if len(h) != HistoryLen {
+		t.Fatalf("History length %d expected %d", len(h), HistoryLen)
+	}
+	for i := 1; i < len(h); i++ {
+		if !h[i].At.After(h[i-1].At) {
+			t.Fatalf("History out of order at %d: %+v", i, h)
+		}
+	}
+	if !h[len(h)-1].At.Equal(at) {
+		t.Errorf("Newest transition at %v expected %v", h[len(h)-1].At, at)
+	}
+}
+
+func TestStateConcurrentReads(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				a.State()
+				a.Stats()
+				a.History()
+			}
+		}()
+	}
+	startOnline(t, a)
+	fs.Drop()
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 1 && s.Idling
+	})
+	close(stop)
+	wg.Wait()
+}
+
+// states returns the To states of the account history.
+func states(a *Account) []State {
+	var s []State
+	for _, t := range a.History() {
+		s = append(s, t.To)
+	}
+	return s
+}
+
+// invalid returns the number of invalid transitions the account attempted.
+func invalid(a *Account) int {
+	a.sm.lock.Lock()
+	defer a.sm.lock.Unlock()
+	return a.sm.invalid
+}
+
+func TestStateIdle(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	startOnline(t, a)
+	fs.Drop()
+	waitFor(t, 5*time.Second, "reconnect", func() bool {
+		s := a.Stats()
+		return s.Reconnects == 1 && s.Idling
+	})
+
+	want := []State{Connecting, Authenticating, Selecting, Idling, Selecting, Disconnected,
+		Connecting, Authenticating, Selecting, Idling}
+	if got := states(a); !reflect.DeepEqual(got, want) {
+		t.Errorf("States %v expected %v", got, want)
+	}
+	if n := invalid(a); n != 0 {
+		t.Errorf("%d invalid transitions", n)
+	}
+}
+
+func TestStatePoll(t *testing.T) {
+	fs := newFakeServer(t, false)
+	a := fs.account()
+	startOnline(t, a)
+	waitFor(t, 5*time.Second, "poll", func() bool {
+		return len(a.History()) >= 6
+	})
+
+	want := []State{Connecting, Authenticating, Selecting, Polling, Selecting, Polling}
+	if got := states(a)[:6]; !reflect.DeepEqual(got, want) {
+		t.Errorf("States %v expected %v", got, want)
+	}
+	if n := invalid(a); n != 0 {
+		t.Errorf("%d invalid transitions", n)
+	}
+}
+
+func TestStateAuthFailed(t *testing.T) {
+	fs := newFakeServer(t, true)
+	a := fs.account()
+	a.Password = "wrong"
+	if err := a.Login(context.Background()); err == nil {
+		t.Fatalf("Login with bad password succeeded")
+	}
+	if s, _ := a.State(); s != AuthFailed {
+		t.Errorf("State %v after refused login", s)
+	}
+
+	a.Password = ""
+	a.PassCmd = "exit 1"
+	if err := a.Login(context.Background()); err == nil {
+		t.Fatalf("Login with failing PassCmd succeeded")
+	}
+	h := a.History()
+	if last := h[len(h)-1]; last.To != Backoff || last.Reason == "" {
+		t.Errorf("Unexpected transition after PassCmd failure %+v", last)
+	}
+}
@@ -0,0 +1,441 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefStatusPollInt = 5 * time.Minute
+
+// statusHighestModSeq is the CONDSTORE STATUS item, see RFC 7162.
+const statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// PollTarget is a mailbox, LIST pattern of mailboxes or special use (e.g.,
+// \Important) checked with STATUS rather than watched with IDLE. Mailboxes are
+// named as on the server, in UTF-8. Changes are updated as Channel:mailbox,
+// the channel of the account's UpdateName if Channel is empty.
+type PollTarget struct {
+	Pattern string
+	Channel string
+}
+
+// pollTarget is a PollTarget of the account whose store configured it.
+type pollTarget struct {
+	PollTarget
+	a *Account
+}
+
+// polled is a mailbox found by LIST for a target.
+type polled struct {
+	channel string // of the target, may be empty
+	a       *Account
+}
+
+// mailboxStatus is what a poll found in a mailbox.
+type mailboxStatus struct {
+	messages    uint32
+	unseen      uint32
+	uidNext     uint32
+	uidValidity uint32
+	modSeq      uint64 // 0 without CONDSTORE
+}
+
+// statusPoller checks the Store.Polls mailboxes of an account, and of the
+// accounts sharing its connections, with STATUS. It uses a connection of its
+// own unless the account has it poll on the account's connection.
+type statusPoller struct {
+	a         *Account
+	targets   []pollTarget
+	log       *log.Entry
+	c         *client.Client
+	connDone  chan struct{} // closed when the connection is done with
+	condStore bool
+	last      map[string]mailboxStatus // by mailbox, from the last poll
+}
+
+// newStatusPoller returns a poller for the targets of the account and those
+// sharing its connections, nil if there are none.
+func (a *Account) newStatusPoller() *statusPoller {
+	var targets []pollTarget
+	for _, b := range append([]*Account{a}, a.followers...) {
+		if b.Store == nil {
+			continue
+		}
+		for _, t := range b.Store.Polls {
+			targets = append(targets, pollTarget{t, b})
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return &statusPoller{
+		a:       a,
+		targets: targets,
+		log:     a.baseLog.WithField("subsystem", "status-poll"),
+		last:    make(map[string]mailboxStatus),
+	}
+}
+
+// statusPollInt returns the time between STATUS polls, stretched while the
+// account is constrained.
+func (a *Account) statusPollInt() time.Duration {
+	d := DefStatusPollInt
+	if a.Store != nil && a.Store.PollInterval != 0 {
+		d = a.Store.PollInterval
+	}
+	if a.Constrained() && d < a.powerPollInt() {
+		d = a.powerPollInt()
+	}
+	return d
+}
+
+// interval returns the shortest time between STATUS polls of the targets.
+func (p *statusPoller) interval() time.Duration {
+	d := p.a.statusPollInt()
+	for _, t := range p.targets {
+		if i := t.a.statusPollInt(); i < d {
+			d = i
+		}
+	}
+	return d
+}
+
+// updateChannel returns the channel of the account's UpdateName.
+func (a *Account) updateChannel() string {
+	if i := strings.Index(a.UpdateName, ":"); i >= 0 {
+		return a.UpdateName[:i]
+	}
+	if len(a.Channels) != 0 {
+		return a.Channels[0].Name
+	}
+	return a.UpdateName
+}
+
+// run polls the targets every interval until ctx is done. It skips polls
+// while the account is paused and logs out between them while it's saving
+// power.
+func (p *statusPoller) run(ctx context.Context) {
+	a := p.a
+	defer func() {
+		// The account keeps running without polling.
+		if r := recover(); r != nil {
+			p.log.WithField("stack", string(debug.Stack())).Errorf("status poller panic: %v", r)
+			p.disconnect()
+			return
+		}
+		p.logout()
+	}()
+
+	p.log.Debugf("polling %d targets every %v", len(p.targets), p.interval())
+	for ctx.Err() == nil {
+		wait := p.interval()
+		if a.Paused() {
+			p.logout()
+		} else {
+			p.poll(ctx)
+			if a.PowerSave() {
+				p.logout()
+				if wait < a.powerPollInt() {
+					wait = a.powerPollInt()
+				}
+			}
+		}
+		t := a.clock().NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+}
+
+// connect connects and logs in, the connection is closed if ctx is done
+// before disconnect.
+func (p *statusPoller) connect(ctx context.Context) error {
+	a := p.a
+	tlsConfig, err := a.tlsConfig()
+	if err != nil {
+		return err
+	}
+	var times ConnectTimes
+	raw, err := a.dial(ctx, &times)
+	if err != nil {
+		return err
+	}
+	conn := raw
+	if !a.StartTLS {
+		conn = tls.Client(raw, tlsConfig)
+	}
+	connDone := make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		select {
+		case <-ctx.Done():
+			raw.Close()
+		case <-connDone:
+		}
+	}()
+	p.connDone = connDone
+
+	if !a.StartTLS {
+		if err := a.handshake(conn.(*tls.Conn), &times); err != nil {
+			raw.Close()
+			p.disconnect()
+			return err
+		}
+	}
+	if p.c, err = a.greet(raw, conn, tlsConfig, &times); err != nil {
+		raw.Close()
+		p.disconnect()
+		return err
+	}
+	p.c.ErrorLog = p.log.WithField("subsystem", "imap")
+	start := time.Now()
+	err = a.authenticate(p.c, p.log)
+	times.Auth = time.Since(start)
+	if err != nil {
+		p.disconnect()
+		return err
+	}
+	if p.condStore, err = p.c.Support("CONDSTORE"); err != nil {
+		p.disconnect()
+		return err
+	}
+	p.log.WithFields(times.fields()).Debugf("logged in after %v, CONDSTORE: %v", times.Total().Round(time.Millisecond), p.condStore)
+	return nil
+}
+
+// disconnect closes the connection without logging out.
+func (p *statusPoller) disconnect() {
+	if p.c != nil {
+		p.c.Terminate()
+		p.c = nil
+	}
+	if p.connDone != nil {
+		close(p.connDone)
+		p.connDone = nil
+	}
+}
+
+// logout logs out and closes the connection if there is one.
+func (p *statusPoller) logout() {
+	if p.c == nil {
+		return
+	}
+	p.c.Timeout = logoutTimeout
+	if err := p.c.Logout(); err != nil {
+		p.log.Debugf("logout: %v", err)
+	}
+	p.disconnect()
+}
+
+// lost returns true if the connection has been closed.
+func (p *statusPoller) lost() bool {
+	select {
+	case <-p.c.LoggedOut():
+		return true
+	default:
+		return false
+	}
+}
+
+// poll checks each polled mailbox, connecting first if need be. Errors are
+// logged and the mailboxes are tried again at the next poll.
+func (p *statusPoller) poll(ctx context.Context) {
+	if p.c == nil {
+		if err := p.connect(ctx); err != nil {
+			p.log.Warnf("connect failed, will retry: %v", err)
+			return
+		}
+	}
+	p.checkAll(ctx)
+	if p.c != nil && p.lost() {
+		p.log.Warnf("connection lost, will reconnect")
+		p.disconnect()
+	}
+}
+
+// pollOn checks each polled mailbox over the account's connection c.
+func (p *statusPoller) pollOn(ctx context.Context, c *client.Client) {
+	p.c = c
+	p.checkAll(ctx)
+	p.c = nil
+}
+
+// checkAll checks each polled mailbox over the connection.
+func (p *statusPoller) checkAll(ctx context.Context) {
+	mailboxes, err := p.list()
+	if err != nil {
+		p.log.Warnf("LIST failed: %v", err)
+		return
+	}
+	names := make([]string, 0, len(mailboxes))
+	for name := range mailboxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := p.check(name, mailboxes[name]); err != nil {
+			p.log.WithField("mailbox", name).Warnf("STATUS failed: %v", err)
+			if p.lost() {
+				return
+			}
+		}
+	}
+}
+
+// list returns the mailboxes matching the poll targets, the first target
+// matching a mailbox gives its channel and account. INBOX is left to the
+// account. Special use targets are resolved with the mailboxes the account
+// found logging in.
+func (p *statusPoller) list() (map[string]polled, error) {
+	found := make(map[string]polled)
+	add := func(name string, t pollTarget) {
+		if _, ok := found[name]; !ok && !strings.EqualFold(name, "INBOX") {
+			found[name] = polled{t.Channel, t.a}
+		}
+	}
+	for _, t := range p.targets {
+		if IsSpecialUse(t.Pattern) {
+			name, err := t.a.Resolve(t.Pattern)
+			if err != nil {
+				p.log.Debugf("skipping %s: %v", t.Pattern, err)
+			} else {
+				add(name, t)
+			}
+			continue
+		}
+		mailboxes := make(chan *imap.MailboxInfo, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- p.c.List("", t.Pattern, mailboxes)
+		}()
+		for m := range mailboxes {
+			if !hasAttr(m.Attributes, imap.NoSelectAttr) {
+				add(m.Name, t)
+			}
+		}
+		if err := <-done; err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func hasAttr(attrs []string, attr string) bool {
+	for _, a := range attrs {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// check gets the STATUS of a mailbox signalling any change since the last
+// poll as a change of channel:mailbox.
+func (p *statusPoller) check(name string, pm polled) error {
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUidNext, imap.StatusUidValidity, imap.StatusUnseen}
+	if p.condStore {
+		items = append(items, statusHighestModSeq)
+	}
+	st, err := p.c.Status(name, items)
+	if err != nil {
+		return err
+	}
+	cur := mailboxStatus{
+		messages:    st.Messages,
+		unseen:      st.Unseen,
+		uidNext:     st.UidNext,
+		uidValidity: st.UidValidity,
+	}
+	if v, ok := st.Items[statusHighestModSeq]; ok && v != nil {
+		cur.modSeq, _ = strconv.ParseUint(fmt.Sprint(v), 10, 64)
+	}
+	p.log.WithField("mailbox", name).Tracef("STATUS: %+v", cur)
+	pm.a.setCounts(name, Counts{Messages: int(cur.messages), Unseen: int(cur.unseen)})
+
+	old, ok := p.last[name]
+	p.last[name] = cur
+	if !ok {
+		return nil
+	}
+	update := pm.a.ChannelArg(pm.channel, name)
+	if cur.uidValidity != old.uidValidity {
+		// The mailbox was recreated, everything in it is new
+		p.signal(pm.a, NewMailChange, name, update, int(cur.messages), cur)
+	} else if cur.uidNext > old.uidNext {
+		p.signal(pm.a, NewMailChange, name, update, int(cur.uidNext-old.uidNext), cur)
+	} else if cur.messages < old.messages {
+		p.signal(pm.a, ExpungeChange, name, update, int(old.messages-cur.messages), cur)
+	} else if cur.modSeq != old.modSeq || cur.unseen != old.unseen {
+		// Which messages changed isn't known
+		p.signal(pm.a, FlagsChange, name, update, 0, cur)
+	}
+	return nil
+}
+
+// signal sends an event for a change of a mailbox polled for account a
+// unless its store ignores the change. New mail isn't fetched so it gets the
+// default class.
+func (p *statusPoller) signal(a *Account, c Change, name, update string, count int, st mailboxStatus) {
+	l := p.log.WithField("mailbox", name)
+	if a.Store.Trigger(c) == IgnoreAction {
+		l.Debugf("ignoring %v change", c)
+		return
+	}
+	code := EventCode(CheckMailEvent)
+	if c == ExpungeChange {
+		code = ExpungeEvent
+	} else if c == FlagsChange {
+		code = FlagsChangedEvent
+	}
+	class := a.defaultClass()
+	if c == NewMailChange && class == IgnoreClass {
+		l.Debugf("ignoring NEW mail until next full update: %d", count)
+		return
+	}
+	l.Debugf("signaling %v change: %d for %s", c, count, update)
+	// Sent by the account running the poller
+	p.a.send(Event{
+		E:       code,
+		A:       a,
+		C:       class,
+		Mailbox: name,
+		Update:  update,
+		N:       count,
+		Total:   int(st.messages),
+	})
+}
@@ -0,0 +1,89 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusPoll(t *testing.T) {
+	fs := newFakeServer(t, true)
+	fs.AddMailbox("Lists/go")
+	fs.AddMailbox("Lists/rust")
+	fs.AddMailbox("Archive")
+
+	a := fs.account()
+	a.Store.Polls = []PollTarget{{Pattern: "Lists/*", Channel: "lists"}, {Pattern: "INBOX"}, {Pattern: "Archive"}}
+	a.Store.PollInterval = 50 * time.Millisecond
+	eventc := startOnline(t, a)
+
+	// The first poll only counts
+	waitFor(t, 5*time.Second, "mailboxes to be counted", func() bool {
+		c := a.Stats().Counts
+		_, golang := c["Lists/go"]
+		_, archive := c["Archive"]
+		return golang && archive
+	})
+	noEvent(t, eventc, CheckMailEvent, 100*time.Millisecond)
+
+	fs.DeliverTo("Lists/rust", testMessage)
+	e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "Lists/rust" || e.UpdateName() != "lists:Lists/rust" || e.N != 1 || e.Total != 1 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if c := a.Stats().Counts["Lists/rust"]; c.Messages != 1 || c.Unseen != 1 {
+		t.Errorf("Unexpected Lists/rust counts %+v", c)
+	}
+
+	fs.DeliverTo("Archive", testMessage)
+	e = waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "Archive" || e.UpdateName() != "test-channel:Archive" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	// INBOX is left to IDLE
+	fs.Deliver(testMessage)
+	e = waitEvent(t, eventc, CheckMailEvent, 5*time.Second)
+	if e.Mailbox != "" || e.UpdateName() != "test-channel:INBOX" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	noEvent(t, eventc, CheckMailEvent, 200*time.Millisecond)
+}
+
+func TestStatusPollConstrained(t *testing.T) {
+	a := &Account{Store: NewStoreConfig("a"), PollInt: time.Minute}
+	b := &Account{Store: NewStoreConfig("b"), primary: a}
+	a.Store.PollInterval = 2 * time.Minute
+	if a.statusPollInt() != 2*time.Minute || b.statusPollInt() != DefStatusPollInt {
+		t.Errorf("Unexpected intervals %v %v", a.statusPollInt(), b.statusPollInt())
+	}
+
+	// Stretched to the power poll interval, for followers by their primary
+	a.SetConstrained(true)
+	b.SetConstrained(false)
+	if a.statusPollInt() != DefPowerPollInt || b.statusPollInt() != DefPowerPollInt || a.PollInterval() != DefPowerPollInt {
+		t.Errorf("Unexpected constrained intervals %v %v %v", a.statusPollInt(), b.statusPollInt(), a.PollInterval())
+	}
+	a.PowerPollInt = time.Second
+	if a.statusPollInt() != 2*time.Minute || a.PollInterval() != time.Minute {
+		t.Errorf("Intervals shortened to %v %v", a.statusPollInt(), a.PollInterval())
+	}
+}
@@ -0,0 +1,162 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	DefMaxRestarts  = 5
+	DefRestartDelay = 10 * time.Second
+
+	// The restart delay doubles up to this
+	maxRestartDelay = 10 * time.Minute
+	// An account running this long has its restart count reset
+	restartReset = time.Hour
+)
+
+// Watcher keeps a set of accounts online delivering their events on a single
+// channel. An account that panics is restarted after RestartDelay, doubling
+// each time, and marked Failed after MaxRestarts restarts. The other accounts
+// keep running.
+type Watcher struct {
+	Accounts     map[string]*Account
+	MaxRestarts  int
+	RestartDelay time.Duration
+
+	events chan Event
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// PanicError is a panic recovered from an account.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// New returns a watcher for the accounts keyed by store name.
+func New(accounts map[string]*Account) *Watcher {
+	return &Watcher{
+		Accounts:     accounts,
+		MaxRestarts:  DefMaxRestarts,
+		RestartDelay: DefRestartDelay,
+		events:       make(chan Event, 1),
+	}
+}
+
+// Events returns the channel the account events are delivered on. It must be
+// read while the watcher is running or the accounts will block.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Start takes the accounts online, they stay online until ctx is done or Stop
+// is called.
+func (w *Watcher) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	for _, a := range w.Accounts {
+		w.wg.Add(1)
+		go func(a *Account) {
+			defer w.wg.Done()
+			w.supervise(ctx, a)
+		}(a)
+	}
+}
+
+// Stop takes the accounts offline and waits for them to finish.
+func (w *Watcher) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+}
+
+// run runs the account until ctx is done, returning the panic if it panics.
+func (w *Watcher) run(ctx context.Context, a *Account) (err *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	a.Online(ctx, w.events)
+	return nil
+}
+
+// supervise runs the account until ctx is done restarting it if it panics.
+func (w *Watcher) supervise(ctx context.Context, a *Account) {
+	delay := w.RestartDelay
+	restarts := 0
+	for {
+		start := a.clock().Now()
+		perr := w.run(ctx, a)
+		if perr == nil || ctx.Err() != nil {
+			return
+		}
+		a.log.WithField("stack", string(perr.Stack)).Errorf("account %v", perr)
+		a.updateStats(func(s *AccountStats) {
+			s.Panics++
+			s.LastPanic = fmt.Sprintf("%v\n%s", perr.Value, perr.Stack)
+		})
+
+		if a.clock().Now().Sub(start) >= restartReset {
+			delay = w.RestartDelay
+			restarts = 0
+		}
+		if restarts >= w.MaxRestarts {
+			a.log.Errorf("giving up after %d restarts", restarts)
+			a.setState(Failed, perr.Error())
+			w.signalState(ctx, a)
+			return
+		}
+		restarts++
+
+		a.setState(Backoff, perr.Error())
+		w.signalState(ctx, a)
+		a.log.Infof("restarting in %v", delay)
+		t := a.clock().NewTimer(delay)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			a.setState(Disconnected, "offline")
+			return
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// signalState sends a StateEvent for an account that isn't online.
+func (w *Watcher) signalState(ctx context.Context, a *Account) {
+	select {
+	case w.events <- Event{E: StateEvent, A: a}:
+	case <-ctx.Done():
+	}
+}
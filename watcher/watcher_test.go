@@ -0,0 +1,130 @@
+// -*- coding: utf-8 -*-
+//
+// October 18 2026, Christian Hopps <chopps@gmail.com>
+//
+// Copyright (c) 2026, Christian Hopps
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package watcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	idle := newFakeServer(t, true)
+	poll := newFakeServer(t, false)
+
+	a, b := idle.account(), poll.account()
+	a.Name, b.Name = "idle", "poll"
+	w := New(map[string]*Account{"idle": a, "poll": b})
+	w.Start(context.Background())
+
+	// Keep reading events until stopping
+	eventc := make(chan Event, 100)
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case e := <-w.Events():
+				eventc <- e
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	waitFor(t, 5*time.Second, "accounts to connect", func() bool {
+		return a.Stats().Idling && b.Stats().Connected
+	})
+	idle.Deliver(testMessage)
+	if e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second); e.A != a {
+		t.Errorf("Event for %v expected %v", e.A.Name, a.Name)
+	}
+
+	// Stop without reading events
+	close(quit)
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop didn't return")
+	}
+	for _, acct := range w.Accounts {
+		if s := acct.Stats(); s.Connected || s.Idling {
+			t.Errorf("%s still online after Stop: %+v", acct.Name, s)
+		}
+	}
+}
+
+func TestWatcherRestart(t *testing.T) {
+	good := newFakeServer(t, true)
+	bad := newFakeServer(t, true)
+
+	a, b := good.account(), bad.account()
+	a.Name, b.Name = "good", "bad"
+	b.Store.Rules.Rules = []*Rule{nil} // panics handling new mail
+	w := New(map[string]*Account{"good": a, "bad": b})
+	w.MaxRestarts = 1
+	w.RestartDelay = 10 * time.Millisecond
+	w.Start(context.Background())
+	defer w.Stop()
+
+	eventc := make(chan Event, 100)
+	go func() {
+		for e := range w.Events() {
+			eventc <- e
+		}
+	}()
+	waitFor(t, 5*time.Second, "accounts to connect", func() bool {
+		return a.Stats().Idling && b.Stats().Idling
+	})
+
+	bad.Deliver(testMessage)
+	waitFor(t, 5*time.Second, "restart", func() bool {
+		s := b.Stats()
+		return s.Panics == 1 && s.Idling
+	})
+	if s := b.Stats(); !strings.Contains(s.LastPanic, "handleUpdate") {
+		t.Errorf("No stack trace in LastPanic: %q", s.LastPanic)
+	}
+
+	bad.Deliver(testMessage)
+	waitFor(t, 5*time.Second, "failed", func() bool {
+		return b.Stats().State == Failed
+	})
+	if s := b.Stats(); s.Panics != 2 {
+		t.Errorf("Unexpected stats after failing: %+v", s)
+	}
+
+	// The other account is unaffected
+	good.Deliver(testMessage)
+	if e := waitEvent(t, eventc, CheckMailEvent, 5*time.Second); e.A != a {
+		t.Errorf("Event for %v expected %v", e.A.Name, a.Name)
+	}
+	// Once it's counted the new mail
+	waitFor(t, 5*time.Second, "good account to idle", func() bool {
+		return a.Stats().Idling
+	})
+	if s := a.Stats(); s.Panics != 0 || s.Reconnects != 0 {
+		t.Errorf("Unexpected stats for good account: %+v", s)
+	}
+}